@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/peergoim/signaling-server/internal/types"
+	"google.golang.org/grpc/codes"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"nhooyr.io/websocket"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dialParallelism 同时建立的连接数量
+	dialParallelism = 64
+	readLimit       = 1 << 24
+	// statusClientTimeout 超过Scenario.Timeout仍未收到响应
+	statusClientTimeout = "ClientTimeout"
+	// statusTransportError 请求发送失败或http请求出错
+	statusTransportError = "TransportError"
+)
+
+// recorder 一个worker的统计，结束后合并，压测期间不需要加锁
+type recorder struct {
+	latencies []time.Duration
+	statuses  map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{statuses: make(map[string]int)}
+}
+
+func (r *recorder) record(status string, latency time.Duration) {
+	r.statuses[status]++
+	if status == codes.OK.String() {
+		r.latencies = append(r.latencies, latency)
+	}
+}
+
+func (r *recorder) merge(other *recorder) {
+	r.latencies = append(r.latencies, other.latencies...)
+	for status, n := range other.statuses {
+		r.statuses[status] += n
+	}
+}
+
+type bench struct {
+	scenario *Scenario
+	baseUrl  string
+	payloads map[string][]byte
+}
+
+func newBench(scenario *Scenario, baseUrl string) *bench {
+	b := &bench{scenario: scenario, baseUrl: strings.TrimSuffix(baseUrl, "/"), payloads: make(map[string][]byte)}
+	r := rand.New(rand.NewSource(scenario.Seed))
+	for _, c := range scenario.Calls {
+		payload := make([]byte, c.PayloadSize)
+		r.Read(payload)
+		b.payloads[c.Method] = payload
+	}
+	return b
+}
+
+func (b *bench) wsUrl(peerId string) string {
+	u := strings.Replace(b.baseUrl, "http", "ws", 1)
+	return u + "/ws?peerId=" + url.QueryEscape(peerId)
+}
+
+func peerIdOf(i int) string {
+	return fmt.Sprintf("bench-peer-%d", i)
+}
+
+// dialAll 并发建立n个websocket连接
+func (b *bench) dialAll(ctx context.Context, n int, peerId func(i int) string) ([]*websocket.Conn, error) {
+	conns := make([]*websocket.Conn, n)
+	errs := make(chan error, n)
+	sem := make(chan struct{}, dialParallelism)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			conn, _, err := websocket.Dial(ctx, b.wsUrl(peerId(i)), nil)
+			if err != nil {
+				errs <- fmt.Errorf("dial %s: %w", peerId(i), err)
+				return
+			}
+			conn.SetReadLimit(readLimit)
+			conns[i] = conn
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		closeAll(conns)
+		return nil, err
+	}
+	return conns, nil
+}
+
+func closeAll(conns []*websocket.Conn) {
+	for _, conn := range conns {
+		if conn != nil {
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+		}
+	}
+}
+
+// servePeer 被调用peer，按场景中的方法回复
+func (b *bench) servePeer(ctx context.Context, conn *websocket.Conn) {
+	for {
+		typ, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		if typ != websocket.MessageBinary {
+			continue
+		}
+		request := &types.CallRequest{}
+		if err = request.FromBytes(data); err != nil {
+			continue
+		}
+		pattern := b.scenario.patternOf(request.Method)
+		if pattern != nil && pattern.NoReply {
+			continue
+		}
+		response := &types.CallResponse{CallId: request.CallId, Method: request.Method, Status: codes.OK, Data: request.Data}
+		if pattern == nil || pattern.ReplyDelay <= 0 {
+			_ = conn.Write(ctx, websocket.MessageText, response.ToBytes())
+			continue
+		}
+		go func() {
+			time.Sleep(time.Millisecond * time.Duration(pattern.ReplyDelay))
+			_ = conn.Write(ctx, websocket.MessageText, response.ToBytes())
+		}()
+	}
+}
+
+// wsCaller 通过websocket发起调用的peer，多个worker共用一个连接
+type wsCaller struct {
+	conn    *websocket.Conn
+	lock    sync.Mutex
+	pending map[string]chan *types.CallResponse
+}
+
+func (c *wsCaller) loopRead(ctx context.Context) {
+	for {
+		typ, data, err := c.conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		if typ != websocket.MessageText {
+			continue
+		}
+		response := &types.CallResponse{}
+		if err = response.FromBytes(data); err != nil {
+			continue
+		}
+		c.lock.Lock()
+		ch, ok := c.pending[response.CallId]
+		delete(c.pending, response.CallId)
+		c.lock.Unlock()
+		if ok {
+			ch <- response
+		}
+	}
+}
+
+func (c *wsCaller) call(ctx context.Context, request *types.CallRequest, timeout time.Duration) string {
+	ch := make(chan *types.CallResponse, 1)
+	c.lock.Lock()
+	c.pending[request.CallId] = ch
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, request.CallId)
+		c.lock.Unlock()
+	}()
+	if err := c.conn.Write(ctx, websocket.MessageBinary, request.ToBytes()); err != nil {
+		return statusTransportError
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case response := <-ch:
+		return response.Status.String()
+	case <-timer.C:
+		return statusClientTimeout
+	}
+}
+
+func (b *bench) httpCall(ctx context.Context, client *http.Client, request *types.CallRequest) string {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseUrl+"/call", bytes.NewReader(request.ToBytes()))
+	if err != nil {
+		return statusTransportError
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return statusClientTimeout
+		}
+		return statusTransportError
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return statusTransportError
+	}
+	response := &types.CallResponse{}
+	if err = response.FromBytes(data); err != nil {
+		return statusTransportError
+	}
+	return response.Status.String()
+}
+
+// limiter 限制一个caller每秒的调用数量，rate为0时不限制
+func limiter(ctx context.Context, rate int) <-chan time.Time {
+	if rate <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	go func() {
+		<-ctx.Done()
+		ticker.Stop()
+	}()
+	return ticker.C
+}
+
+// worker 在deadline之前不断发起调用；seed由场景Seed和worker序号决定，调用序列可以复现
+func (b *bench) worker(ctx context.Context, id string, seed int64, deadline time.Time, ticks <-chan time.Time,
+	call func(request *types.CallRequest) string) *recorder {
+	rec := newRecorder()
+	r := rand.New(rand.NewSource(seed))
+	for n := 0; time.Now().Before(deadline); n++ {
+		if ticks != nil {
+			select {
+			case <-ticks:
+			case <-ctx.Done():
+				return rec
+			}
+		}
+		pattern := b.scenario.pattern(r)
+		request := &types.CallRequest{
+			PeerId: peerIdOf(r.Intn(b.scenario.Peers)),
+			CallId: fmt.Sprintf("%s-%d", id, n),
+			Method: pattern.Method,
+			Data:   b.payloads[pattern.Method],
+		}
+		start := time.Now()
+		status := call(request)
+		rec.record(status, time.Since(start))
+	}
+	return rec
+}
+
+// run 建立连接，压测Duration秒后返回统计
+func (b *bench) run(ctx context.Context) (*recorder, time.Duration, error) {
+	s := b.scenario
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	peers, err := b.dialAll(ctx, s.Peers, peerIdOf)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer closeAll(peers)
+	for _, conn := range peers {
+		go b.servePeer(ctx, conn)
+	}
+	callerConns, err := b.dialAll(ctx, s.Callers, func(i int) string { return fmt.Sprintf("bench-caller-%d", i) })
+	if err != nil {
+		return nil, 0, err
+	}
+	defer closeAll(callerConns)
+	// 等待服务端完成注册
+	time.Sleep(500 * time.Millisecond)
+
+	timeout := time.Second * time.Duration(s.Timeout)
+	start := time.Now()
+	deadline := start.Add(time.Second * time.Duration(s.Duration))
+	results := make(chan *recorder)
+	workers := 0
+	for i, conn := range callerConns {
+		caller := &wsCaller{conn: conn, pending: make(map[string]chan *types.CallResponse)}
+		go caller.loopRead(ctx)
+		ticks := limiter(ctx, s.Rate)
+		for j := 0; j < s.Concurrency; j++ {
+			workers++
+			id := fmt.Sprintf("ws-%d-%d", i, j)
+			seed := s.Seed + int64(workers)
+			go func() {
+				results <- b.worker(ctx, id, seed, deadline, ticks, func(request *types.CallRequest) string {
+					return caller.call(ctx, request, timeout)
+				})
+			}()
+		}
+	}
+	client := &http.Client{Timeout: timeout}
+	for i := 0; i < s.HttpCallers; i++ {
+		workers++
+		id := fmt.Sprintf("http-%d", i)
+		seed := s.Seed + int64(workers)
+		ticks := limiter(ctx, s.Rate)
+		go func() {
+			results <- b.worker(ctx, id, seed, deadline, ticks, func(request *types.CallRequest) string {
+				return b.httpCall(ctx, client, request)
+			})
+		}()
+	}
+	total := newRecorder()
+	for i := 0; i < workers; i++ {
+		total.merge(<-results)
+	}
+	return total, time.Since(start), nil
+}
@@ -0,0 +1,111 @@
+// signalbench 信令服务压测工具
+// 启动模拟peer连接到服务端（或进程内启动的服务端），按场景文件通过 /ws 和 /call 发起调用，
+// 输出吞吐量、延迟分位数、超时率和服务端内存
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/handler/wslogic"
+	"github.com/peergoim/signaling-server/internal/server"
+	"github.com/peergoim/signaling-server/internal/svc"
+	"github.com/zeromicro/go-zero/core/conf"
+	"net"
+	"os"
+)
+
+var (
+	scenarioPath = flag.String("s", "cmd/signalbench/scenarios/basic.yaml", "scenario file path")
+	serverUrl    = flag.String("server", "", "server url, overrides the scenario, e.g. http://127.0.0.1:21480")
+	jsonOutput   = flag.Bool("json", false, "print the report as json")
+)
+
+func main() {
+	flag.Parse()
+	s, err := LoadScenario(*scenarioPath)
+	if err != nil {
+		fatalf("load scenario: %v", err)
+	}
+	if *serverUrl != "" {
+		s.Server = *serverUrl
+	}
+	baseUrl := s.Server
+	var readMemory func() (*MemoryReport, error)
+	switch {
+	case baseUrl == "":
+		if baseUrl, err = startServer(s); err != nil {
+			fatalf("start server: %v", err)
+		}
+		readMemory = inProcessMemory
+	case s.AdminToken != "":
+		readMemory = remoteMemory(baseUrl, s.AdminToken)
+	}
+	var sampler *memorySampler
+	if readMemory != nil {
+		sampler = newMemorySampler(readMemory)
+	}
+	rec, elapsed, err := newBench(s, baseUrl).run(context.Background())
+	if err != nil {
+		fatalf("run scenario: %v", err)
+	}
+	report := newReport(s, baseUrl, rec, elapsed)
+	if sampler != nil {
+		report.Memory = sampler.stop()
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(report)
+		return
+	}
+	report.Print(os.Stdout)
+}
+
+// startServer 在进程内启动服务端，监听本机的随机端口
+func startServer(s *Scenario) (string, error) {
+	c := &config.Config{}
+	var err error
+	if s.Config != "" {
+		err = conf.Load(s.Config, c)
+	} else {
+		err = conf.LoadFromYamlBytes([]byte("Mode: pro\nWebSocket: {}\n"), c)
+	}
+	if err != nil {
+		return "", err
+	}
+	// 只监听本机，不启用tls；只输出错误日志，避免影响压测结果
+	c.WebSocket.Tls.Enabled = false
+	c.Log.Level = "error"
+	if err = c.Validate(); err != nil {
+		return "", err
+	}
+	if s.Timeout <= c.WebSocket.CallTimeout {
+		return "", fmt.Errorf("scenario timeout %ds must be greater than the server call timeout %ds", s.Timeout, c.WebSocket.CallTimeout)
+	}
+	// 直接在监听的随机端口上提供服务，端口不会被其他进程抢占
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	c.WebSocket.ListenOn = listener.Addr().String()
+	c.MustSetup()
+	svcCtx := svc.NewServiceContext(c)
+	gin.SetMode(gin.ReleaseMode)
+	ws, err := server.NewWebSocketServer(svcCtx, wslogic.New(svcCtx, wslogic.Hooks{}))
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		fatalf("serve: %v", ws.Serve(listener))
+	}()
+	return "http://" + c.WebSocket.ListenOn, nil
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
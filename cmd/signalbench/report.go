@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+)
+
+type Report struct {
+	Scenario   string         `json:"scenario"`
+	Server     string         `json:"server"`
+	Peers      int            `json:"peers"`
+	Callers    int            `json:"callers"`
+	DurationMs int64          `json:"durationMs"`
+	Calls      int            `json:"calls"`
+	Throughput float64        `json:"throughput"` // 每秒完成的调用数量
+	Statuses   map[string]int `json:"statuses"`
+	// TimeoutRate 服务端返回DeadlineExceeded和客户端等待超时的比例
+	TimeoutRate float64       `json:"timeoutRate"`
+	Latency     LatencyReport `json:"latency"` // 成功调用的延迟
+	Memory      *MemoryReport `json:"memory,omitempty"`
+}
+
+// LatencyReport 单位：毫秒
+type LatencyReport struct {
+	P50 float64 `json:"p50Ms"`
+	P90 float64 `json:"p90Ms"`
+	P99 float64 `json:"p99Ms"`
+	Max float64 `json:"maxMs"`
+}
+
+// MemoryReport 服务端内存，进程内启动服务端时包含模拟peer的占用
+type MemoryReport struct {
+	HeapAlloc     uint64 `json:"heapAlloc"`
+	PeakHeapAlloc uint64 `json:"peakHeapAlloc"`
+	Sys           uint64 `json:"sys"`
+	NumGC         uint32 `json:"numGC"`
+	InProcess     bool   `json:"inProcess"`
+}
+
+func newReport(s *Scenario, server string, rec *recorder, elapsed time.Duration) *Report {
+	calls := 0
+	for _, n := range rec.statuses {
+		calls += n
+	}
+	r := &Report{
+		Scenario:   s.Name,
+		Server:     server,
+		Peers:      s.Peers,
+		Callers:    s.Callers + s.HttpCallers,
+		DurationMs: elapsed.Milliseconds(),
+		Calls:      calls,
+		Throughput: float64(calls) / elapsed.Seconds(),
+		Statuses:   rec.statuses,
+	}
+	if calls > 0 {
+		timeouts := rec.statuses[codes.DeadlineExceeded.String()] + rec.statuses[statusClientTimeout]
+		r.TimeoutRate = float64(timeouts) / float64(calls)
+	}
+	latencies := rec.latencies
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if n := len(latencies); n > 0 {
+		r.Latency = LatencyReport{
+			P50: milliseconds(latencies[n*50/100]),
+			P90: milliseconds(latencies[n*90/100]),
+			P99: milliseconds(latencies[n*99/100]),
+			Max: milliseconds(latencies[n-1]),
+		}
+	}
+	return r
+}
+
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "scenario:     %s\n", r.Scenario)
+	fmt.Fprintf(w, "server:       %s\n", r.Server)
+	fmt.Fprintf(w, "peers:        %d callee, %d caller\n", r.Peers, r.Callers)
+	fmt.Fprintf(w, "duration:     %s\n", time.Duration(r.DurationMs)*time.Millisecond)
+	fmt.Fprintf(w, "calls:        %d (%.1f/s)\n", r.Calls, r.Throughput)
+	fmt.Fprintf(w, "timeout rate: %.2f%%\n", r.TimeoutRate*100)
+	fmt.Fprintf(w, "latency:      p50 %.2fms, p90 %.2fms, p99 %.2fms, max %.2fms\n", r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max)
+	statuses := make([]string, 0, len(r.Statuses))
+	for status := range r.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(w, "  %-20s %d\n", status, r.Statuses[status])
+	}
+	if m := r.Memory; m != nil {
+		scope := "remote"
+		if m.InProcess {
+			scope = "in-process, includes simulated peers"
+		}
+		fmt.Fprintf(w, "memory:       heap %s (peak %s), sys %s, gc %d (%s)\n",
+			formatBytes(m.HeapAlloc), formatBytes(m.PeakHeapAlloc), formatBytes(m.Sys), m.NumGC, scope)
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func formatBytes(n uint64) string {
+	return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20))
+}
+
+// memorySampler 定期读取服务端内存，记录峰值
+type memorySampler struct {
+	read    func() (*MemoryReport, error)
+	peak    uint64
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newMemorySampler(read func() (*MemoryReport, error)) *memorySampler {
+	m := &memorySampler{read: read, done: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(m.stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if r, err := m.read(); err == nil && r.HeapAlloc > m.peak {
+					m.peak = r.HeapAlloc
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// stop 停止采样，返回最后一次读取的结果
+func (m *memorySampler) stop() *MemoryReport {
+	close(m.done)
+	<-m.stopped
+	r, err := m.read()
+	if err != nil {
+		return nil
+	}
+	if m.peak > r.HeapAlloc {
+		r.PeakHeapAlloc = m.peak
+	} else {
+		r.PeakHeapAlloc = r.HeapAlloc
+	}
+	return r
+}
+
+func inProcessMemory() (*MemoryReport, error) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return &MemoryReport{HeapAlloc: stats.HeapAlloc, Sys: stats.Sys, NumGC: stats.NumGC, InProcess: true}, nil
+}
+
+// remoteMemory 从管理接口 /admin/metrics 读取expvar中的memstats
+func remoteMemory(baseUrl string, token string) func() (*MemoryReport, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return func() (*MemoryReport, error) {
+		req, err := http.NewRequest(http.MethodGet, baseUrl+"/admin/metrics", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("read metrics: %s", resp.Status)
+		}
+		var metrics struct {
+			Memstats runtime.MemStats `json:"memstats"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&metrics); err != nil {
+			return nil, err
+		}
+		return &MemoryReport{HeapAlloc: metrics.Memstats.HeapAlloc, Sys: metrics.Memstats.Sys, NumGC: metrics.Memstats.NumGC}, nil
+	}
+}
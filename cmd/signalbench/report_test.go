@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"google.golang.org/grpc/codes"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewReport(t *testing.T) {
+	rec := newRecorder()
+	// 1ms..100ms 的成功调用，乱序记录
+	for _, i := range rand.New(rand.NewSource(1)).Perm(100) {
+		rec.record(codes.OK.String(), time.Duration(i+1)*time.Millisecond)
+	}
+	other := newRecorder()
+	for i := 0; i < 15; i++ {
+		other.record(codes.DeadlineExceeded.String(), time.Second)
+	}
+	for i := 0; i < 5; i++ {
+		other.record(statusClientTimeout, time.Second)
+	}
+	other.record(statusTransportError, 0)
+	rec.merge(other)
+
+	s := &Scenario{Name: "test", Peers: 3, Callers: 2, HttpCallers: 1}
+	r := newReport(s, "http://127.0.0.1", rec, 2*time.Second)
+	if r.Calls != 121 || r.Callers != 3 || r.Throughput != 60.5 {
+		t.Fatalf("unexpected totals: %+v", r)
+	}
+	// 超时包括服务端返回的DeadlineExceeded和客户端等待超时
+	if r.TimeoutRate != 20.0/121 {
+		t.Fatalf("unexpected timeout rate %f", r.TimeoutRate)
+	}
+	// 只统计成功调用的延迟
+	expected := LatencyReport{P50: 51, P90: 91, P99: 100, Max: 100}
+	if r.Latency != expected {
+		t.Fatalf("expected latency %+v, got %+v", expected, r.Latency)
+	}
+
+	var out bytes.Buffer
+	r.Print(&out)
+	for _, want := range []string{"calls:        121 (60.5/s)", "timeout rate: 16.53%", "p50 51.00ms", "ClientTimeout        5"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in the report:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestNewReportWithoutCalls(t *testing.T) {
+	r := newReport(&Scenario{}, "", newRecorder(), time.Second)
+	if r.Calls != 0 || r.TimeoutRate != 0 || r.Latency != (LatencyReport{}) {
+		t.Fatalf("an empty run should report zeros, got %+v", r)
+	}
+}
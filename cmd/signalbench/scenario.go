@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"github.com/zeromicro/go-zero/core/conf"
+	"math/rand"
+)
+
+var ErrInvalidScenario = errors.New("scenario needs at least one peer, one caller and one call pattern with a positive weight")
+
+// Scenario 压测场景，相同的场景文件和Seed生成相同的调用序列
+type Scenario struct {
+	Name string `json:",optional"`
+	// 服务端地址，如 http://127.0.0.1:21480；为空时在进程内启动服务端
+	Server string `json:",optional"`
+	// 进程内服务端的配置文件，为空时使用默认配置；ListenOn会被替换为随机端口
+	Config string `json:",optional"`
+	// 服务端的管理接口token，用于从 /admin/metrics 读取内存统计
+	AdminToken string `json:",optional"`
+
+	Seed        int64 `json:",default=1"`
+	Peers       int   `json:",default=100"` // 被调用的模拟peer数量
+	Callers     int   `json:",default=10"`  // 通过websocket发起调用的模拟peer数量
+	HttpCallers int   `json:",optional"`    // 通过 /call 发起调用的并发数
+	Concurrency int   `json:",default=8"`   // 每个websocket caller同时等待响应的调用数量
+	Rate        int   `json:",optional"`    // 每个caller每秒最多发起的调用数量，0为不限制
+	Duration    int   `json:",default=10"`  // 压测时长，单位：秒
+	Timeout     int   `json:",default=30"`  // 客户端等待响应的最长时间，应大于服务端的CallTimeout，单位：秒
+
+	Calls []CallPattern
+}
+
+// CallPattern 一类调用，按Weight随机选择
+type CallPattern struct {
+	Method      string
+	Weight      int  `json:",default=1"`
+	PayloadSize int  `json:",default=64"` // 请求数据的长度，单位：字节
+	ReplyDelay  int  `json:",optional"`   // 被调用peer回复前的延迟，单位：毫秒
+	NoReply     bool `json:",optional"`   // 被调用peer不回复，用于测试超时
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	s := &Scenario{}
+	if err := conf.Load(path, s); err != nil {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Scenario) Validate() error {
+	if s.Peers <= 0 || s.Callers+s.HttpCallers <= 0 || s.Concurrency <= 0 || len(s.Calls) == 0 {
+		return ErrInvalidScenario
+	}
+	for _, c := range s.Calls {
+		if c.Method == "" || c.Weight <= 0 {
+			return ErrInvalidScenario
+		}
+	}
+	return nil
+}
+
+// pattern 按权重随机选择调用
+func (s *Scenario) pattern(r *rand.Rand) *CallPattern {
+	total := 0
+	for _, c := range s.Calls {
+		total += c.Weight
+	}
+	n := r.Intn(total)
+	for i := range s.Calls {
+		if n < s.Calls[i].Weight {
+			return &s.Calls[i]
+		}
+		n -= s.Calls[i].Weight
+	}
+	return &s.Calls[len(s.Calls)-1]
+}
+
+// patternOf 被调用peer按方法名查找回复方式
+func (s *Scenario) patternOf(method string) *CallPattern {
+	for i := range s.Calls {
+		if s.Calls[i].Method == method {
+			return &s.Calls[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBundledScenarios(t *testing.T) {
+	paths, err := filepath.Glob("scenarios/*.yaml")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("expected bundled scenarios, got %v, %v", paths, err)
+	}
+	for _, path := range paths {
+		if _, err := LoadScenario(path); err != nil {
+			t.Fatalf("load %s: %v", path, err)
+		}
+	}
+}
+
+func TestScenarioValidate(t *testing.T) {
+	valid := func() *Scenario {
+		return &Scenario{Peers: 1, Callers: 1, Concurrency: 1, Calls: []CallPattern{{Method: "echo", Weight: 1}}}
+	}
+	tests := []struct {
+		name   string
+		change func(s *Scenario)
+		valid  bool
+	}{
+		{"valid", func(s *Scenario) {}, true},
+		{"http callers only", func(s *Scenario) { s.Callers, s.HttpCallers = 0, 2 }, true},
+		{"no peers", func(s *Scenario) { s.Peers = 0 }, false},
+		{"no callers", func(s *Scenario) { s.Callers = 0 }, false},
+		{"no concurrency", func(s *Scenario) { s.Concurrency = 0 }, false},
+		{"no calls", func(s *Scenario) { s.Calls = nil }, false},
+		{"empty method", func(s *Scenario) { s.Calls[0].Method = "" }, false},
+		{"zero weight", func(s *Scenario) { s.Calls[0].Weight = 0 }, false},
+		{"negative weight", func(s *Scenario) { s.Calls = append(s.Calls, CallPattern{Method: "x", Weight: -1}) }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := valid()
+			test.change(s)
+			err := s.Validate()
+			if test.valid && err != nil {
+				t.Fatalf("expected valid, got %v", err)
+			}
+			if !test.valid && !errors.Is(err, ErrInvalidScenario) {
+				t.Fatalf("expected ErrInvalidScenario, got %v", err)
+			}
+		})
+	}
+}
+
+func TestScenarioPatternWeights(t *testing.T) {
+	s := &Scenario{Calls: []CallPattern{{Method: "a", Weight: 7}, {Method: "b", Weight: 2}, {Method: "c", Weight: 1}}}
+	const n = 100000
+	counts := make(map[string]int)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		counts[s.pattern(r).Method]++
+	}
+	for _, c := range s.Calls {
+		expected := float64(c.Weight) / 10
+		if got := float64(counts[c.Method]) / n; math.Abs(got-expected) > 0.01 {
+			t.Fatalf("method %s: expected ratio %.2f, got %.3f", c.Method, expected, got)
+		}
+	}
+	// 相同的Seed生成相同的调用序列
+	r1, r2 := rand.New(rand.NewSource(42)), rand.New(rand.NewSource(42))
+	for i := 0; i < 100; i++ {
+		if s.pattern(r1) != s.pattern(r2) {
+			t.Fatal("the same seed should select the same patterns")
+		}
+	}
+	if s.patternOf("b") != &s.Calls[1] || s.patternOf("nope") != nil {
+		t.Fatal("unexpected pattern lookup by method")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/peergoim/signaling-server/internal/event"
+	"github.com/peergoim/signaling-server/internal/types"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// adminClient 访问服务端的 /admin 管理接口
+type adminClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+func newAdminClient(server string, token string, timeout time.Duration) (*adminClient, error) {
+	if token == "" {
+		return nil, errors.New("--token or SIGNALCTL_TOKEN is required for admin commands")
+	}
+	return &adminClient{server: strings.TrimSuffix(server, "/"), token: token, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// do 发送请求，状态码不是200时返回服务端的错误信息
+func (a *adminClient) do(method string, path string) (*http.Response, error) {
+	req, err := http.NewRequest(method, a.server+"/admin"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, e.Error)
+		}
+		return nil, errors.New(resp.Status)
+	}
+	return resp, nil
+}
+
+func runPeers(args []string) error {
+	fs := flag.NewFlagSet("peers", flag.ExitOnError)
+	server, token := serverFlag(fs), tokenFlag(fs)
+	jsonOutput := fs.Bool("json", false, "print the response as json")
+	_ = fs.Parse(args)
+	admin, err := newAdminClient(*server, *token, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	resp, err := admin.do(http.MethodGet, "/peers")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Peers []types.PeerInfo `json:"peers"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tDEVICE\tCLIENT IP\tCONNECTED\tMETHODS")
+	for _, peer := range result.Peers {
+		for _, d := range peer.Devices {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", peer.PeerId, orDash(d.DeviceId), orDash(d.ClientIp),
+				time.Since(d.ConnectedAt).Truncate(time.Second), orDash(strings.Join(d.Methods, ",")))
+		}
+	}
+	return w.Flush()
+}
+
+func runKick(args []string) error {
+	fs := flag.NewFlagSet("kick", flag.ExitOnError)
+	server, token := serverFlag(fs), tokenFlag(fs)
+	peerId := fs.String("peer", "", "peer id (required)")
+	deviceId := fs.String("device", "", "only kick this device")
+	_ = fs.Parse(args)
+	if *peerId == "" {
+		fs.Usage()
+		return errors.New("--peer is required")
+	}
+	admin, err := newAdminClient(*server, *token, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	path := "/peers/" + url.PathEscape(*peerId)
+	if *deviceId != "" {
+		path += "?deviceId=" + url.QueryEscape(*deviceId)
+	}
+	resp, err := admin.do(http.MethodDelete, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Kicked int `json:"kicked"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Printf("kicked %d connections of %s\n", result.Kicked, *peerId)
+	return nil
+}
+
+// runWatch 读取 /admin/presence 的sse事件流，直到连接断开
+func runWatch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	server, token := serverFlag(fs), tokenFlag(fs)
+	jsonOutput := fs.Bool("json", false, "print events as json lines")
+	_ = fs.Parse(args)
+	admin, err := newAdminClient(*server, *token, 0)
+	if err != nil {
+		return err
+	}
+	resp, err := admin.do(http.MethodGet, "/presence")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		e := event.Event{}
+		if json.Unmarshal([]byte(data), &e) != nil || e.Type == "" {
+			// ready事件
+			continue
+		}
+		if *jsonOutput {
+			fmt.Println(data)
+			continue
+		}
+		peer := e.PeerId
+		if e.DeviceId != "" {
+			peer += "/" + e.DeviceId
+		}
+		line := fmt.Sprintf("%s %-16s %s %s", e.Time.Format("15:04:05.000"), e.Type, peer, orDash(e.ClientIp))
+		if e.Type == event.TypeDisconnect {
+			line += fmt.Sprintf(" after %s", time.Duration(e.DurationMs)*time.Millisecond)
+		}
+		fmt.Println(line)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("presence stream closed")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
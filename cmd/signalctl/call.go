@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/peergoim/signaling-server/internal/types"
+	"github.com/peergoim/signaling-server/internal/utils"
+	"google.golang.org/grpc/codes"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// runCall 通过 /call 接口匿名调用peer，响应数据输出到stdout，状态不是OK时退出码为1
+func runCall(args []string) error {
+	fs := flag.NewFlagSet("call", flag.ExitOnError)
+	server := serverFlag(fs)
+	peerId := fs.String("peer", "", "peer id, or peerId/deviceId (required)")
+	method := fs.String("method", "", "method (required)")
+	data := fs.String("data", "", "request data, @file reads a file and @- reads stdin")
+	timeout := fs.Duration("timeout", 30*time.Second, "http timeout")
+	_ = fs.Parse(args)
+	if *peerId == "" || *method == "" {
+		fs.Usage()
+		return errors.New("--peer and --method are required")
+	}
+	body, err := readData(*data)
+	if err != nil {
+		return err
+	}
+	request := &types.CallRequest{PeerId: *peerId, CallId: utils.RandomId(), Method: *method, Data: body}
+	httpClient := &http.Client{Timeout: *timeout}
+	resp, err := httpClient.Post(strings.TrimSuffix(*server, "/")+"/call", "application/json", bytes.NewReader(request.ToBytes()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	response := &types.CallResponse{}
+	if err = response.FromBytes(raw); err != nil {
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, raw)
+	}
+	_, _ = os.Stdout.Write(response.Data)
+	if len(response.Data) > 0 && response.Data[len(response.Data)-1] != '\n' {
+		fmt.Println()
+	}
+	if response.Status != codes.OK {
+		return fmt.Errorf("call failed: %s", response.Status)
+	}
+	return nil
+}
+
+// readData 解析 --data：@file 读取文件，@- 读取stdin，其他为原始数据
+func readData(data string) ([]byte, error) {
+	switch {
+	case data == "@-":
+		return io.ReadAll(os.Stdin)
+	case strings.HasPrefix(data, "@"):
+		return os.ReadFile(data[1:])
+	default:
+		return []byte(data), nil
+	}
+}
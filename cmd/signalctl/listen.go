@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/peergoim/signaling-server/pkg/client"
+	"github.com/zeromicro/go-zero/core/conf"
+	"google.golang.org/grpc/codes"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
+)
+
+// Script listen按方法返回的脚本化回复，没有匹配的方法时按Default处理
+type Script struct {
+	Replies []ScriptReply `json:",optional"`
+	// Default 没有匹配的方法时：echo原样返回请求数据，unimplemented返回Unimplemented
+	Default string `json:",default=echo,options=echo|unimplemented"`
+}
+
+type ScriptReply struct {
+	Method string // 方法名，支持 path.Match 通配符
+	Status string `json:",default=OK"` // 状态码名称，如 OK、NotFound、PermissionDenied
+	Data   string `json:",optional"`
+	Delay  int    `json:",optional"` // 回复前等待的时间，单位：毫秒
+
+	code codes.Code
+}
+
+func loadScript(file string) (*Script, error) {
+	s := &Script{}
+	if file == "" {
+		s.Default = "echo"
+		return s, nil
+	}
+	if err := conf.Load(file, s); err != nil {
+		return nil, err
+	}
+	for i := range s.Replies {
+		r := &s.Replies[i]
+		if _, err := path.Match(r.Method, ""); err != nil || r.Method == "" {
+			return nil, fmt.Errorf("invalid method pattern %q", r.Method)
+		}
+		var ok bool
+		if r.code, ok = parseCode(r.Status); !ok {
+			return nil, fmt.Errorf("invalid status %q of method %s", r.Status, r.Method)
+		}
+	}
+	return s, nil
+}
+
+// parseCode 按名称解析状态码，名称与 codes.Code.String() 相同
+func parseCode(status string) (codes.Code, bool) {
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if c.String() == status {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Script) reply(ctx context.Context, request *client.CallRequest) ([]byte, error) {
+	for _, r := range s.Replies {
+		if ok, _ := path.Match(r.Method, request.Method); !ok {
+			continue
+		}
+		if r.Delay > 0 {
+			select {
+			case <-time.After(time.Duration(r.Delay) * time.Millisecond):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if r.code != codes.OK {
+			return nil, &client.StatusError{Code: r.code, Data: []byte(r.Data)}
+		}
+		return []byte(r.Data), nil
+	}
+	if s.Default == "unimplemented" {
+		return nil, &client.StatusError{Code: codes.Unimplemented}
+	}
+	return request.Data, nil
+}
+
+func runListen(args []string) error {
+	fs := flag.NewFlagSet("listen", flag.ExitOnError)
+	server := serverFlag(fs)
+	peerId := fs.String("peer", "", "peer id to register (required)")
+	deviceId := fs.String("device", "", "device id")
+	scriptFile := fs.String("script", "", "reply script file (yaml or json), echoes the request data by default")
+	_ = fs.Parse(args)
+	if *peerId == "" {
+		fs.Usage()
+		return errors.New("--peer is required")
+	}
+	script, err := loadScript(*scriptFile)
+	if err != nil {
+		return err
+	}
+	c := client.New(*server, client.Options{PeerId: *peerId, DeviceId: *deviceId})
+	c.HandleDefault(func(ctx context.Context, request *client.CallRequest) ([]byte, error) {
+		data, err := script.reply(ctx, request)
+		status := codes.OK
+		var statusErr *client.StatusError
+		if errors.As(err, &statusErr) {
+			status = statusErr.Code
+		}
+		fmt.Printf("%s %s callId=%s data=%q -> %s\n", time.Now().Format("15:04:05.000"), request.Method, request.CallId, request.Data, status)
+		return data, err
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = c.Connect(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "listening as %s, press ctrl+c to exit\n", *peerId)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-signals:
+		return c.Close()
+	case <-c.Done():
+		return c.Err()
+	}
+}
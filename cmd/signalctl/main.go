@@ -0,0 +1,72 @@
+// signalctl 信令服务调试工具
+//
+//	signalctl listen --peer alice [--script replies.yaml]   注册为peer，打印收到的请求并回复
+//	signalctl call --peer alice --method echo --data @body.json
+//	signalctl peers                                        列出在线的peer（管理接口）
+//	signalctl kick --peer alice [--device phone]           踢下线（管理接口）
+//	signalctl watch                                        实时查看peer上下线（管理接口）
+//
+// 服务端地址和管理接口token可以通过 SIGNALCTL_SERVER、SIGNALCTL_TOKEN 环境变量设置
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+const defaultServer = "http://127.0.0.1:21480"
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"listen", "register as a peer and print incoming calls", runListen},
+	{"call", "call a method of a peer", runCall},
+	{"peers", "list online peers", runPeers},
+	{"kick", "disconnect a peer", runKick},
+	{"watch", "watch peers going online and offline", runWatch},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: signalctl <command> [flags]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun 'signalctl <command> -h' for the flags of a command\n")
+}
+
+// serverFlag 所有子命令共用的服务端地址参数
+func serverFlag(fs *flag.FlagSet) *string {
+	server := os.Getenv("SIGNALCTL_SERVER")
+	if server == "" {
+		server = defaultServer
+	}
+	return fs.String("server", server, "server url")
+}
+
+// tokenFlag 管理接口子命令的token参数
+func tokenFlag(fs *flag.FlagSet) *string {
+	return fs.String("token", os.Getenv("SIGNALCTL_TOKEN"), "admin token")
+}
@@ -0,0 +1,177 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/zeromicro/go-zero/core/logx"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	TypeAuth       = "auth"       // 鉴权结果：调用权限、管理接口token、ip过滤、Origin、客户端证书
+	TypeConnect    = "connect"    // peer上线，被会话策略拒绝时Result为deny
+	TypeDisconnect = "disconnect" // peer下线
+	TypeAdmin      = "admin"      // 管理接口的请求
+	TypeCall       = "call"       // 调用完成
+)
+
+const (
+	ResultAllow = "allow"
+	ResultDeny  = "deny"
+)
+
+const redactedMask = "***"
+
+// Record 一条审计记录，不包含请求、响应的数据
+type Record struct {
+	Time          time.Time `json:"time"`
+	Type          string    `json:"type"`
+	Action        string    `json:"action,omitempty"` // auth: call | admin | ip | origin | cert；admin: 请求的路由
+	Result        string    `json:"result,omitempty"` // auth: allow | deny；admin: http状态码；call: 响应状态
+	PeerId        string    `json:"peerId,omitempty"`
+	DeviceId      string    `json:"deviceId,omitempty"`
+	ClientIp      string    `json:"clientIp,omitempty"`
+	Caller        string    `json:"caller,omitempty"` // 调用方的peerId，匿名调用方为空
+	Method        string    `json:"method,omitempty"`
+	CallId        string    `json:"callId,omitempty"`
+	DurationMs    int64     `json:"durationMs,omitempty"`
+	RequestBytes  int       `json:"requestBytes,omitempty"`
+	ResponseBytes int       `json:"responseBytes,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+}
+
+// Logger 审计日志，未启用时Log不做任何事；配置热加载后通过Update切换输出
+type Logger struct {
+	lock   sync.RWMutex
+	config config.AuditConfig
+	writer io.WriteCloser
+	types  map[string]bool
+	redact map[string]bool
+}
+
+// New 创建审计日志，打开输出失败时记录到普通日志中，不丢弃审计记录
+func New(c config.AuditConfig) *Logger {
+	l := &Logger{}
+	l.Update(c)
+	return l
+}
+
+// Update 替换为已经校验过的新配置，输出相关的配置变化时重新打开输出
+func (l *Logger) Update(c config.AuditConfig) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.writer != nil && !c.Enabled || !sameOutput(l.config, c) {
+		l.close()
+	}
+	if c.Enabled && l.writer == nil {
+		writer, err := open(c)
+		if err != nil {
+			logx.Errorf("failed to open audit %s output, audit records go to the log: %v", c.Output, err)
+			writer = logWriter{}
+		}
+		l.writer = writer
+	}
+	l.config = c
+	l.types = toSet(c.Types)
+	l.redact = toSet(c.Redact)
+}
+
+// Close 关闭输出，等待缓冲的记录写入
+func (l *Logger) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.close()
+}
+
+func (l *Logger) close() error {
+	if l.writer == nil {
+		return nil
+	}
+	err := l.writer.Close()
+	l.writer = nil
+	return err
+}
+
+// Log 写入一条记录，Time为空时使用当前时间
+func (l *Logger) Log(r Record) {
+	if l == nil {
+		return
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.writer == nil || (len(l.types) > 0 && !l.types[r.Type]) {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	for field, value := range map[string]*string{
+		"peerId":   &r.PeerId,
+		"deviceId": &r.DeviceId,
+		"clientIp": &r.ClientIp,
+		"caller":   &r.Caller,
+		"callId":   &r.CallId,
+	} {
+		if l.redact[field] && *value != "" {
+			*value = l.redacted(*value)
+		}
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		logx.Errorf("failed to marshal audit record: %v", err)
+		return
+	}
+	if _, err = l.writer.Write(append(data, '\n')); err != nil {
+		logx.Errorf("failed to write audit record: %v", err)
+	}
+}
+
+func (l *Logger) redacted(value string) string {
+	if l.config.RedactMode == config.AuditRedactMask {
+		return redactedMask
+	}
+	sum := sha256.Sum256([]byte(l.config.RedactSalt + value))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+func open(c config.AuditConfig) (io.WriteCloser, error) {
+	if c.Output == config.AuditOutputSyslog {
+		return openSyslog(c)
+	}
+	rule := logx.DefaultRotateRule(c.Path, "-", c.KeepDays, c.Compress)
+	if c.Rotation == "size" {
+		rule = logx.NewSizeLimitRotateRule(c.Path, "-", c.KeepDays, c.MaxSize, c.MaxBackups, c.Compress)
+	}
+	return logx.NewLogger(c.Path, rule, c.Compress)
+}
+
+// sameOutput 两份配置是否使用同一个输出，脱敏、类型等配置不需要重新打开输出
+func sameOutput(a config.AuditConfig, b config.AuditConfig) bool {
+	a.Enabled, a.Types, a.Redact, a.RedactMode, a.RedactSalt = b.Enabled, b.Types, b.Redact, b.RedactMode, b.RedactSalt
+	return reflect.DeepEqual(a, b)
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// logWriter 无法打开审计输出时写入普通日志
+type logWriter struct{}
+
+func (logWriter) Write(data []byte) (int, error) {
+	logx.Info("audit: " + string(data[:len(data)-1]))
+	return len(data), nil
+}
+
+func (logWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/peergoim/signaling-server/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fileConfig(t *testing.T) config.AuditConfig {
+	t.Helper()
+	return config.AuditConfig{
+		Enabled:    true,
+		Output:     config.AuditOutputFile,
+		Path:       filepath.Join(t.TempDir(), "audit.log"),
+		Rotation:   "daily",
+		KeepDays:   7,
+		RedactMode: config.AuditRedactHash,
+	}
+}
+
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	defer f.Close()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		r := Record{}
+		if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("line %q is not a json record: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestLogWritesJsonLines(t *testing.T) {
+	c := fileConfig(t)
+	c.Types = []string{TypeConnect, TypeCall}
+	l := New(c)
+	l.Log(Record{Type: TypeConnect, Result: ResultAllow, PeerId: "alice", ClientIp: "10.0.0.1"})
+	l.Log(Record{Type: TypeAdmin, Action: "GET /admin/peers", Result: "200"})
+	l.Log(Record{Type: TypeCall, Result: "OK", PeerId: "bob", Caller: "alice", Method: "echo", RequestBytes: 5})
+	if err := l.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	records := readRecords(t, c.Path)
+	if len(records) != 2 {
+		t.Fatalf("expected the admin record to be filtered out, got %+v", records)
+	}
+	if r := records[0]; r.Type != TypeConnect || r.PeerId != "alice" || r.ClientIp != "10.0.0.1" || r.Time.IsZero() {
+		t.Fatalf("unexpected connect record: %+v", r)
+	}
+	if r := records[1]; r.Type != TypeCall || r.Caller != "alice" || r.Method != "echo" || r.RequestBytes != 5 {
+		t.Fatalf("unexpected call record: %+v", r)
+	}
+	// 关闭后不再写入
+	l.Log(Record{Type: TypeConnect, PeerId: "late"})
+	if records = readRecords(t, c.Path); len(records) != 2 {
+		t.Fatalf("closed logger should not write, got %d records", len(records))
+	}
+}
+
+func TestRedact(t *testing.T) {
+	c := fileConfig(t)
+	c.Redact = []string{"clientIp", "caller"}
+	c.RedactSalt = "pepper"
+	l := New(c)
+	l.Log(Record{Type: TypeCall, PeerId: "bob", Caller: "alice", ClientIp: "10.0.0.1"})
+	l.Log(Record{Type: TypeCall, PeerId: "bob", Caller: "alice", ClientIp: "10.0.0.2"})
+	c.RedactMode = config.AuditRedactMask
+	l.Update(c)
+	l.Log(Record{Type: TypeCall, PeerId: "bob", Caller: "alice"})
+	_ = l.Close()
+
+	records := readRecords(t, c.Path)
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records after changing the redact mode, got %d", len(records))
+	}
+	first, second := records[0], records[1]
+	if first.PeerId != "bob" || first.Caller == "alice" || first.ClientIp == "10.0.0.1" {
+		t.Fatalf("caller and client ip should be redacted: %+v", first)
+	}
+	if first.Caller != second.Caller || first.ClientIp == second.ClientIp {
+		t.Fatalf("hashes should be stable per value: %+v, %+v", first, second)
+	}
+	if records[2].Caller != redactedMask {
+		t.Fatalf("expected a masked caller, got %+v", records[2])
+	}
+}
+
+func TestUpdateEnablesAndDisables(t *testing.T) {
+	c := fileConfig(t)
+	c.Enabled = false
+	l := New(c)
+	l.Log(Record{Type: TypeConnect, PeerId: "ignored"})
+	if _, err := os.Stat(c.Path); !os.IsNotExist(err) {
+		t.Fatalf("disabled logger should not create the file, got %v", err)
+	}
+
+	c.Enabled = true
+	l.Update(c)
+	l.Log(Record{Type: TypeConnect, PeerId: "alice"})
+	c.Enabled = false
+	l.Update(c)
+	l.Log(Record{Type: TypeConnect, PeerId: "ignored"})
+	if records := readRecords(t, c.Path); len(records) != 1 || records[0].PeerId != "alice" {
+		t.Fatalf("expected only the record written while enabled, got %+v", records)
+	}
+
+	var nilLogger *Logger
+	nilLogger.Log(Record{Type: TypeConnect})
+}
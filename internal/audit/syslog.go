@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"github.com/peergoim/signaling-server/internal/config"
+	"io"
+	"log/syslog"
+)
+
+// openSyslog 以auth类别、info级别写入syslog
+func openSyslog(c config.AuditConfig) (io.WriteCloser, error) {
+	return syslog.Dial(c.SyslogNetwork, c.SyslogAddress, syslog.LOG_AUTH|syslog.LOG_INFO, c.SyslogTag)
+}
@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package audit
+
+import (
+	"errors"
+	"github.com/peergoim/signaling-server/internal/config"
+	"io"
+)
+
+var ErrSyslogUnsupported = errors.New("syslog is not supported on this platform")
+
+func openSyslog(_ config.AuditConfig) (io.WriteCloser, error) {
+	return nil, ErrSyslogUnsupported
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"errors"
+)
+
+const (
+	AuditOutputFile   = "file"
+	AuditOutputSyslog = "syslog"
+
+	AuditRedactHash = "hash" // 替换为加盐的sha256摘要，同一个值的摘要相同，仍然可以关联
+	AuditRedactMask = "mask" // 替换为 ***
+)
+
+// AuditTypes 审计记录的类型
+var AuditTypes = []string{"auth", "connect", "disconnect", "admin", "call"}
+
+// AuditRedactFields 可以脱敏的字段
+var AuditRedactFields = []string{"peerId", "deviceId", "clientIp", "caller", "callId"}
+
+var (
+	ErrInvalidAudit = errors.New("invalid audit, check output, path, rotation, types and redact fields")
+	// ErrAuditRedactSalt 没有盐时peerId、ip等取值有限的字段可以通过穷举还原
+	ErrAuditRedactSalt = errors.New("audit redact mode hash requires a non-empty redact salt")
+)
+
+// AuditConfig 审计日志，每行一条json记录：鉴权结果、peer上下线、管理操作和调用的元数据，不记录请求、响应的数据
+type AuditConfig struct {
+	Enabled bool   `json:",optional"`
+	Output  string `json:",default=file,options=file|syslog"`
+	// Output为file时写入Path，按天（daily）或按大小（size）切割，切割后的文件名带有时间后缀
+	Path       string `json:",default=logs/audit.log"`
+	Rotation   string `json:",default=daily,options=daily|size"`
+	MaxSize    int    `json:",default=100"` // size切割时单个文件的最大大小，单位：MB
+	KeepDays   int    `json:",default=30"`  // 切割后的文件保留天数，0为永久保留
+	MaxBackups int    `json:",optional"`    // size切割时最多保留的文件数，0为不限制
+	Compress   bool   `json:",optional"`    // gzip压缩切割后的文件
+	// Output为syslog时的syslog服务，Network为空时使用本机的syslog
+	SyslogNetwork string `json:",optional"` // udp | tcp
+	SyslogAddress string `json:",optional"`
+	SyslogTag     string `json:",default=signaling-server"`
+	// 记录的类型，为空时记录所有类型
+	Types []string `json:",optional"`
+	// 脱敏的字段，RedactSalt用于hash方式且不能为空，修改后同一个值的摘要会变化
+	Redact     []string `json:",optional"`
+	RedactMode string   `json:",default=hash,options=hash|mask"`
+	RedactSalt string   `json:",optional"`
+}
+
+func (c *AuditConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	switch c.Output {
+	case AuditOutputFile:
+		if c.Path == "" || c.KeepDays < 0 || c.MaxBackups < 0 || (c.Rotation == "size" && c.MaxSize <= 0) {
+			return ErrInvalidAudit
+		}
+	case AuditOutputSyslog:
+		if (c.SyslogNetwork == "") != (c.SyslogAddress == "") {
+			return ErrInvalidAudit
+		}
+	default:
+		return ErrInvalidAudit
+	}
+	if !containsAll(AuditTypes, c.Types) || !containsAll(AuditRedactFields, c.Redact) {
+		return ErrInvalidAudit
+	}
+	if len(c.Redact) > 0 && c.RedactMode != AuditRedactMask && c.RedactSalt == "" {
+		return ErrAuditRedactSalt
+	}
+	return nil
+}
+
+func containsAll(all []string, values []string) bool {
+	for _, v := range values {
+		found := false
+		for _, a := range all {
+			if a == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
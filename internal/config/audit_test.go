@@ -0,0 +1,33 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditRedactNeedsSalt(t *testing.T) {
+	c := AuditConfig{
+		Enabled:    true,
+		Output:     AuditOutputFile,
+		Path:       filepath.Join(t.TempDir(), "audit.log"),
+		Rotation:   "daily",
+		RedactMode: AuditRedactHash,
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("no redacted fields: %v", err)
+	}
+	c.Redact = []string{"clientIp"}
+	if err := c.Validate(); !errors.Is(err, ErrAuditRedactSalt) {
+		t.Fatalf("expected ErrAuditRedactSalt, got %v", err)
+	}
+	c.RedactSalt = "pepper"
+	if err := c.Validate(); err != nil {
+		t.Fatalf("salted hash: %v", err)
+	}
+	// mask方式不需要盐
+	c.RedactMode, c.RedactSalt = AuditRedactMask, ""
+	if err := c.Validate(); err != nil {
+		t.Fatalf("mask: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+const (
+	CallAuthAllow = "allow"
+	CallAuthDeny  = "deny"
+	// AnonymousCaller 在Callers中匹配通过 /call 接口调用的匿名调用方
+	AnonymousCaller = "$anonymous"
+)
+
+var (
+	ErrInvalidCallAuth = errors.New("invalid call auth, actions must be allow or deny and patterns must be valid")
+	// ErrCallAuthUnverifiedCallers peerId由peer连接时自己声明，没有可信的身份时规则可以被冒充绕过
+	ErrCallAuthUnverifiedCallers = errors.New("call auth needs verified caller identity, enable WebSocket.Tls.PeerIdFromCert or set CallAuth.AllowUnverifiedCallers")
+)
+
+// CallAuthConfig 调用权限，按顺序使用第一条匹配的规则，没有匹配的规则时使用DefaultAction
+// 调用方是连接时声明的peerId，需要启用 WebSocket.Tls.PeerIdFromCert 由客户端证书确认身份，
+// 或者在peerId已经由其他方式（如前置的鉴权代理）保证时设置AllowUnverifiedCallers
+type CallAuthConfig struct {
+	Enabled                bool                 `json:",optional"`
+	AllowUnverifiedCallers bool                 `json:",optional"`
+	DefaultAction          string               `json:",default=allow,options=allow|deny"`
+	Rules                  []CallAuthRuleConfig `json:",optional"`
+}
+
+// CallAuthRuleConfig 调用权限规则，各项均支持 path.Match 通配符，为空时匹配所有
+type CallAuthRuleConfig struct {
+	Callers []string `json:",optional"` // 调用方的peerId，匿名调用方为 $anonymous
+	PeerIds []string `json:",optional"` // 被调用的peerId
+	Methods []string `json:",optional"` // 方法，可以带或不带版本，如 upload、upload@2
+	Action  string   `json:",options=allow|deny"`
+}
+
+func (c *CallAuthConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.DefaultAction != "" && c.DefaultAction != CallAuthAllow && c.DefaultAction != CallAuthDeny {
+		return ErrInvalidCallAuth
+	}
+	for _, rule := range c.Rules {
+		if rule.Action != CallAuthAllow && rule.Action != CallAuthDeny {
+			return ErrInvalidCallAuth
+		}
+		for _, patterns := range [][]string{rule.Callers, rule.PeerIds, rule.Methods} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return ErrInvalidCallAuth
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Allowed caller为空表示匿名调用方，未启用时允许所有调用
+func (c *CallAuthConfig) Allowed(caller string, peerId string, method string) bool {
+	if !c.Enabled {
+		return true
+	}
+	if caller == "" {
+		caller = AnonymousCaller
+	}
+	name, _, _ := strings.Cut(method, "@")
+	for _, rule := range c.Rules {
+		if matchAny(rule.Callers, caller) && matchAny(rule.PeerIds, peerId) &&
+			(matchAny(rule.Methods, method) || matchAny(rule.Methods, name)) {
+			return rule.Action == CallAuthAllow
+		}
+	}
+	return c.DefaultAction != CallAuthDeny
+}
+
+// matchAny patterns为空时匹配所有
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCallAuthNeedsVerifiedCallers(t *testing.T) {
+	c := newTestConfig(t)
+	c.CallAuth = CallAuthConfig{Enabled: true, DefaultAction: CallAuthDeny}
+	if err := c.Validate(); !errors.Is(err, ErrCallAuthUnverifiedCallers) {
+		t.Fatalf("expected ErrCallAuthUnverifiedCallers without a verified identity, got %v", err)
+	}
+	// 只开启PeerIdFromCert而没有启用tls，peerId仍然是自己声明的
+	c.WebSocket.Tls.PeerIdFromCert = true
+	if err := c.Validate(); !errors.Is(err, ErrCallAuthUnverifiedCallers) {
+		t.Fatalf("expected ErrCallAuthUnverifiedCallers without tls, got %v", err)
+	}
+	c.CallAuth.AllowUnverifiedCallers = true
+	if err := c.Validate(); err != nil {
+		t.Fatalf("explicitly allowed unverified callers: %v", err)
+	}
+	c.CallAuth = CallAuthConfig{}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("disabled call auth: %v", err)
+	}
+}
@@ -2,14 +2,11 @@ package config
 
 import (
 	"errors"
-	"github.com/fsnotify/fsnotify"
+	"github.com/peergoim/signaling-server/internal/ipfilter"
 	"github.com/zeromicro/go-zero/core/logx"
 	"github.com/zeromicro/go-zero/core/trace"
-	"log"
-	"os"
-	"regexp"
-	"strings"
-	"sync"
+	"net/url"
+	"path"
 )
 
 type CorsConfig struct {
@@ -19,146 +16,299 @@ type CorsConfig struct {
 	AllowMethods     []string `json:",optional"`
 	ExposeHeaders    []string `json:",optional"`
 	AllowCredentials bool     `json:",optional"`
+	// websocket升级时允许的跨站Origin，为空则只允许同源；不会使用AllowOrigins
+	// 支持通配符：带协议时匹配完整Origin（如 https://*.example.com），否则只匹配host（如 *.example.com）
+	OriginPatterns []string `json:",optional"`
+	// dev模式下跳过websocket的Origin校验，需要显式开启
+	DevSkipOriginCheck bool `json:",optional"`
 }
 
-type IpWhitelistConfig struct {
-	Enabled    bool     `json:",optional"`
-	IpList     []string `json:",optional"`
-	File       string   `json:",optional"`
-	ipListLock sync.RWMutex
+// HttpFallbackConfig 无法使用websocket的peer，通过sse或长轮询接收请求，通过http接口返回响应
+type HttpFallbackConfig struct {
+	Enabled           bool `json:",optional"`
+	BufferSize        int  `json:",default=64"` // 每个连接待发送消息的缓冲数量
+	HeartbeatInterval int  `json:",default=15"` // sse心跳间隔，防止代理断开空闲连接，单位：秒
+	PollTimeout       int  `json:",default=25"` // 长轮询无消息时的最长等待时间，单位：秒
+	PollIdleTimeout   int  `json:",default=60"` // 长轮询连接超过此时间未轮询则视为下线，单位：秒
+}
+
+// ProxyConfig 部署在反向代理或负载均衡之后时的客户端ip解析
+type ProxyConfig struct {
+	// 受信任的代理地址（IP或CIDR），只有来自这些地址的请求才会解析 Forwarded / X-Forwarded-For / X-Real-Ip 请求头
+	// 不再识别腾讯云CDN的 Tencent-Acceleration-Domain-Name 请求头，使用CDN时需要把CDN回源地址加入此列表，修改后需要重启
+	TrustedProxies []string `json:",optional"`
+	// 监听端口接受PROXY protocol v1/v2（四层负载均衡），只解析来自受信任代理的头部
+	ProxyProtocol bool `json:",optional"`
+	// 读取PROXY protocol头部的超时时间，单位：秒
+	ProxyProtocolTimeout int `json:",default=5"`
+}
+
+// ResumeConfig 断线重连后恢复会话，peer连接时会收到会话token，断开后在GraceWindow内携带token重连即可恢复
+type ResumeConfig struct {
+	Enabled      bool `json:",optional"`
+	GraceWindow  int  `json:",default=30"` // 断开后会话保留的时间，单位：秒
+	BufferSize   int  `json:",default=64"` // 断开期间最多缓冲的消息数量，超过后请求直接失败
+	FlushTimeout int  `json:",default=5"`  // 恢复会话时发送缓冲消息的最长时间，超时后关闭新连接，单位：秒
+}
+
+// LimitsConfig 消息大小限制，单位：字节
+// 超过单帧限制的消息可以拆分为分片发送，分片在服务端重组为一条完整的消息
+type LimitsConfig struct {
+	MaxInboundFrame  int `json:",default=32768"`   // peer发给服务端的单个websocket消息的最大长度
+	MaxOutboundFrame int `json:",default=32768"`   // 服务端发给peer的单个websocket消息的最大长度，peer支持分片时超过后拆分发送
+	MaxMessageSize   int `json:",default=1048576"` // 重组后单条消息Data的最大长度，也用于限制http请求体
+	// 每个连接正在重组的分片最多占用的内存
+	MaxReassemblyMemory int `json:",default=4194304"`
+	FragmentTimeout     int `json:",default=30"` // 分片未收齐的超时时间，单位：秒
+}
+
+func (c LimitsConfig) Validate() error {
+	if c.MaxInboundFrame <= 0 || c.MaxOutboundFrame <= 0 || c.MaxMessageSize <= 0 ||
+		c.MaxReassemblyMemory <= 0 || c.FragmentTimeout <= 0 {
+		return ErrInvalidLimits
+	}
+	return nil
+}
+
+// MaxBodySize http请求体的最大长度，Data为base64编码，需要留出编码和其他字段的空间
+func (c LimitsConfig) MaxBodySize() int64 {
+	return int64(c.MaxMessageSize)/3*4 + 4096
+}
+
+// WriteQueueConfig 每个websocket连接的发送队列，由单独的goroutine按优先级发送：控制消息 > 响应 > 请求
+type WriteQueueConfig struct {
+	Size int `json:",default=256"` // 队列长度，不含控制消息
+	// 队列满时：drop 直接失败 | block 等待BlockTimeout后失败 | disconnect 断开慢消费者（关闭码4003）
+	Overflow     string `json:",default=block,options=drop|block|disconnect"`
+	BlockTimeout int    `json:",default=5"` // 单位：秒，也是关闭连接时等待队列中的响应发送完成的最长时间
+}
+
+// DispatchConfig websocket连接上收到的请求并发处理，不会因为一个慢请求阻塞其他请求和响应
+type DispatchConfig struct {
+	Concurrency int `json:",default=64"` // 每个连接同时处理（包括排队）的请求数量，超过后返回ResourceExhausted
+	// 按收到的顺序逐个处理的方法，同一连接上同一方法的请求不会并发，支持通配符，如 chat.*
+	OrderedMethods []string `json:",optional"`
+}
+
+const (
+	SessionPolicyMultiple = "multiple" // 允许同一peerId同时存在多个连接
+	SessionPolicyNewest   = "newest"   // 新连接上线后踢掉旧连接
+	SessionPolicyOldest   = "oldest"   // 已有连接在线时拒绝新连接
+)
+
+// SessionPolicyConfig 一类peer的会话策略
+type SessionPolicyConfig struct {
+	PeerIds []string // peerId匹配规则，支持通配符，如 app-*
+	Policy  string   `json:",options=multiple|newest|oldest"`
 }
 
 type WebSocketConfig struct {
-	ListenOn    string             `json:",default=0.0.0.0:21480"`
-	IpWhitelist *IpWhitelistConfig `json:",optional"`
-	CallTimeout int                `json:",default=10"` // 单位：秒
+	ListenOn     string             `json:",default=0.0.0.0:21480"`
+	IpWhitelist  *IpWhitelistConfig `json:",optional"`
+	CallTimeout  int                `json:",default=10"` // 单位：秒
+	HttpFallback HttpFallbackConfig `json:",optional"`
+	Tls          TlsConfig          `json:",optional"`
+	Proxy        ProxyConfig        `json:",optional"`
+	Resume       ResumeConfig
+	Limits       LimitsConfig
+	WriteQueue   WriteQueueConfig
+	Dispatch     DispatchConfig
+	// 同一peerId存在多个连接时的默认策略，SessionPolicies按顺序匹配，第一个匹配的生效
+	SessionPolicy   string                `json:",default=multiple,options=multiple|newest|oldest"`
+	SessionPolicies []SessionPolicyConfig `json:",optional"`
+}
+
+// VirtualPeerConfig 虚拟peer，无法保持websocket连接的后端服务，通过http webhook接收请求
+type VirtualPeerConfig struct {
+	PeerId        string
+	Url           string
+	Secret        string `json:",optional"`    // 用于签名请求体，为空则不签名
+	Timeout       int    `json:",default=5"`   // 单次请求超时，单位：秒
+	MaxRetries    int    `json:",default=2"`   // 失败后的重试次数
+	RetryInterval int    `json:",default=200"` // 首次重试间隔，之后指数增长，单位：毫秒
+}
+
+type AdminConfig struct {
+	Enabled bool   `json:",optional"`
+	Token   string `json:",optional"` // 请求头 Authorization: Bearer <Token>
+}
+
+// EventWebhookConfig 生命周期事件webhook，peer上下线以及（可选的）调用完成事件
+type EventWebhookConfig struct {
+	Enabled       bool     `json:",optional"`
+	Urls          []string `json:",optional"`
+	Secret        string   `json:",optional"`     // 用于签名请求体，为空则不签名
+	CallEvents    bool     `json:",optional"`     // 是否推送调用完成事件
+	BatchSize     int      `json:",default=100"`  // 每批最多事件数
+	FlushInterval int      `json:",default=1000"` // 未满一批时的推送间隔，单位：毫秒
+	Timeout       int      `json:",default=5"`    // 单次推送超时，单位：秒
+	MaxRetries    int      `json:",default=3"`
+	RetryInterval int      `json:",default=500"`  // 首次重试间隔，之后指数增长，单位：毫秒
+	QueueDir      string   `json:",optional"`     // 重试仍失败、或来不及投递的批次落盘目录，每个url一个子目录，为空则丢弃
+	MaxQueueFiles int      `json:",default=1000"` // 每个url落盘批次的最大数量，超过后丢弃最旧的
 }
 
 type Config struct {
-	Mode      string       `json:",default=dev,options=dev|pro"`
-	Cors      CorsConfig   `json:",optional"`
-	Log       logx.LogConf `json:",optional"`
-	Telemetry trace.Config `json:",optional"`
-	WebSocket WebSocketConfig
+	Mode         string       `json:",default=dev,options=dev|pro"`
+	Cors         CorsConfig   `json:",optional"`
+	Log          logx.LogConf `json:",optional"`
+	Telemetry    trace.Config `json:",optional"`
+	Admin        AdminConfig  `json:",optional"`
+	WebSocket    WebSocketConfig
+	VirtualPeers []VirtualPeerConfig `json:",optional"`
+	Events       EventWebhookConfig  `json:",optional"`
+	CallAuth     CallAuthConfig      `json:",optional"`
+	IceServers   []IceServerConfig   `json:",optional"` // $server.iceServers 返回的STUN/TURN服务器
+	Audit        AuditConfig         `json:",optional"`
+	// 按方法校验请求、响应数据
+	Schemas []MethodSchemaConfig `json:",optional"`
 }
 
 var (
-	ErrInvalidMode = errors.New("invalid mode, mode must be in [dev, pro]")
+	ErrInvalidMode          = errors.New("invalid mode, mode must be in [dev, pro]")
+	ErrEmptyAdminToken      = errors.New("admin token must not be empty when admin api is enabled")
+	ErrInvalidVirtualPeer   = errors.New("virtual peer must have peerId and url")
+	ErrDuplicateVirtualPeer = errors.New("duplicate virtual peer id")
+	ErrInvalidEventWebhook  = errors.New("event webhook needs at least one url and a positive batch size")
+	ErrInvalidIpRoute       = errors.New("invalid ip whitelist route, paths must not be empty")
+	ErrInvalidTrustedProxy  = errors.New("invalid trusted proxy, must be an ip or cidr")
+	ErrProxyProtocolNoProxy = errors.New("proxy protocol requires trusted proxies")
+	ErrInvalidSessionPolicy = errors.New("invalid session policy, peerIds must be valid patterns")
+	ErrInvalidLimits        = errors.New("invalid limits, sizes and fragment timeout must be positive")
+	ErrInvalidWriteQueue    = errors.New("invalid write queue, size and block timeout must be positive")
+	ErrInvalidResume        = errors.New("invalid resume, buffer size and flush timeout must be positive")
+	ErrInvalidDispatch      = errors.New("invalid dispatch, concurrency must be positive and ordered methods must be valid patterns")
 )
 
 func (c *Config) Validate() error {
 	if c.Mode != "dev" && c.Mode != "pro" {
 		return ErrInvalidMode
 	}
+	if c.Admin.Enabled && c.Admin.Token == "" {
+		return ErrEmptyAdminToken
+	}
+	peerIds := make(map[string]struct{})
+	for i := range c.VirtualPeers {
+		if e := c.VirtualPeers[i].Validate(); e != nil {
+			return e
+		}
+		if _, ok := peerIds[c.VirtualPeers[i].PeerId]; ok {
+			return ErrDuplicateVirtualPeer
+		}
+		peerIds[c.VirtualPeers[i].PeerId] = struct{}{}
+	}
+	if c.Events.Enabled && (len(c.Events.Urls) == 0 || c.Events.BatchSize <= 0 || c.Events.FlushInterval <= 0) {
+		return ErrInvalidEventWebhook
+	}
+	if e := c.CallAuth.Validate(); e != nil {
+		return e
+	}
+	if c.CallAuth.Enabled && !c.CallAuth.AllowUnverifiedCallers && !(c.WebSocket.Tls.Enabled && c.WebSocket.Tls.PeerIdFromCert) {
+		return ErrCallAuthUnverifiedCallers
+	}
+	if e := c.Audit.Validate(); e != nil {
+		return e
+	}
+	for i := range c.IceServers {
+		if e := c.IceServers[i].Validate(); e != nil {
+			return e
+		}
+	}
 	if e := c.WebSocket.IpWhitelist.Validate(); e != nil {
 		return e
 	}
+	if e := c.WebSocket.Proxy.Validate(); e != nil {
+		return e
+	}
+	if e := c.WebSocket.Tls.Validate(); e != nil {
+		return e
+	}
+	if e := c.WebSocket.Limits.Validate(); e != nil {
+		return e
+	}
+	if c.WebSocket.WriteQueue.Size <= 0 || c.WebSocket.WriteQueue.BlockTimeout <= 0 {
+		return ErrInvalidWriteQueue
+	}
+	if r := c.WebSocket.Resume; r.Enabled && (r.BufferSize <= 0 || r.FlushTimeout <= 0) {
+		return ErrInvalidResume
+	}
+	if e := c.WebSocket.Dispatch.Validate(); e != nil {
+		return e
+	}
+	for _, p := range c.WebSocket.SessionPolicies {
+		if e := p.Validate(); e != nil {
+			return e
+		}
+	}
+	for i := range c.Schemas {
+		if e := c.Schemas[i].Validate(); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// MustSetup 初始化日志和链路追踪，只在启动时调用一次
+func (c *Config) MustSetup() {
 	logx.MustSetup(c.Log)
 	trace.StartAgent(c.Telemetry)
-	return nil
 }
 
-func (c *IpWhitelistConfig) Validate() error {
-	if !c.Enabled {
-		return nil
+func (c *ProxyConfig) Validate() error {
+	if _, invalid := ipfilter.ParseRules(c.TrustedProxies); len(invalid) > 0 {
+		return ErrInvalidTrustedProxy
 	}
-	if c.File != "" {
-		c.readIpWhitelistFromFile()
+	if c.ProxyProtocol && len(c.TrustedProxies) == 0 {
+		return ErrProxyProtocolNoProxy
 	}
-	go c.listenIpWhitelistChange()
 	return nil
 }
 
-func (c *IpWhitelistConfig) readIpWhitelistFromFile() {
-	filepath := c.File
-	// 逐行读取文件，如果符合IP格式，就加入白名单
-	ipList := make([]string, 0)
-	// 读取文件
-	content, err := os.ReadFile(filepath)
-	if err != nil {
-		logx.Errorf("read file %s error: %v", filepath, err)
-		return
-	}
-	// 逐行读取
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		// 去掉空格
-		line = strings.TrimSpace(line)
-		// 如果是空行，就跳过
-		if line == "" {
-			continue
-		}
-		// 如果是注释，就跳过
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-		// 如果符合IP格式，就加入白名单
-		reg := `^((25[0-5]|2[0-4]\d|1\d{2}|[1-9]\d|\d)\.){3}(25[0-5]|2[0-4]\d|1\d{2}|[1-9]\d|\d)(:\d{1,5})?$`
-		if ok, _ := regexp.MatchString(reg, line); ok {
-			ipList = append(ipList, line)
-		}
+func (c SessionPolicyConfig) Validate() error {
+	if len(c.PeerIds) == 0 {
+		return ErrInvalidSessionPolicy
 	}
-	// 更新白名单
-	c.ipListLock.Lock()
-	defer c.ipListLock.Unlock()
-	c.IpList = ipList
-}
-
-func (c *IpWhitelistConfig) listenIpWhitelistChange() {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer watcher.Close()
-	// 监听文件
-	err = watcher.Add(c.File)
-	if err != nil {
-		log.Fatal(err)
-	}
-	for {
-		select {
-		case event, ok := <-watcher.Events:
-			// 如果文件被删除，就重新监听
-			if event.Op&fsnotify.Remove == fsnotify.Remove {
-				err = watcher.Add(c.File)
-				if err != nil {
-					log.Fatal(err)
-				}
-			}
-			// 如果文件被修改，就重新读取
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				c.readIpWhitelistFromFile()
-			}
-			if !ok {
-				return
-			}
-		case err, ok := <-watcher.Errors:
-			if !ok {
-				return
-			}
-			logx.Errorf("watcher error: %v", err)
+	for _, pattern := range c.PeerIds {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return ErrInvalidSessionPolicy
 		}
 	}
+	return nil
 }
 
-func (c *IpWhitelistConfig) InIpWhitelist(ip string) bool {
-	ips := strings.Split(ip, ",")
-	if !c.Enabled {
-		return true
+func (c DispatchConfig) Validate() error {
+	if c.Concurrency <= 0 {
+		return ErrInvalidDispatch
 	}
-	// 只要有一个ip在白名单中，就返回true
-	c.ipListLock.RLock()
-	defer c.ipListLock.RUnlock()
-	for _, v := range ips {
-		// 把后面端口号去掉
-		if strings.Contains(v, ":") {
-			v = strings.Split(v, ":")[0]
+	for _, pattern := range c.OrderedMethods {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return ErrInvalidDispatch
 		}
-		for _, ip := range c.IpList {
-			if ip == v {
-				return true
+	}
+	return nil
+}
+
+// SessionPolicyOf 返回peerId适用的会话策略
+func (c *WebSocketConfig) SessionPolicyOf(peerId string) string {
+	for _, p := range c.SessionPolicies {
+		for _, pattern := range p.PeerIds {
+			if ok, _ := path.Match(pattern, peerId); ok {
+				return p.Policy
 			}
 		}
 	}
-	return false
+	if c.SessionPolicy == "" {
+		return SessionPolicyMultiple
+	}
+	return c.SessionPolicy
+}
+
+func (c *VirtualPeerConfig) Validate() error {
+	if c.PeerId == "" || c.Url == "" {
+		return ErrInvalidVirtualPeer
+	}
+	if u, err := url.Parse(c.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ErrInvalidVirtualPeer
+	}
+	return nil
 }
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// AllowOrigin 判断跨域请求的Origin是否在AllowOrigins中
+func (c *CorsConfig) AllowOrigin(origin string) bool {
+	return matchOrigin(c.AllowOrigins, origin)
+}
+
+// AllowWebSocketOrigin 判断websocket升级请求的Origin是否允许，防止跨站websocket劫持
+// 没有Origin（非浏览器客户端）或与请求host同源时总是允许，其他Origin必须匹配OriginPatterns
+// 不使用AllowOrigins，避免CORS的通配符让升级请求的校验失效；"null" Origin（沙箱iframe、本地文件）总是拒绝
+func (c *CorsConfig) AllowWebSocketOrigin(mode string, origin string, requestHost string) bool {
+	if c.SkipWebSocketOriginCheck(mode) {
+		return true
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, requestHost) {
+		return true
+	}
+	return matchOrigin(c.OriginPatterns, origin)
+}
+
+// SkipWebSocketOriginCheck dev模式下开启 DevSkipOriginCheck 时不校验Origin
+func (c *CorsConfig) SkipWebSocketOriginCheck(mode string) bool {
+	return mode == "dev" && c.DevSkipOriginCheck
+}
+
+// WebSocketOriginHosts 传给 websocket.Accept 的OriginPatterns，只能匹配host
+// 带协议的规则去掉协议部分，协议已由 AllowWebSocketOrigin 校验
+func (c *CorsConfig) WebSocketOriginHosts() []string {
+	hosts := make([]string, 0, len(c.OriginPatterns))
+	for _, pattern := range c.OriginPatterns {
+		if i := strings.Index(pattern, "://"); i >= 0 {
+			pattern = pattern[i+len("://"):]
+		}
+		hosts = append(hosts, pattern)
+	}
+	return hosts
+}
+
+func matchOrigin(patterns []string, origin string) bool {
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	host := strings.ToLower(u.Host)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		target := host
+		if strings.Contains(pattern, "://") {
+			target = origin
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
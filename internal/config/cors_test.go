@@ -0,0 +1,62 @@
+package config
+
+import (
+	"github.com/zeromicro/go-zero/core/conf"
+	"reflect"
+	"testing"
+)
+
+func TestAllowWebSocketOrigin(t *testing.T) {
+	patterns := []string{"https://*.example.com", "app.example.org"}
+	tests := []struct {
+		name     string
+		config   CorsConfig
+		mode     string
+		origin   string
+		host     string
+		expected bool
+	}{
+		{"no origin", CorsConfig{}, "pro", "", "signal.example.com", true},
+		{"same host", CorsConfig{}, "pro", "https://signal.example.com", "signal.example.com", true},
+		{"same host ignores case", CorsConfig{}, "pro", "https://Signal.Example.com", "signal.example.com", true},
+		{"same host different port", CorsConfig{}, "pro", "https://signal.example.com:8443", "signal.example.com", false},
+		{"cross site without patterns", CorsConfig{}, "pro", "https://evil.com", "signal.example.com", false},
+		{"cors wildcard is not used", CorsConfig{AllowOrigins: []string{"*"}}, "pro", "https://evil.com", "signal.example.com", false},
+		{"explicit wildcard", CorsConfig{OriginPatterns: []string{"*"}}, "pro", "https://evil.com", "signal.example.com", true},
+		{"scheme pattern", CorsConfig{OriginPatterns: patterns}, "pro", "https://web.example.com", "signal.example.com", true},
+		{"scheme pattern wrong scheme", CorsConfig{OriginPatterns: patterns}, "pro", "http://web.example.com", "signal.example.com", false},
+		{"scheme pattern other domain", CorsConfig{OriginPatterns: patterns}, "pro", "https://example.com.evil.com", "signal.example.com", false},
+		{"host pattern any scheme", CorsConfig{OriginPatterns: patterns}, "pro", "http://app.example.org", "signal.example.com", true},
+		{"null origin", CorsConfig{OriginPatterns: patterns}, "pro", "null", "signal.example.com", false},
+		{"null origin with wildcard", CorsConfig{OriginPatterns: []string{"*"}}, "pro", "null", "signal.example.com", false},
+		{"invalid origin", CorsConfig{OriginPatterns: []string{"*"}}, "pro", "https://%zz", "signal.example.com", false},
+		{"dev bypass", CorsConfig{DevSkipOriginCheck: true}, "dev", "https://evil.com", "signal.example.com", true},
+		{"dev bypass disabled", CorsConfig{}, "dev", "https://evil.com", "signal.example.com", false},
+		{"no bypass in pro", CorsConfig{DevSkipOriginCheck: true}, "pro", "https://evil.com", "signal.example.com", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if allowed := test.config.AllowWebSocketOrigin(test.mode, test.origin, test.host); allowed != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, allowed)
+			}
+		})
+	}
+}
+
+func TestWebSocketOriginHosts(t *testing.T) {
+	c := CorsConfig{OriginPatterns: []string{"https://*.example.com", "app.example.org", "http://localhost:3000"}}
+	expected := []string{"*.example.com", "app.example.org", "localhost:3000"}
+	if hosts := c.WebSocketOriginHosts(); !reflect.DeepEqual(hosts, expected) {
+		t.Fatalf("expected %v, got %v", expected, hosts)
+	}
+}
+
+func TestDevSkipOriginCheckDefaultsToFalse(t *testing.T) {
+	c := &Config{}
+	if err := conf.LoadFromYamlBytes([]byte("Mode: dev\n"), c); err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if c.Cors.DevSkipOriginCheck || c.Cors.SkipWebSocketOriginCheck(c.Mode) {
+		t.Fatal("origin check should be enforced unless explicitly skipped")
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidIceServer = errors.New("ice server needs at least one url, and a positive ttl when secret is set")
+
+// IceServerConfig 通过 $server.iceServers 下发给peer的STUN/TURN服务器
+// 设置Secret时（coturn的 static-auth-secret）为每个peer生成有效期为Ttl秒的临时凭证，不使用Username、Credential
+type IceServerConfig struct {
+	Urls       []string // 如 stun:stun.example.com:3478、turn:turn.example.com:3478?transport=udp
+	Username   string   `json:",optional"`
+	Credential string   `json:",optional"`
+	Secret     string   `json:",optional"`
+	Ttl        int      `json:",default=86400"`
+}
+
+// IceServer 与浏览器 RTCIceServer 的格式相同
+type IceServer struct {
+	Urls       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+func (c *IceServerConfig) Validate() error {
+	if len(c.Urls) == 0 || (c.Secret != "" && c.Ttl <= 0) {
+		return ErrInvalidIceServer
+	}
+	return nil
+}
+
+// IceServer 返回下发给peerId的配置，临时凭证的用户名为 过期时间戳:peerId
+func (c *IceServerConfig) IceServer(peerId string, now time.Time) IceServer {
+	if c.Secret == "" {
+		return IceServer{Urls: c.Urls, Username: c.Username, Credential: c.Credential}
+	}
+	username := strconv.FormatInt(now.Add(time.Duration(c.Ttl)*time.Second).Unix(), 10)
+	if peerId != "" {
+		username += ":" + peerId
+	}
+	mac := hmac.New(sha1.New, []byte(c.Secret))
+	mac.Write([]byte(username))
+	return IceServer{Urls: c.Urls, Username: username, Credential: base64.StdEncoding.EncodeToString(mac.Sum(nil))}
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"fmt"
+	"github.com/peergoim/signaling-server/internal/ipfilter"
+	"github.com/zeromicro/go-zero/core/logx"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+)
+
+// IpWhitelistConfig ip访问过滤，支持IPv4、IPv6、CIDR，允许名单和禁止名单
+type IpWhitelistConfig struct {
+	Enabled bool `json:",optional"`
+	// 允许名单，与File中的规则合并
+	IpList []string `json:",optional"`
+	File   string   `json:",optional"`
+	// 禁止名单，与DenyFile中的规则合并
+	DenyList []string `json:",optional"`
+	DenyFile string   `json:",optional"`
+	// 同时命中允许和禁止名单时的处理：deny 禁止优先；allow 允许优先；specific 更具体的规则优先
+	Precedence string `json:",default=deny,options=deny|allow|specific"`
+	// 两个名单都没有命中时的处理
+	DefaultAction string `json:",default=deny,options=deny|allow"`
+	// 单独设置名单的路由，按顺序使用第一个匹配的；没有匹配的peer注册路由使用上面的名单
+	Routes []IpRouteConfig `json:",optional"`
+
+	rules      *ipRules
+	routeRules []*ipRules
+}
+
+// IpRouteConfig 路由单独使用的名单，不与外层的名单合并
+type IpRouteConfig struct {
+	// 支持 * 结尾的前缀匹配（如 /admin/*）
+	Paths    []string
+	IpList   []string `json:",optional"`
+	File     string   `json:",optional"`
+	DenyList []string `json:",optional"`
+	DenyFile string   `json:",optional"`
+	// 为空时使用外层的设置
+	Precedence    string `json:",optional,options=deny|allow|specific"`
+	DefaultAction string `json:",optional,options=deny|allow"`
+}
+
+// ipRules 一组允许/禁止名单生成的过滤器，名单文件变化后重新生成
+type ipRules struct {
+	ipList       []string
+	denyList     []string
+	precedence   string
+	defaultAllow bool
+
+	lock      sync.RWMutex
+	fileAllow []netip.Prefix
+	fileDeny  []netip.Prefix
+	filter    *ipfilter.Filter
+}
+
+// defaultIpFilterRoutes peer注册的路由
+var defaultIpFilterRoutes = []string{"/ws", "/sse", "/poll/connect"}
+
+func (c *IpWhitelistConfig) Validate() error {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+	if _, invalid := ipfilter.ParseRules(append(c.IpList, c.DenyList...)); len(invalid) > 0 {
+		return fmt.Errorf("%w: %v", ipfilter.ErrInvalidRule, invalid)
+	}
+	for _, r := range c.Routes {
+		if len(r.Paths) == 0 {
+			return ErrInvalidIpRoute
+		}
+		if _, invalid := ipfilter.ParseRules(append(r.IpList, r.DenyList...)); len(invalid) > 0 {
+			return fmt.Errorf("%w: %v", ipfilter.ErrInvalidRule, invalid)
+		}
+	}
+	// 名单文件的变化由 Watcher 监听
+	c.rules = newIpRules(c.IpList, c.File, c.DenyList, c.DenyFile, c.Precedence, c.DefaultAction)
+	c.routeRules = make([]*ipRules, 0, len(c.Routes))
+	for _, r := range c.Routes {
+		precedence, defaultAction := r.Precedence, r.DefaultAction
+		if precedence == "" {
+			precedence = c.Precedence
+		}
+		if defaultAction == "" {
+			defaultAction = c.DefaultAction
+		}
+		c.routeRules = append(c.routeRules, newIpRules(r.IpList, r.File, r.DenyList, r.DenyFile, precedence, defaultAction))
+	}
+	return nil
+}
+
+func newIpRules(ipList []string, file string, denyList []string, denyFile string, precedence string, defaultAction string) *ipRules {
+	r := &ipRules{
+		ipList:       ipList,
+		denyList:     denyList,
+		precedence:   precedence,
+		defaultAllow: defaultAction == "allow",
+	}
+	if file != "" {
+		r.fileAllow = readIpRulesFromFile(file)
+	}
+	if denyFile != "" {
+		r.fileDeny = readIpRulesFromFile(denyFile)
+	}
+	r.rebuildFilter()
+	return r
+}
+
+func (r *ipRules) reloadFile(file string, target *[]netip.Prefix) {
+	rules := readIpRulesFromFile(file)
+	r.lock.Lock()
+	*target = rules
+	r.lock.Unlock()
+	r.rebuildFilter()
+}
+
+// rebuildFilter 合并配置和文件中的规则，重新生成过滤器
+func (r *ipRules) rebuildFilter() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// 配置中的规则已在Validate中校验
+	allow, _ := ipfilter.ParseRules(r.ipList)
+	deny, _ := ipfilter.ParseRules(r.denyList)
+	allow = append(allow, r.fileAllow...)
+	deny = append(deny, r.fileDeny...)
+	r.filter = ipfilter.New(allow, deny, r.precedence, r.defaultAllow)
+}
+
+// allowed ip可以是逗号分隔的多个ip，只要有一个允许就返回true
+func (r *ipRules) allowed(ip string) bool {
+	r.lock.RLock()
+	filter := r.filter
+	r.lock.RUnlock()
+	for _, v := range strings.Split(ip, ",") {
+		if filter.AllowedString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// readIpRulesFromFile 逐行读取文件，每行一个IP或CIDR，# 开头为注释
+func readIpRulesFromFile(filepath string) []netip.Prefix {
+	rules := make([]netip.Prefix, 0)
+	// 读取文件
+	content, err := os.ReadFile(filepath)
+	if err != nil {
+		logx.Errorf("read file %s error: %v", filepath, err)
+		return rules
+	}
+	// 逐行读取
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		// 去掉空格
+		line = strings.TrimSpace(line)
+		// 如果是空行，就跳过
+		if line == "" {
+			continue
+		}
+		// 如果是注释，就跳过
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ipfilter.ParseRule(line)
+		if err != nil {
+			logx.Errorf("invalid ip rule %q in %s", line, filepath)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// rulesFor 返回路由使用的名单，不需要过滤时返回nil
+func (c *IpWhitelistConfig) rulesFor(route string) *ipRules {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+	for i, r := range c.Routes {
+		if matchRoute(r.Paths, route) && i < len(c.routeRules) {
+			return c.routeRules[i]
+		}
+	}
+	if matchRoute(defaultIpFilterRoutes, route) {
+		return c.rules
+	}
+	return nil
+}
+
+func matchRoute(routes []string, route string) bool {
+	for _, r := range routes {
+		if r == route || (strings.HasSuffix(r, "*") && strings.HasPrefix(route, strings.TrimSuffix(r, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldFilter 路由是否需要ip过滤
+func (c *IpWhitelistConfig) ShouldFilter(route string) bool {
+	return c.rulesFor(route) != nil
+}
+
+// InIpWhitelist 判断ip是否允许访问路由，ip可以是逗号分隔的多个ip，只要有一个允许就返回true
+func (c *IpWhitelistConfig) InIpWhitelist(route string, ip string) bool {
+	if rules := c.rulesFor(route); rules != nil {
+		return rules.allowed(ip)
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"errors"
+	"github.com/zeromicro/go-zero/core/conf"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIpWhitelistRoutes(t *testing.T) {
+	dir := t.TempDir()
+	callDeny := filepath.Join(dir, "call_deny.txt")
+	if err := os.WriteFile(callDeny, []byte("10.1.0.0/16\n"), 0o600); err != nil {
+		t.Fatalf("write deny file: %v", err)
+	}
+	c := &Config{}
+	content := `Mode: dev
+WebSocket:
+  IpWhitelist:
+    Enabled: true
+    IpList: ["192.168.0.0/16"]
+    Routes:
+      - Paths: ["/call"]
+        IpList: ["10.0.0.0/8"]
+        DenyFile: "` + callDeny + `"
+      - Paths: ["/admin/*"]
+        DefaultAction: allow
+`
+	if err := conf.LoadFromYamlBytes([]byte(content), c); err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	w := c.WebSocket.IpWhitelist
+	cases := []struct {
+		route   string
+		ip      string
+		allowed bool
+	}{
+		{"/ws", "192.168.1.1", true},
+		{"/ws", "10.0.0.1", false},
+		{"/call", "10.0.0.1", true},
+		{"/call", "192.168.1.1", false},
+		{"/call", "10.1.0.1", false},
+		{"/admin/peers", "8.8.8.8", true},
+		// 没有单独设置的路由只过滤peer注册的路由
+		{"/reply", "8.8.8.8", true},
+	}
+	for _, tc := range cases {
+		if allowed := w.InIpWhitelist(tc.route, tc.ip); allowed != tc.allowed {
+			t.Errorf("%s %s: expected allowed=%v", tc.route, tc.ip, tc.allowed)
+		}
+	}
+	if !w.ShouldFilter("/call") || w.ShouldFilter("/reply") {
+		t.Fatal("only the peer routes and the configured routes should be filtered")
+	}
+
+	// 名单文件变化后只重新加载对应路由的名单
+	if err := os.WriteFile(callDeny, []byte("10.2.0.0/16\n"), 0o600); err != nil {
+		t.Fatalf("write deny file: %v", err)
+	}
+	for _, reload := range c.fileHandlers()[filepath.Clean(callDeny)] {
+		reload()
+	}
+	if !w.InIpWhitelist("/call", "10.1.0.1") || w.InIpWhitelist("/call", "10.2.0.1") {
+		t.Fatal("expected the reloaded deny file to apply to /call")
+	}
+
+	w.Routes = append(w.Routes, IpRouteConfig{IpList: []string{"10.0.0.0/8"}})
+	if err := c.Validate(); !errors.Is(err, ErrInvalidIpRoute) {
+		t.Fatalf("expected ErrInvalidIpRoute for a route without paths, got %v", err)
+	}
+}
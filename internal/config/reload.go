@@ -0,0 +1,344 @@
+package config
+
+import (
+	"expvar"
+	"github.com/fsnotify/fsnotify"
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/logx"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	reloadSucceeded = expvar.NewInt("config_reload_succeeded")
+	reloadFailed    = expvar.NewInt("config_reload_failed")
+	reloadLastTime  = expvar.NewString("config_reload_last_time")
+	reloadChanged   = expvar.NewString("config_reload_last_changed")
+)
+
+// restartOnlyFields 修改后需要重启才能生效的字段（及其子字段），热加载时保留旧值
+var restartOnlyFields = []string{
+	"Mode",
+	"Telemetry",
+	"Admin.Enabled",
+	"Events",
+	"WebSocket.ListenOn",
+	"WebSocket.HttpFallback.Enabled",
+	"WebSocket.Tls.Enabled",
+	"WebSocket.Proxy.ProxyProtocol",
+	// gin的SetTrustedProxies不能在处理请求时调用
+	"WebSocket.Proxy.TrustedProxies",
+	"Log.ServiceName",
+	"Log.Mode",
+	"Log.Encoding",
+	"Log.TimeFormat",
+	"Log.Path",
+	"Log.Compress",
+	"Log.KeepDays",
+	"Log.StackCooldownMillis",
+	"Log.MaxBackups",
+	"Log.MaxSize",
+	"Log.Rotation",
+}
+
+// debounceInterval 编辑器保存时会产生多个文件事件，合并后再重新加载
+const debounceInterval = 200 * time.Millisecond
+
+// Watcher 监听配置文件及其引用的文件（ip名单、证书、schema），变化或收到SIGHUP后重新加载
+// 新配置校验通过后整体替换，校验失败则继续使用旧配置
+type Watcher struct {
+	path     string
+	onChange func(old *Config, new *Config)
+
+	lock    sync.Mutex
+	current *Config
+	watcher *fsnotify.Watcher
+	watched map[string]struct{}
+}
+
+// Load 加载并校验配置文件
+func Load(path string) (*Config, error) {
+	c := &Config{}
+	if err := conf.Load(path, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewWatcher current为已经加载并校验过的配置，onChange在新配置生效后调用
+func NewWatcher(path string, current *Config, onChange func(old *Config, new *Config)) *Watcher {
+	return &Watcher{
+		path:     path,
+		current:  current,
+		onChange: onChange,
+		watched:  make(map[string]struct{}),
+	}
+}
+
+// Reload 重新加载配置文件
+func (w *Watcher) Reload() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	next := &Config{}
+	if err := conf.Load(w.path, next); err != nil {
+		reloadFailed.Add(1)
+		logx.Errorf("reload config %s failed, keep using the old one: %v", w.path, err)
+		return err
+	}
+	old := w.current
+	// 先恢复需要重启的字段再校验，保证校验的就是最终生效的配置
+	kept := keepRestartOnlyFields(old, next, diffConfig(old, next))
+	if err := next.Validate(); err != nil {
+		reloadFailed.Add(1)
+		logx.Errorf("reload config %s failed, keep using the old one: %v", w.path, err)
+		return err
+	}
+	if len(kept) > 0 {
+		logx.Errorf("config fields %v require restart, keep the old values", kept)
+	}
+	changed := diffConfig(old, next)
+	if len(changed) == 0 {
+		return nil
+	}
+	w.current = next
+	w.updateWatchedFiles()
+	reloadSucceeded.Add(1)
+	reloadLastTime.Set(time.Now().Format(time.RFC3339))
+	reloadChanged.Set(strings.Join(changed, ","))
+	logx.Infof("config reloaded, changed fields: %v", changed)
+	w.onChange(old, next)
+	return nil
+}
+
+// Watch 阻塞监听文件变化和SIGHUP信号
+func (w *Watcher) Watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logx.Errorf("failed to create config watcher: %v", err)
+		return
+	}
+	defer watcher.Close()
+	w.lock.Lock()
+	w.watcher = watcher
+	w.updateWatchedFiles()
+	w.lock.Unlock()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	debounce := time.NewTimer(time.Hour)
+	debounce.Stop()
+	var pendingFiles = make(map[string]struct{})
+	for {
+		select {
+		case <-signals:
+			logx.Infof("received SIGHUP, reload config")
+			_ = w.Reload()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// 如果文件被删除或重命名（如证书轮换时用新文件替换），就重新监听
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				rewatch(watcher, event.Name)
+			}
+			if event.Op&(fsnotify.Remove|fsnotify.Rename|fsnotify.Write|fsnotify.Create) != 0 {
+				pendingFiles[filepath.Clean(event.Name)] = struct{}{}
+				debounce.Reset(debounceInterval)
+			}
+		case <-debounce.C:
+			w.handleFileChanges(pendingFiles)
+			pendingFiles = make(map[string]struct{})
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logx.Errorf("watcher error: %v", err)
+		}
+	}
+}
+
+// handleFileChanges 配置文件变化时整体重新加载，否则只重新加载变化的引用文件
+func (w *Watcher) handleFileChanges(files map[string]struct{}) {
+	if _, ok := files[filepath.Clean(w.path)]; ok {
+		_ = w.Reload()
+		return
+	}
+	w.lock.Lock()
+	current := w.current
+	w.lock.Unlock()
+	handlers := current.fileHandlers()
+	for file := range files {
+		for _, handler := range handlers[file] {
+			handler()
+		}
+	}
+}
+
+// updateWatchedFiles 调用时需持有w.lock
+func (w *Watcher) updateWatchedFiles() {
+	if w.watcher == nil {
+		return
+	}
+	files := map[string]struct{}{filepath.Clean(w.path): {}}
+	for file := range w.current.fileHandlers() {
+		files[file] = struct{}{}
+	}
+	for file := range w.watched {
+		if _, ok := files[file]; !ok {
+			_ = w.watcher.Remove(file)
+		}
+	}
+	for file := range files {
+		if _, ok := w.watched[file]; ok {
+			continue
+		}
+		if err := w.watcher.Add(file); err != nil {
+			logx.Errorf("failed to watch %s: %v", file, err)
+		}
+	}
+	w.watched = files
+}
+
+// fileHandlers 配置引用的文件，以及文件变化后的重新加载方法
+// 同一个文件可能被多处引用（如多个方法共用一个schema文件），每处都需要重新加载
+func (c *Config) fileHandlers() map[string][]func() {
+	handlers := make(map[string][]func())
+	// 同一处引用的多个文件相同时（如证书和私钥在同一个文件中），只加载一次
+	add := func(handler func(), files ...string) {
+		added := make(map[string]struct{})
+		for _, file := range files {
+			if file == "" {
+				continue
+			}
+			file = filepath.Clean(file)
+			if _, ok := added[file]; !ok {
+				added[file] = struct{}{}
+				handlers[file] = append(handlers[file], handler)
+			}
+		}
+	}
+	if w := c.WebSocket.IpWhitelist; w != nil && w.Enabled && w.rules != nil {
+		addIpRules := func(r *ipRules, file string, denyFile string) {
+			add(func() {
+				r.reloadFile(file, &r.fileAllow)
+			}, file)
+			add(func() {
+				r.reloadFile(denyFile, &r.fileDeny)
+			}, denyFile)
+		}
+		addIpRules(w.rules, w.File, w.DenyFile)
+		for i, r := range w.routeRules {
+			addIpRules(r, w.Routes[i].File, w.Routes[i].DenyFile)
+		}
+	}
+	if t := &c.WebSocket.Tls; t.Enabled {
+		add(t.reloadCertificates, t.CertFile, t.KeyFile, t.ClientCaFile)
+	}
+	for i := range c.Schemas {
+		s := &c.Schemas[i]
+		add(s.reload, s.files()...)
+	}
+	return handlers
+}
+
+// rewatch 文件被替换时新文件可能还未写入，短暂重试
+func rewatch(watcher *fsnotify.Watcher, file string) {
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = watcher.Add(file); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	logx.Errorf("failed to rewatch %s: %v", file, err)
+}
+
+// keepRestartOnlyFields 把需要重启才能生效的字段恢复为旧值，返回被保留的字段
+func keepRestartOnlyFields(old *Config, next *Config, changed []string) []string {
+	kept := make([]string, 0)
+	oldValue, nextValue := reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem()
+	for _, field := range restartOnlyFields {
+		if !hasChangedPrefix(changed, field) {
+			continue
+		}
+		src, dst := fieldByPath(oldValue, field), fieldByPath(nextValue, field)
+		if !src.IsValid() || !dst.IsValid() || !dst.CanSet() {
+			continue
+		}
+		dst.Set(src)
+		kept = append(kept, field)
+	}
+	return kept
+}
+
+func hasChangedPrefix(changed []string, field string) bool {
+	for _, c := range changed {
+		if c == field || strings.HasPrefix(c, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldByPath(v reflect.Value, path string) reflect.Value {
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.FieldByName(name)
+		if !v.IsValid() {
+			return v
+		}
+	}
+	return v
+}
+
+// diffConfig 比较两个配置的导出字段，返回有变化的字段路径，如 WebSocket.CallTimeout
+func diffConfig(old *Config, next *Config) []string {
+	changed := make([]string, 0)
+	diffValue(reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem(), "", &changed)
+	return changed
+}
+
+func diffValue(a reflect.Value, b reflect.Value, path string, changed *[]string) {
+	for a.Kind() == reflect.Ptr {
+		if a.IsNil() || b.IsNil() {
+			if a.IsNil() != b.IsNil() {
+				*changed = append(*changed, path)
+			}
+			return
+		}
+		a, b = a.Elem(), b.Elem()
+	}
+	if a.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*changed = append(*changed, path)
+		}
+		return
+	}
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if path != "" {
+			name = path + "." + field.Name
+		}
+		diffValue(a.Field(i), b.Field(i), name, changed)
+	}
+}
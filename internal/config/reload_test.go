@@ -0,0 +1,187 @@
+package config
+
+import (
+	"crypto/tls"
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/logx"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logx.Disable()
+	os.Exit(m.Run())
+}
+
+func newTestConfig(t *testing.T) *Config {
+	t.Helper()
+	c := &Config{}
+	if err := conf.LoadFromYamlBytes([]byte("Mode: dev\nWebSocket:\n  CallTimeout: 10\n"), c); err != nil {
+		t.Fatalf("load test config: %v", err)
+	}
+	return c
+}
+
+func TestDiffConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(c *Config)
+		expected []string
+	}{
+		{"no change", func(c *Config) {}, []string{}},
+		{"nested field", func(c *Config) { c.WebSocket.CallTimeout = 20 }, []string{"WebSocket.CallTimeout"}},
+		{"deeply nested field", func(c *Config) { c.WebSocket.Resume.GraceWindow = 5 }, []string{"WebSocket.Resume.GraceWindow"}},
+		{"several fields", func(c *Config) {
+			c.Mode = "pro"
+			c.WebSocket.Tls.Enabled = true
+		}, []string{"Mode", "WebSocket.Tls.Enabled"}},
+		{"nil pointer to value", func(c *Config) {
+			c.WebSocket.IpWhitelist = &IpWhitelistConfig{Enabled: true}
+		}, []string{"WebSocket.IpWhitelist"}},
+		{"slice", func(c *Config) { c.Cors.AllowOrigins = []string{"https://example.com"} }, []string{"Cors.AllowOrigins"}},
+		{"slice of structs", func(c *Config) {
+			c.VirtualPeers = []VirtualPeerConfig{{PeerId: "vp", Url: "http://example.com"}}
+		}, []string{"VirtualPeers"}},
+		// 未导出的字段（如已加载的证书）不参与比较
+		{"unexported field", func(c *Config) { c.WebSocket.Tls.certificate = &tls.Certificate{} }, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old, next := newTestConfig(t), newTestConfig(t)
+			test.change(next)
+			if changed := diffConfig(old, next); !reflect.DeepEqual(changed, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, changed)
+			}
+		})
+	}
+}
+
+func TestDiffConfigPointers(t *testing.T) {
+	old, next := newTestConfig(t), newTestConfig(t)
+	old.WebSocket.IpWhitelist = &IpWhitelistConfig{Enabled: true, IpList: []string{"10.0.0.0/8"}}
+	next.WebSocket.IpWhitelist = &IpWhitelistConfig{Enabled: true, IpList: []string{"10.0.0.0/8"}}
+	if changed := diffConfig(old, next); len(changed) != 0 {
+		t.Fatalf("equal pointers should be compared by value, got %v", changed)
+	}
+	next.WebSocket.IpWhitelist.IpList = append(next.WebSocket.IpWhitelist.IpList, "192.168.0.0/16")
+	if changed := diffConfig(old, next); !reflect.DeepEqual(changed, []string{"WebSocket.IpWhitelist.IpList"}) {
+		t.Fatalf("expected the field behind the pointer, got %v", changed)
+	}
+	next.WebSocket.IpWhitelist = nil
+	if changed := diffConfig(old, next); !reflect.DeepEqual(changed, []string{"WebSocket.IpWhitelist"}) {
+		t.Fatalf("expected the removed pointer, got %v", changed)
+	}
+}
+
+func TestFieldByPath(t *testing.T) {
+	c := newTestConfig(t)
+	value := reflect.ValueOf(c).Elem()
+	if v := fieldByPath(value, "WebSocket.Tls.Enabled"); !v.IsValid() || v.Kind() != reflect.Bool {
+		t.Fatalf("expected a nested bool field, got %v", v)
+	}
+	if v := fieldByPath(value, "WebSocket.Nope"); v.IsValid() {
+		t.Fatalf("unknown field should be invalid, got %v", v)
+	}
+	if v := fieldByPath(value, "WebSocket.IpWhitelist.Enabled"); v.IsValid() {
+		t.Fatalf("field behind a nil pointer should be invalid, got %v", v)
+	}
+	c.WebSocket.IpWhitelist = &IpWhitelistConfig{Enabled: true}
+	if v := fieldByPath(value, "WebSocket.IpWhitelist.Enabled"); !v.IsValid() || !v.Bool() || !v.CanSet() {
+		t.Fatalf("expected a settable field behind the pointer, got %v", v)
+	}
+}
+
+func TestKeepRestartOnlyFields(t *testing.T) {
+	old, next := newTestConfig(t), newTestConfig(t)
+	next.Mode = "pro"
+	next.WebSocket.ListenOn = "0.0.0.0:8080"
+	next.WebSocket.CallTimeout = 30
+	next.WebSocket.Tls.MinVersion = "1.3"
+	next.Events.Urls = []string{"http://example.com"}
+	next.Admin.Token = "token"
+	next.WebSocket.Proxy.TrustedProxies = []string{"10.0.0.0/8"}
+
+	kept := keepRestartOnlyFields(old, next, diffConfig(old, next))
+	expected := []string{"Mode", "Events", "WebSocket.ListenOn", "WebSocket.Proxy.TrustedProxies"}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Fatalf("expected %v to be kept, got %v", expected, kept)
+	}
+	if next.Mode != "dev" || next.WebSocket.ListenOn != old.WebSocket.ListenOn || len(next.Events.Urls) != 0 ||
+		len(next.WebSocket.Proxy.TrustedProxies) != 0 {
+		t.Fatalf("restart only fields should keep the old values: %+v", next)
+	}
+	// 其他字段，包括需要重启字段的兄弟字段，使用新值
+	if next.WebSocket.CallTimeout != 30 || next.WebSocket.Tls.MinVersion != "1.3" || next.Admin.Token != "token" {
+		t.Fatalf("hot reloadable fields should take the new values: %+v", next)
+	}
+	if changed := diffConfig(old, next); !reflect.DeepEqual(changed, []string{"Admin.Token", "WebSocket.CallTimeout", "WebSocket.Tls.MinVersion"}) {
+		t.Fatalf("unexpected remaining changes %v", changed)
+	}
+}
+
+func TestWatcherReloadKeepsRestartOnlyFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+	}
+	write("Mode: dev\nWebSocket:\n  ListenOn: 0.0.0.0:1000\n  CallTimeout: 10\n")
+	current, err := Load(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	var reloaded *Config
+	w := NewWatcher(path, current, func(old *Config, new *Config) { reloaded = new })
+
+	write("Mode: dev\nWebSocket:\n  ListenOn: 0.0.0.0:2000\n  CallTimeout: 20\n")
+	if err = w.Reload(); err != nil || reloaded == nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if reloaded.WebSocket.ListenOn != "0.0.0.0:1000" || reloaded.WebSocket.CallTimeout != 20 {
+		t.Fatalf("unexpected reloaded config %+v", &reloaded.WebSocket)
+	}
+
+	// 只修改了需要重启的字段，不触发onChange
+	reloaded = nil
+	write("Mode: dev\nWebSocket:\n  ListenOn: 0.0.0.0:3000\n  CallTimeout: 20\n")
+	if err = w.Reload(); err != nil || reloaded != nil {
+		t.Fatalf("reload with only restart fields changed: %v, %+v", err, reloaded)
+	}
+
+	// 校验失败时继续使用旧配置
+	write("Mode: dev\nWebSocket:\n  CallTimeout: 30\n  Limits:\n    MaxInboundFrame: -1\n")
+	if err = w.Reload(); err == nil || reloaded != nil {
+		t.Fatalf("invalid config should be rejected, got %v", err)
+	}
+}
+
+func TestSharedFileReloadsEveryReference(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(file, []byte(`{"type": "object"}`), 0o600); err != nil {
+		t.Fatalf("write schema: %v", err)
+	}
+	c := newTestConfig(t)
+	c.Schemas = []MethodSchemaConfig{
+		{Method: "echo", RequestSchema: file, ResponseSchema: file},
+		{Method: "render", RequestSchema: file},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if handlers := c.fileHandlers()[filepath.Clean(file)]; len(handlers) != 2 {
+		t.Fatalf("expected one handler per referencing schema, got %d", len(handlers))
+	}
+
+	if err := os.WriteFile(file, []byte(`{"type": "object", "required": ["text"]}`), 0o600); err != nil {
+		t.Fatalf("write schema: %v", err)
+	}
+	NewWatcher(filepath.Join(t.TempDir(), "config.yaml"), c, nil).handleFileChanges(map[string]struct{}{file: {}})
+	for i := range c.Schemas {
+		if errs := c.Schemas[i].ValidateRequest([]byte(`{}`)); len(errs) != 1 {
+			t.Fatalf("schema of %s should be reloaded, got %v", c.Schemas[i].Method, errs)
+		}
+	}
+}
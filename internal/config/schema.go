@@ -0,0 +1,139 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/peergoim/signaling-server/internal/schema"
+	"github.com/zeromicro/go-zero/core/logx"
+	"os"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidMethodSchema = errors.New("method schema needs a method and a json schema or a descriptor set with message types")
+
+// MethodSchemaConfig 方法请求、响应数据的校验规则，使用JSON Schema或protobuf消息类型
+// 文件变化后自动重新加载，加载失败时继续使用旧的规则
+type MethodSchemaConfig struct {
+	Method string // 方法名，不带版本号时匹配所有版本，如 echo 匹配 echo@2
+	// JSON Schema文件
+	RequestSchema  string `json:",optional"`
+	ResponseSchema string `json:",optional"`
+	// protoc --include_imports --descriptor_set_out 生成的文件，以及请求、响应的消息全名
+	DescriptorSet   string `json:",optional"`
+	RequestMessage  string `json:",optional"`
+	ResponseMessage string `json:",optional"`
+
+	lock     sync.RWMutex
+	request  schema.Validator
+	response schema.Validator
+}
+
+func (c *MethodSchemaConfig) Validate() error {
+	if c.Method == "" {
+		return ErrInvalidMethodSchema
+	}
+	usesJson := c.RequestSchema != "" || c.ResponseSchema != ""
+	usesProto := c.DescriptorSet != "" || c.RequestMessage != "" || c.ResponseMessage != ""
+	if usesJson == usesProto || (usesProto && (c.DescriptorSet == "" || c.RequestMessage == "" && c.ResponseMessage == "")) {
+		return fmt.Errorf("%w: %s", ErrInvalidMethodSchema, c.Method)
+	}
+	return c.load()
+}
+
+func (c *MethodSchemaConfig) load() error {
+	var request, response schema.Validator
+	if c.DescriptorSet != "" {
+		files, err := schema.LoadDescriptorSet(c.DescriptorSet)
+		if err != nil {
+			return err
+		}
+		if c.RequestMessage != "" {
+			if request, err = schema.NewProtoMessage(files, c.RequestMessage); err != nil {
+				return err
+			}
+		}
+		if c.ResponseMessage != "" {
+			if response, err = schema.NewProtoMessage(files, c.ResponseMessage); err != nil {
+				return err
+			}
+		}
+	} else {
+		var err error
+		if request, err = loadJsonSchema(c.RequestSchema); err != nil {
+			return err
+		}
+		if response, err = loadJsonSchema(c.ResponseSchema); err != nil {
+			return err
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.request, c.response = request, response
+	return nil
+}
+
+func (c *MethodSchemaConfig) reload() {
+	if err := c.load(); err != nil {
+		logx.Errorf("failed to reload schema of %s, keep the old one: %v", c.Method, err)
+		return
+	}
+	logx.Infof("reloaded schema of %s", c.Method)
+}
+
+func (c *MethodSchemaConfig) files() []string {
+	return []string{c.RequestSchema, c.ResponseSchema, c.DescriptorSet}
+}
+
+// ValidateRequest 校验请求数据，没有配置请求规则时返回nil
+func (c *MethodSchemaConfig) ValidateRequest(data []byte) []string {
+	c.lock.RLock()
+	v := c.request
+	c.lock.RUnlock()
+	return validate(v, data)
+}
+
+// ValidateResponse 校验响应数据，没有配置响应规则时返回nil
+func (c *MethodSchemaConfig) ValidateResponse(data []byte) []string {
+	c.lock.RLock()
+	v := c.response
+	c.lock.RUnlock()
+	return validate(v, data)
+}
+
+// SchemaOf 返回方法的校验规则，优先完全匹配，其次匹配不带版本号的方法名
+func (c *Config) SchemaOf(method string) (*MethodSchemaConfig, bool) {
+	name, _, _ := strings.Cut(method, "@")
+	var fallback *MethodSchemaConfig
+	for i := range c.Schemas {
+		switch c.Schemas[i].Method {
+		case method:
+			return &c.Schemas[i], true
+		case name:
+			fallback = &c.Schemas[i]
+		}
+	}
+	return fallback, fallback != nil
+}
+
+func validate(v schema.Validator, data []byte) []string {
+	if v == nil {
+		return nil
+	}
+	return v.Validate(data)
+}
+
+func loadJsonSchema(file string) (schema.Validator, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s, err := schema.CompileJsonSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("compile json schema %s: %w", file, err)
+	}
+	return s, nil
+}
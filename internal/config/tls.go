@@ -0,0 +1,125 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/zeromicro/go-zero/core/logx"
+	"net/http"
+	"os"
+	"sync"
+)
+
+type TlsConfig struct {
+	Enabled    bool   `json:",optional"`
+	CertFile   string `json:",optional"`
+	KeyFile    string `json:",optional"`
+	MinVersion string `json:",default=1.2,options=1.2|1.3"`
+	// 设置后启用mTLS，校验客户端证书
+	ClientCaFile string `json:",optional"`
+	// require: 必须提供客户端证书；request: 提供了才校验
+	ClientAuth string `json:",default=require,options=request|require"`
+	// 使用客户端证书的CommonName作为peerId
+	PeerIdFromCert bool `json:",optional"`
+
+	certLock    sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+var (
+	ErrMissingTlsCertificate = errors.New("tls cert file and key file are required when tls is enabled")
+	ErrPeerIdFromCertNeedsCa = errors.New("peerIdFromCert requires client ca file")
+)
+
+func (c *TlsConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return ErrMissingTlsCertificate
+	}
+	if c.PeerIdFromCert && c.ClientCaFile == "" {
+		return ErrPeerIdFromCertNeedsCa
+	}
+	// 证书文件的变化由 Watcher 监听
+	return c.loadCertificates()
+}
+
+func (c *TlsConfig) loadCertificates() error {
+	certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load tls certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if c.ClientCaFile != "" {
+		content, err := os.ReadFile(c.ClientCaFile)
+		if err != nil {
+			return fmt.Errorf("read client ca file: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(content) {
+			return fmt.Errorf("no certificate found in client ca file %s", c.ClientCaFile)
+		}
+	}
+	c.certLock.Lock()
+	defer c.certLock.Unlock()
+	c.certificate = &certificate
+	c.clientCAs = clientCAs
+	return nil
+}
+
+// reloadCertificates 证书文件变化后重新加载，加载失败时继续使用旧证书
+func (c *TlsConfig) reloadCertificates() {
+	if err := c.loadCertificates(); err != nil {
+		logx.Errorf("reload tls certificates failed, keep using the old ones: %v", err)
+		return
+	}
+	logx.Infof("tls certificates reloaded")
+}
+
+// ConfigForClient 每次握手时返回最新的证书和客户端CA
+func (c *TlsConfig) ConfigForClient() (*tls.Config, error) {
+	c.certLock.RLock()
+	defer c.certLock.RUnlock()
+	conf := &tls.Config{
+		MinVersion:   c.minVersion(),
+		Certificates: []tls.Certificate{*c.certificate},
+		NextProtos:   []string{"http/1.1"},
+	}
+	if c.clientCAs != nil {
+		conf.ClientCAs = c.clientCAs
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		if c.ClientAuth == "request" {
+			conf.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return conf, nil
+}
+
+// GetCertificate 返回最新的服务端证书，用于 tls.Config.GetCertificate
+func (c *TlsConfig) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.certLock.RLock()
+	defer c.certLock.RUnlock()
+	return c.certificate, nil
+}
+
+func (c *TlsConfig) minVersion() uint16 {
+	if c.MinVersion == "1.3" {
+		return tls.VersionTLS13
+	}
+	return tls.VersionTLS12
+}
+
+// PeerIdFromRequest 从已校验的客户端证书中取出peerId，未启用或没有证书时返回false
+func (c *TlsConfig) PeerIdFromRequest(r *http.Request) (string, bool) {
+	if !c.Enabled || !c.PeerIdFromCert || r.TLS == nil {
+		return "", false
+	}
+	if len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	return commonName, commonName != ""
+}
@@ -0,0 +1,117 @@
+package dispatch
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// Dispatcher 并发处理一个连接上收到的请求
+// 同时处理（包括排队等待）的请求数量不超过limit，匹配orderedMethods的方法按收到的顺序逐个处理
+type Dispatcher struct {
+	limit          int
+	orderedMethods []string
+
+	lock    sync.Mutex
+	pending int
+	closed  bool
+	// 正在处理的有序方法，及其排队的请求
+	queues map[string][]func()
+	// 正在处理的请求，Wait等待全部结束
+	running sync.WaitGroup
+}
+
+func NewDispatcher(limit int, orderedMethods []string) *Dispatcher {
+	return &Dispatcher{
+		limit:          limit,
+		orderedMethods: orderedMethods,
+		queues:         make(map[string][]func()),
+	}
+}
+
+// Dispatch 异步处理请求，超过并发限制或已经关闭时返回false，由调用方返回错误
+func (d *Dispatcher) Dispatch(method string, task func()) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed || d.pending >= d.limit {
+		return false
+	}
+	d.pending++
+	d.running.Add(1)
+	if !d.ordered(method) {
+		go func() {
+			defer d.running.Done()
+			task()
+			d.lock.Lock()
+			d.pending--
+			d.lock.Unlock()
+		}()
+		return true
+	}
+	if queue, ok := d.queues[method]; ok {
+		d.queues[method] = append(queue, task)
+		return true
+	}
+	d.queues[method] = nil
+	go d.runOrdered(method, task)
+	return true
+}
+
+func (d *Dispatcher) runOrdered(method string, task func()) {
+	for {
+		task()
+		d.lock.Lock()
+		d.pending--
+		d.running.Done()
+		queue := d.queues[method]
+		if len(queue) == 0 {
+			delete(d.queues, method)
+			d.lock.Unlock()
+			return
+		}
+		task = queue[0]
+		queue[0] = nil
+		d.queues[method] = queue[1:]
+		d.lock.Unlock()
+	}
+}
+
+// Close 不再接受新的请求，丢弃排队中的有序请求，正在处理的请求不受影响
+func (d *Dispatcher) Close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.closed = true
+	for method, queue := range d.queues {
+		d.pending -= len(queue)
+		for range queue {
+			d.running.Done()
+		}
+		d.queues[method] = nil
+	}
+}
+
+// Wait 等待正在处理的请求全部结束，通常在Close之后调用
+func (d *Dispatcher) Wait() {
+	d.running.Wait()
+}
+
+// Pending 正在处理和排队的请求数量
+func (d *Dispatcher) Pending() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.pending
+}
+
+// ordered 方法是否需要按顺序处理，规则同时匹配带版本号和不带版本号的方法名
+func (d *Dispatcher) ordered(method string) bool {
+	name, _, _ := strings.Cut(method, "@")
+	for _, pattern := range d.orderedMethods {
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
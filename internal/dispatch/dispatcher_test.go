@@ -0,0 +1,101 @@
+package dispatch
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatchConcurrently(t *testing.T) {
+	d := NewDispatcher(2, nil)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		if !d.Dispatch("slow", func() { defer wg.Done(); <-release }) {
+			t.Fatal("expected dispatch to succeed")
+		}
+	}
+	// 两个请求都在处理中，超过限制
+	if d.Dispatch("fast", func() {}) {
+		t.Fatal("expected dispatch over the limit to be rejected")
+	}
+	close(release)
+	wg.Wait()
+	for i := 0; i < 100 && d.Pending() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if d.Pending() != 0 {
+		t.Fatalf("expected no pending calls, got %d", d.Pending())
+	}
+}
+
+func TestDispatchOrderedMethods(t *testing.T) {
+	d := NewDispatcher(100, []string{"chat.*"})
+	var lock sync.Mutex
+	var got []int
+	var wg sync.WaitGroup
+	release := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(1)
+		d.Dispatch("chat.send@2", func() {
+			defer wg.Done()
+			if i == 0 {
+				<-release
+			}
+			lock.Lock()
+			got = append(got, i)
+			lock.Unlock()
+		})
+	}
+	// 有序方法的第一个请求卡住时，其他方法不受影响
+	done := make(chan struct{})
+	d.Dispatch("other", func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("unordered method blocked by an ordered one")
+	}
+	close(release)
+	wg.Wait()
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("expected calls in order, got %v", got)
+		}
+	}
+	if !d.ordered("chat.send") || d.ordered("other") {
+		t.Fatal("unexpected ordered method matching")
+	}
+}
+
+func TestDispatcherClose(t *testing.T) {
+	d := NewDispatcher(100, []string{"chat.*"})
+	release := make(chan struct{})
+	d.Dispatch("chat.send", func() { <-release })
+	queued := false
+	d.Dispatch("chat.send", func() { queued = true })
+	d.Close()
+	if d.Dispatch("other", func() {}) {
+		t.Fatal("expected dispatch after close to be rejected")
+	}
+	waited := make(chan struct{})
+	go func() {
+		defer close(waited)
+		d.Wait()
+	}()
+	select {
+	case <-waited:
+		t.Fatal("Wait should block until the running call finishes")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("expected Wait to return once the running call finished")
+	}
+	if queued || d.Pending() != 0 {
+		t.Fatalf("queued calls should be dropped on close, pending %d", d.Pending())
+	}
+}
@@ -0,0 +1,98 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// diskQueue 有界的磁盘队列，每个投递失败的批次保存为一个文件，文件名按时间排序
+// 超过最大文件数时删除最旧的批次
+type diskQueue struct {
+	dir      string
+	maxFiles int
+	seq      uint64
+	lock     sync.Mutex
+}
+
+type queuedBatch struct {
+	Url     string          `json:"url"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func newDiskQueue(dir string, maxFiles int) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &diskQueue{dir: dir, maxFiles: maxFiles}, nil
+}
+
+func (q *diskQueue) push(url string, payload []byte) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	data, err := json.Marshal(queuedBatch{Url: url, Payload: payload})
+	if err != nil {
+		return err
+	}
+	q.seq++
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), q.seq%1000000)
+	// 先写临时文件再重命名，避免读到写了一半的批次
+	tmp := filepath.Join(q.dir, name+".tmp")
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(q.dir, name)); err != nil {
+		return err
+	}
+	names := q.list()
+	for len(names) > q.maxFiles {
+		_ = os.Remove(filepath.Join(q.dir, names[0]))
+		names = names[1:]
+	}
+	return nil
+}
+
+// peek 返回最旧的批次
+func (q *diskQueue) peek() (name string, url string, payload []byte, ok bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, name = range q.list() {
+		data, err := os.ReadFile(filepath.Join(q.dir, name))
+		if err != nil {
+			continue
+		}
+		b := queuedBatch{}
+		if err = json.Unmarshal(data, &b); err != nil {
+			// 损坏的批次直接丢弃
+			_ = os.Remove(filepath.Join(q.dir, name))
+			continue
+		}
+		return name, b.Url, b.Payload, true
+	}
+	return "", "", nil, false
+}
+
+func (q *diskQueue) remove(name string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	_ = os.Remove(filepath.Join(q.dir, name))
+}
+
+func (q *diskQueue) list() []string {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && filepath.Ext(e.Name()) == ".json" {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,51 @@
+package event
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestDiskQueueBounds(t *testing.T) {
+	q, err := newDiskQueue(filepath.Join(t.TempDir(), "queue"), 3)
+	if err != nil {
+		t.Fatalf("new disk queue: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err = q.push("http://example.com", []byte(`{"n":`+strconv.Itoa(i)+`}`)); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+	if names := q.list(); len(names) != 3 {
+		t.Fatalf("expected the oldest batches to be dropped, got %v", names)
+	}
+	for i := 2; i < 5; i++ {
+		name, url, payload, ok := q.peek()
+		if !ok || url != "http://example.com" || string(payload) != `{"n":`+strconv.Itoa(i)+`}` {
+			t.Fatalf("expected batch %d first, got %s %s %v", i, url, payload, ok)
+		}
+		q.remove(name)
+	}
+	if _, _, _, ok := q.peek(); ok {
+		t.Fatal("queue should be empty")
+	}
+}
+
+func TestDiskQueueSkipsCorruptBatches(t *testing.T) {
+	q, _ := newDiskQueue(t.TempDir(), 10)
+	if err := os.WriteFile(filepath.Join(q.dir, "00000000000000000000-000000.json"), []byte("{broken"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	// 写了一半的临时文件不会被读取
+	if err := os.WriteFile(filepath.Join(q.dir, "00000000000000000001-000000.json.tmp"), []byte("{}"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = q.push("http://example.com", []byte(`{}`))
+	if _, url, _, ok := q.peek(); !ok || url != "http://example.com" {
+		t.Fatalf("expected the valid batch, got %s %v", url, ok)
+	}
+	if names := q.list(); len(names) != 1 {
+		t.Fatalf("corrupt batch should be removed, got %v", names)
+	}
+}
@@ -0,0 +1,289 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/utils"
+	"github.com/zeromicro/go-zero/core/logx"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// workerBacklog 每个url等待投递的批次数量，超过后写入磁盘队列
+const workerBacklog = 16
+
+// Dispatcher 批量、异步地把事件推送到webhook
+// 每个url由单独的goroutine投递，一个url不可用不影响其他url；投递失败的批次按指数退避重试，
+// 仍然失败、或者事件来不及投递时写入磁盘队列，稍后重新投递
+type Dispatcher struct {
+	config    config.EventWebhookConfig
+	client    *http.Client
+	events    chan Event
+	workers   []*worker
+	done      chan struct{}
+	closeOnce sync.Once
+	batcherWg sync.WaitGroup
+	workersWg sync.WaitGroup
+}
+
+// worker 向一个url投递批次
+type worker struct {
+	d       *Dispatcher
+	url     string
+	batches chan []byte
+	queue   *diskQueue // 未配置QueueDir时为nil，无法投递的批次被丢弃
+	closing bool       // 关闭时投递失败过，剩余的批次直接写入磁盘队列
+}
+
+// NewDispatcher 创建事件分发器，未启用时返回nil，nil分发器的Publish、Close不做任何事
+func NewDispatcher(c config.EventWebhookConfig) *Dispatcher {
+	if !c.Enabled {
+		return nil
+	}
+	d := &Dispatcher{
+		config: c,
+		client: &http.Client{Timeout: time.Second * time.Duration(c.Timeout)},
+		events: make(chan Event, c.BatchSize*4),
+		done:   make(chan struct{}),
+	}
+	for _, url := range c.Urls {
+		w := &worker{d: d, url: url, batches: make(chan []byte, workerBacklog)}
+		if c.QueueDir != "" {
+			// 每个url一个目录，MaxQueueFiles对每个url分别生效
+			queue, err := newDiskQueue(filepath.Join(c.QueueDir, queueDirName(url)), c.MaxQueueFiles)
+			if err != nil {
+				logx.Errorf("event queue dir %s unavailable, failed batches will be dropped: %v", c.QueueDir, err)
+			} else {
+				w.queue = queue
+			}
+		}
+		d.workers = append(d.workers, w)
+	}
+	d.batcherWg.Add(1)
+	go d.run()
+	d.workersWg.Add(len(d.workers))
+	for _, w := range d.workers {
+		go w.run()
+	}
+	return d
+}
+
+func queueDirName(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Publish 非阻塞地投递事件，来不及投递或者已经关闭时写入磁盘队列
+func (d *Dispatcher) Publish(e Event) {
+	if d == nil {
+		return
+	}
+	if e.Type == TypeCall && !d.config.CallEvents {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case <-d.done:
+		d.spill([]Event{e})
+		return
+	default:
+	}
+	select {
+	case d.events <- e:
+	default:
+		d.spill([]Event{e})
+	}
+}
+
+// Close 停止接收事件，投递缓冲中的事件后返回，投递失败的批次写入磁盘队列
+func (d *Dispatcher) Close() {
+	if d == nil {
+		return
+	}
+	d.closeOnce.Do(func() {
+		close(d.done)
+		d.batcherWg.Wait()
+		d.workersWg.Wait()
+	})
+}
+
+// run 把事件攒成批次交给各url的worker
+func (d *Dispatcher) run() {
+	defer d.batcherWg.Done()
+	ticker := time.NewTicker(time.Millisecond * time.Duration(d.config.FlushInterval))
+	defer ticker.Stop()
+	pending := make([]Event, 0, d.config.BatchSize)
+	for {
+		select {
+		case e := <-d.events:
+			pending = append(pending, e)
+			if len(pending) < d.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(pending) == 0 {
+				continue
+			}
+		case <-d.done:
+			d.drain(pending)
+			return
+		}
+		d.flush(pending)
+		pending = make([]Event, 0, d.config.BatchSize)
+	}
+}
+
+// drain 关闭时投递剩余的事件，然后通知worker退出
+func (d *Dispatcher) drain(pending []Event) {
+	// 只有run读取events，长度大于0时读取不会阻塞
+	for len(d.events) > 0 {
+		pending = append(pending, <-d.events)
+		if len(pending) >= d.config.BatchSize {
+			d.flush(pending)
+			pending = make([]Event, 0, d.config.BatchSize)
+		}
+	}
+	if len(pending) > 0 {
+		d.flush(pending)
+	}
+	for _, w := range d.workers {
+		close(w.batches)
+	}
+}
+
+func (d *Dispatcher) flush(events []Event) {
+	payload, err := json.Marshal(batch{Events: events})
+	if err != nil {
+		logx.Errorf("failed to marshal events: %v", err)
+		return
+	}
+	for _, w := range d.workers {
+		select {
+		case w.batches <- payload:
+		default:
+			w.spill(payload)
+		}
+	}
+}
+
+// spill 跳过内存中的批次，直接写入各url的磁盘队列
+func (d *Dispatcher) spill(events []Event) {
+	payload, err := json.Marshal(batch{Events: events})
+	if err != nil {
+		logx.Errorf("failed to marshal events: %v", err)
+		return
+	}
+	for _, w := range d.workers {
+		w.spill(payload)
+	}
+}
+
+func (w *worker) run() {
+	defer w.d.workersWg.Done()
+	ticker := time.NewTicker(time.Millisecond * time.Duration(w.d.config.FlushInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case payload, ok := <-w.batches:
+			if !ok {
+				return
+			}
+			w.deliver(payload)
+		case <-ticker.C:
+			w.redeliverQueued()
+		}
+	}
+}
+
+func (w *worker) spill(payload []byte) {
+	if w.queue == nil {
+		logx.Errorf("event queue unavailable, drop a batch for %s", w.url)
+		return
+	}
+	if err := w.queue.push(w.url, payload); err != nil {
+		logx.Errorf("failed to persist events for %s: %v", w.url, err)
+	}
+}
+
+// deliver 投递一个批次，失败时按指数退避重试；关闭时不再重试，仍然失败的批次写入磁盘队列
+func (w *worker) deliver(payload []byte) {
+	if w.closing {
+		w.spill(payload)
+		return
+	}
+	var (
+		interval = time.Millisecond * time.Duration(w.d.config.RetryInterval)
+		err      error
+	)
+	for attempt := 0; attempt <= w.d.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-w.d.done:
+				w.closing = true
+			case <-time.After(interval):
+			}
+			if w.closing {
+				break
+			}
+			interval *= 2
+		}
+		if err = w.d.post(w.url, payload); err == nil {
+			return
+		}
+	}
+	logx.Errorf("failed to deliver events to %s: %v", w.url, err)
+	select {
+	case <-w.d.done:
+		w.closing = true
+	default:
+	}
+	w.spill(payload)
+}
+
+// redeliverQueued 按先进先出重新投递磁盘队列中的批次，遇到失败就停止，等待下一轮
+func (w *worker) redeliverQueued() {
+	if w.queue == nil {
+		return
+	}
+	for {
+		name, _, payload, ok := w.queue.peek()
+		if !ok {
+			return
+		}
+		if err := w.d.post(w.url, payload); err != nil {
+			return
+		}
+		w.queue.remove(name)
+	}
+}
+
+func (d *Dispatcher) post(url string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(d.config.Timeout))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	utils.SignRequest(req, d.config.Secret, payload)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
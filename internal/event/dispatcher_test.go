@@ -0,0 +1,251 @@
+package event
+
+import (
+	"encoding/json"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/utils"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhook 记录收到的批次
+type webhook struct {
+	*httptest.Server
+	lock    sync.Mutex
+	batches [][]Event
+	times   []time.Time
+	status  func(attempt int) int
+}
+
+func newWebhook(t *testing.T, status func(attempt int) int) *webhook {
+	t.Helper()
+	h := &webhook{status: status}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(utils.HeaderSignatureTimestamp), 10, 64)
+		if r.Header.Get(utils.HeaderSignature) != utils.SignPayload("s3cret", timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.lock.Lock()
+		h.times = append(h.times, time.Now())
+		attempt := len(h.times)
+		h.lock.Unlock()
+		if code := h.status(attempt); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		b := batch{}
+		_ = json.Unmarshal(body, &b)
+		h.lock.Lock()
+		h.batches = append(h.batches, b.Events)
+		h.lock.Unlock()
+	}))
+	t.Cleanup(h.Close)
+	return h
+}
+
+func alwaysOk(int) int {
+	return http.StatusOK
+}
+
+func (h *webhook) received() (batches [][]Event, events int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, b := range h.batches {
+		events += len(b)
+	}
+	return append([][]Event(nil), h.batches...), events
+}
+
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testConfig(urls ...string) config.EventWebhookConfig {
+	return config.EventWebhookConfig{
+		Enabled: true, Urls: urls, Secret: "s3cret", BatchSize: 3, FlushInterval: 50,
+		Timeout: 1, MaxRetries: 2, RetryInterval: 20, MaxQueueFiles: 1000,
+	}
+}
+
+// queuedEvents 磁盘队列中url的事件数量
+func queuedEvents(t *testing.T, dir string, url string) int {
+	t.Helper()
+	q := &diskQueue{dir: filepath.Join(dir, queueDirName(url))}
+	count := 0
+	for _, name := range q.list() {
+		data, err := os.ReadFile(filepath.Join(q.dir, name))
+		if err != nil {
+			t.Fatalf("read queued batch: %v", err)
+		}
+		b := queuedBatch{}
+		events := batch{}
+		if json.Unmarshal(data, &b) != nil || json.Unmarshal(b.Payload, &events) != nil || b.Url != url {
+			t.Fatalf("invalid queued batch %s", data)
+		}
+		count += len(events.Events)
+	}
+	return count
+}
+
+func TestDispatcherBatchesAndSigns(t *testing.T) {
+	h := newWebhook(t, alwaysOk)
+	d := NewDispatcher(testConfig(h.URL))
+	defer d.Close()
+	for i := 0; i < 7; i++ {
+		d.Publish(Event{Type: TypeConnect, PeerId: strconv.Itoa(i)})
+	}
+	// 调用事件未开启时不推送
+	d.Publish(Event{Type: TypeCall, PeerId: "ignored"})
+	waitFor(t, 2*time.Second, func() bool {
+		_, events := h.received()
+		return events == 7
+	})
+	batches, _ := h.received()
+	if len(batches) != 3 || len(batches[0]) != 3 || len(batches[1]) != 3 || len(batches[2]) != 1 {
+		t.Fatalf("expected batches of 3, 3 and 1, got %v", batches)
+	}
+	if batches[0][0].PeerId != "0" || batches[2][0].PeerId != "6" || batches[0][0].Time.IsZero() {
+		t.Fatalf("unexpected events: %v", batches)
+	}
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	h := newWebhook(t, func(attempt int) int {
+		if attempt <= 2 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+	c := testConfig(h.URL)
+	c.RetryInterval = 50
+	d := NewDispatcher(c)
+	defer d.Close()
+	d.Publish(Event{Type: TypeConnect, PeerId: "a"})
+	waitFor(t, 2*time.Second, func() bool {
+		_, events := h.received()
+		return events == 1
+	})
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.times) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(h.times))
+	}
+	first, second := h.times[1].Sub(h.times[0]), h.times[2].Sub(h.times[1])
+	if first < 50*time.Millisecond || second < 100*time.Millisecond {
+		t.Fatalf("expected exponential backoff, got %s then %s", first, second)
+	}
+}
+
+func TestDispatcherSlowUrlDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := newWebhook(t, alwaysOk)
+	c := testConfig(slow.URL, fast.URL)
+	c.MaxRetries = 0
+	c.QueueDir = t.TempDir()
+	d := NewDispatcher(c)
+
+	for i := 0; i < 6; i++ {
+		d.Publish(Event{Type: TypeConnect, PeerId: strconv.Itoa(i)})
+	}
+	waitFor(t, 500*time.Millisecond, func() bool {
+		_, events := fast.received()
+		return events == 6
+	})
+	d.Close()
+	if queued := queuedEvents(t, c.QueueDir, slow.URL); queued != 6 {
+		t.Fatalf("events the slow url missed should be queued on disk, got %d", queued)
+	}
+	if queued := queuedEvents(t, c.QueueDir, fast.URL); queued != 0 {
+		t.Fatalf("delivered events should not be queued, got %d", queued)
+	}
+}
+
+func TestDispatcherSpillsOnOverflow(t *testing.T) {
+	var requests atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	c := testConfig(down.URL)
+	c.BatchSize = 1
+	c.RetryInterval = 200
+	c.QueueDir = t.TempDir()
+	d := NewDispatcher(c)
+	for i := 0; i < 100; i++ {
+		d.Publish(Event{Type: TypeConnect, PeerId: strconv.Itoa(i)})
+	}
+	d.Close()
+	if queued := queuedEvents(t, c.QueueDir, down.URL); queued != 100 {
+		t.Fatalf("no event should be lost while the webhook is down, %d queued", queued)
+	}
+	// 关闭后的事件直接写入磁盘队列
+	d.Publish(Event{Type: TypeConnect, PeerId: "late"})
+	if queued := queuedEvents(t, c.QueueDir, down.URL); queued != 101 {
+		t.Fatalf("events published after close should be queued, %d queued", queued)
+	}
+}
+
+func TestDispatcherCloseFlushesPending(t *testing.T) {
+	h := newWebhook(t, alwaysOk)
+	c := testConfig(h.URL)
+	c.BatchSize = 100
+	c.FlushInterval = 3600 * 1000
+	d := NewDispatcher(c)
+	for i := 0; i < 5; i++ {
+		d.Publish(Event{Type: TypeConnect, PeerId: strconv.Itoa(i)})
+	}
+	d.Close()
+	if _, events := h.received(); events != 5 {
+		t.Fatalf("close should deliver pending events, got %d", events)
+	}
+	var nilDispatcher *Dispatcher
+	nilDispatcher.Publish(Event{Type: TypeConnect})
+	nilDispatcher.Close()
+}
+
+func TestDispatcherRedeliversQueued(t *testing.T) {
+	h := newWebhook(t, alwaysOk)
+	c := testConfig(h.URL)
+	c.QueueDir = t.TempDir()
+	// 上次运行落盘的批次
+	queue, _ := newDiskQueue(filepath.Join(c.QueueDir, queueDirName(h.URL)), 10)
+	payload, _ := json.Marshal(batch{Events: []Event{{Type: TypeConnect, PeerId: "queued"}}})
+	if err := queue.push(h.URL, payload); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	d := NewDispatcher(c)
+	defer d.Close()
+	waitFor(t, 2*time.Second, func() bool {
+		_, events := h.received()
+		return events == 1
+	})
+	waitFor(t, time.Second, func() bool {
+		return queuedEvents(t, c.QueueDir, h.URL) == 0
+	})
+}
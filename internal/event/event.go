@@ -0,0 +1,35 @@
+package event
+
+import (
+	"google.golang.org/grpc/codes"
+	"time"
+)
+
+type Type string
+
+const (
+	TypeConnect    Type = "peer.connect"
+	TypeDisconnect Type = "peer.disconnect"
+	TypeCall       Type = "call.complete"
+)
+
+// Event 推送给webhook的生命周期事件，不包含请求/响应数据
+type Event struct {
+	Type     Type      `json:"type"`
+	Time     time.Time `json:"time"`
+	PeerId   string    `json:"peerId"`
+	DeviceId string    `json:"deviceId,omitempty"`
+	ClientIp string    `json:"clientIp,omitempty"`
+	// peer.connect / peer.disconnect
+	ConnectedAt *time.Time `json:"connectedAt,omitempty"`
+	// peer.disconnect 时为连接时长，call.complete 时为调用耗时，单位：毫秒
+	DurationMs int64 `json:"durationMs,omitempty"`
+	// call.complete
+	CallId string     `json:"callId,omitempty"`
+	Method string     `json:"method,omitempty"`
+	Status codes.Code `json:"status,omitempty"`
+}
+
+type batch struct {
+	Events []Event `json:"events"`
+}
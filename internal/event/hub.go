@@ -0,0 +1,49 @@
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+// Hub 进程内的事件订阅，管理接口通过它推送peer上下线
+// 订阅者接收过慢时丢弃事件，不会阻塞发布者
+type Hub struct {
+	lock        sync.RWMutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe 订阅事件，size为缓冲的事件数量；返回的函数用于取消订阅，取消后channel被关闭
+func (h *Hub) Subscribe(size int) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+	h.lock.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.lock.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.lock.Lock()
+			delete(h.subscribers, ch)
+			h.lock.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish 非阻塞地发给所有订阅者
+func (h *Hub) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
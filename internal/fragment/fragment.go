@@ -0,0 +1,175 @@
+package fragment
+
+import (
+	"errors"
+	"github.com/peergoim/signaling-server/internal/types"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidFragment = errors.New("invalid fragment")
+	ErrMessageTooLarge = errors.New("message too large")
+	ErrMemoryExceeded  = errors.New("reassembly memory limit exceeded")
+	ErrFrameTooSmall   = errors.New("max frame size too small to fit a fragment")
+)
+
+const (
+	// MaxFragments 一条消息最多的分片数量
+	MaxFragments = 4096
+	// slotSize 每个分片槽位的内存占用估算
+	slotSize = 24
+)
+
+// Split 把超过maxFrame的请求或响应按Data拆分为多个分片消息，不超过时原样返回
+func Split(typ types.FrameType, data []byte, maxFrame int) ([][]byte, error) {
+	if len(data) <= maxFrame {
+		return [][]byte{data}, nil
+	}
+	if typ == types.FrameRequest {
+		request := &types.CallRequest{}
+		if err := request.FromBytes(data); err != nil {
+			return nil, err
+		}
+		return split(request.Data, maxFrame, func(chunk []byte, f *types.Fragment) []byte {
+			r := *request
+			r.Data, r.Fragment = chunk, f
+			return r.ToBytes()
+		})
+	}
+	response := &types.CallResponse{}
+	if err := response.FromBytes(data); err != nil {
+		return nil, err
+	}
+	return split(response.Data, maxFrame, func(chunk []byte, f *types.Fragment) []byte {
+		r := *response
+		r.Data, r.Fragment = chunk, f
+		return r.ToBytes()
+	})
+}
+
+func split(data []byte, maxFrame int, encode func(chunk []byte, f *types.Fragment) []byte) ([][]byte, error) {
+	// 不带数据的分片消息长度，Data为base64编码，每3个字节编码为4个字符
+	overhead := len(encode([]byte{}, &types.Fragment{Index: len(data), Count: len(data)}))
+	chunkSize := (maxFrame - overhead) / 4 * 3
+	if chunkSize <= 0 {
+		return nil, ErrFrameTooSmall
+	}
+	count := (len(data) + chunkSize - 1) / chunkSize
+	frames := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		frames = append(frames, encode(data[i*chunkSize:end], &types.Fragment{Index: i, Count: count}))
+	}
+	return frames, nil
+}
+
+// Reassembler 重组一个连接上收到的分片消息，限制单条消息大小和正在重组的分片占用的内存
+type Reassembler struct {
+	maxMessage int
+	maxMemory  int
+	timeout    time.Duration
+
+	lock    sync.Mutex
+	pending map[string]*partial
+	memory  int
+}
+
+type partial struct {
+	chunks    [][]byte
+	overhead  int
+	received  int
+	size      int
+	updatedAt time.Time
+}
+
+func NewReassembler(maxMessage int, maxMemory int, timeout time.Duration) *Reassembler {
+	return &Reassembler{
+		maxMessage: maxMessage,
+		maxMemory:  maxMemory,
+		timeout:    timeout,
+		pending:    make(map[string]*partial),
+	}
+}
+
+// Add 添加一个分片，收齐后返回完整数据；出错时丢弃该消息已收到的分片
+func (r *Reassembler) Add(key string, f *types.Fragment, chunk []byte) ([]byte, bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.expire()
+	if f.Count <= 0 || f.Index < 0 || f.Index >= f.Count || f.Count > MaxFragments {
+		r.drop(key)
+		return nil, false, ErrInvalidFragment
+	}
+	p, ok := r.pending[key]
+	if !ok {
+		// 分片槽位也计入内存，防止用很大的Count占用内存
+		overhead := f.Count * slotSize
+		if r.memory+overhead > r.maxMemory {
+			return nil, false, ErrMemoryExceeded
+		}
+		p = &partial{chunks: make([][]byte, f.Count), overhead: overhead}
+		r.pending[key] = p
+		r.memory += overhead
+	}
+	if len(p.chunks) != f.Count {
+		r.drop(key)
+		return nil, false, ErrInvalidFragment
+	}
+	if old := p.chunks[f.Index]; old != nil {
+		// 重复的分片，以后收到的为准
+		p.size -= len(old)
+		r.memory -= len(old)
+		p.received--
+	}
+	if p.size+len(chunk) > r.maxMessage {
+		r.drop(key)
+		return nil, false, ErrMessageTooLarge
+	}
+	if r.memory+len(chunk) > r.maxMemory {
+		r.drop(key)
+		return nil, false, ErrMemoryExceeded
+	}
+	// 空分片也要与未收到区分
+	p.chunks[f.Index] = append(make([]byte, 0, len(chunk)), chunk...)
+	p.size += len(chunk)
+	p.received++
+	p.updatedAt = time.Now()
+	r.memory += len(chunk)
+	if p.received < f.Count {
+		return nil, false, nil
+	}
+	data := make([]byte, 0, p.size)
+	for _, c := range p.chunks {
+		data = append(data, c...)
+	}
+	r.drop(key)
+	return data, true, nil
+}
+
+// Memory 正在重组的分片占用的字节数
+func (r *Reassembler) Memory() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.memory
+}
+
+func (r *Reassembler) drop(key string) {
+	if p, ok := r.pending[key]; ok {
+		r.memory -= p.size + p.overhead
+		delete(r.pending, key)
+	}
+}
+
+// expire 丢弃超时未收齐的消息，调用时需持有r.lock
+func (r *Reassembler) expire() {
+	now := time.Now()
+	for key, p := range r.pending {
+		if now.Sub(p.updatedAt) > r.timeout {
+			r.drop(key)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package fragment
+
+import (
+	"bytes"
+	"errors"
+	"github.com/peergoim/signaling-server/internal/types"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSplitAndReassemble(t *testing.T) {
+	data := make([]byte, 10000)
+	rand.Read(data)
+	request := &types.CallRequest{CallId: "1", Method: "echo", Data: data}
+	frames, err := Split(types.FrameRequest, request.ToBytes(), 2048)
+	if err != nil {
+		t.Fatalf("split: %v", err)
+	}
+	if len(frames) < 2 {
+		t.Fatalf("expected multiple frames, got %d", len(frames))
+	}
+	r := NewReassembler(1<<20, 1<<20, time.Minute)
+	// 乱序到达
+	for i := len(frames) - 1; i >= 0; i-- {
+		if len(frames[i]) > 2048 {
+			t.Fatalf("frame %d exceeds max frame: %d", i, len(frames[i]))
+		}
+		f := &types.CallRequest{}
+		if err = f.FromBytes(frames[i]); err != nil {
+			t.Fatalf("unmarshal frame %d: %v", i, err)
+		}
+		if f.CallId != "1" || f.Method != "echo" || f.Fragment == nil {
+			t.Fatalf("unexpected frame %d: %+v", i, f)
+		}
+		got, complete, err := r.Add(f.CallId, f.Fragment, f.Data)
+		if err != nil {
+			t.Fatalf("add fragment %d: %v", i, err)
+		}
+		if complete != (i == 0) {
+			t.Fatalf("fragment %d: complete = %v", i, complete)
+		}
+		if complete && !bytes.Equal(got, data) {
+			t.Fatal("reassembled data mismatch")
+		}
+	}
+	if r.Memory() != 0 {
+		t.Fatalf("expected memory released, got %d", r.Memory())
+	}
+}
+
+func TestSplitSmallMessage(t *testing.T) {
+	response := (&types.CallResponse{CallId: "1", Data: []byte("hi")}).ToBytes()
+	frames, err := Split(types.FrameResponse, response, 2048)
+	if err != nil || len(frames) != 1 || !bytes.Equal(frames[0], response) {
+		t.Fatalf("expected message unchanged, got %d frames, err %v", len(frames), err)
+	}
+	if _, err = Split(types.FrameResponse, (&types.CallResponse{Data: make([]byte, 100)}).ToBytes(), 60); !errors.Is(err, ErrFrameTooSmall) {
+		t.Fatalf("expected ErrFrameTooSmall, got %v", err)
+	}
+}
+
+func TestReassemblerLimits(t *testing.T) {
+	r := NewReassembler(10, 1000, time.Minute)
+	if _, _, err := r.Add("a", &types.Fragment{Index: 2, Count: 2}, nil); !errors.Is(err, ErrInvalidFragment) {
+		t.Fatalf("expected ErrInvalidFragment, got %v", err)
+	}
+	if _, _, err := r.Add("a", &types.Fragment{Index: 0, Count: 2}, make([]byte, 6)); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, _, err := r.Add("a", &types.Fragment{Index: 1, Count: 3}, nil); !errors.Is(err, ErrInvalidFragment) {
+		t.Fatalf("expected count mismatch to fail, got %v", err)
+	}
+	if r.Memory() != 0 {
+		t.Fatalf("expected partial dropped, got memory %d", r.Memory())
+	}
+	r.Add("a", &types.Fragment{Index: 0, Count: 2}, make([]byte, 6))
+	if _, _, err := r.Add("a", &types.Fragment{Index: 1, Count: 2}, make([]byte, 6)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+	}
+	// 分片槽位计入内存
+	if _, _, err := r.Add("b", &types.Fragment{Index: 0, Count: MaxFragments}, nil); !errors.Is(err, ErrMemoryExceeded) {
+		t.Fatalf("expected ErrMemoryExceeded, got %v", err)
+	}
+}
+
+func TestReassemblerExpire(t *testing.T) {
+	r := NewReassembler(100, 1000, time.Millisecond)
+	r.Add("a", &types.Fragment{Index: 0, Count: 2}, make([]byte, 10))
+	time.Sleep(5 * time.Millisecond)
+	_, complete, err := r.Add("a", &types.Fragment{Index: 1, Count: 2}, make([]byte, 10))
+	if err != nil || complete {
+		t.Fatalf("expected stale partial to expire, got complete %v, err %v", complete, err)
+	}
+	if r.Memory() != 2*slotSize+10 {
+		t.Fatalf("unexpected memory %d", r.Memory())
+	}
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/zeromicro/go-zero/core/conf"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// presenceBufferSize 每个上下线事件订阅者缓冲的事件数量
+	presenceBufferSize = 256
+	presenceHeartbeat  = 15 * time.Second
+)
+
+// ListVirtualPeersHandler 列出所有虚拟peer，不返回签名密钥
+func (h *Handler) ListVirtualPeersHandler(context *gin.Context) {
+	list := h.logic.VirtualPeers()
+	for i := range list {
+		if list[i].Secret != "" {
+			list[i].Secret = "******"
+		}
+	}
+	context.JSON(http.StatusOK, gin.H{"virtualPeers": list})
+}
+
+// PutVirtualPeerHandler 注册或替换虚拟peer，请求体与配置文件中的VirtualPeers元素格式相同
+func (h *Handler) PutVirtualPeerHandler(context *gin.Context) {
+	body, err := io.ReadAll(context.Request.Body)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c := config.VirtualPeerConfig{}
+	// 使用与配置文件相同的加载方式，以便填充默认值
+	if err = conf.LoadFromJsonBytes(body, &c); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err = c.Validate(); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.logic.AddVirtualPeer(c)
+	context.JSON(http.StatusOK, gin.H{"peerId": c.PeerId})
+}
+
+// DeleteVirtualPeerHandler 删除虚拟peer
+func (h *Handler) DeleteVirtualPeerHandler(context *gin.Context) {
+	peerId := context.Param("peerId")
+	if !h.logic.DeleteVirtualPeer(peerId) {
+		context.JSON(http.StatusNotFound, gin.H{"error": "virtual peer not found"})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"peerId": peerId})
+}
+
+// ListDevicesHandler 列出peer在线的设备，peer不在线时返回空列表
+func (h *Handler) ListDevicesHandler(context *gin.Context) {
+	peerId := context.Param("peerId")
+	devices := h.logic.Devices(peerId)
+	context.JSON(http.StatusOK, gin.H{"peerId": peerId, "online": len(devices) > 0, "devices": devices})
+}
+
+// ListPeersHandler 列出所有在线的peer及其设备
+func (h *Handler) ListPeersHandler(context *gin.Context) {
+	peers := h.logic.Peers()
+	context.JSON(http.StatusOK, gin.H{"peers": peers})
+}
+
+// KickPeerHandler 踢下线peer的所有连接，查询参数deviceId不为空时只踢指定设备
+func (h *Handler) KickPeerHandler(context *gin.Context) {
+	peerId := context.Param("peerId")
+	kicked := h.logic.Kick(peerId, context.Query("deviceId"))
+	if kicked == 0 {
+		context.JSON(http.StatusNotFound, gin.H{"error": "peer not online"})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"peerId": peerId, "kicked": kicked})
+}
+
+// PresenceHandler 通过sse推送peer上下线事件，事件格式与webhook相同
+// 连接建立后首先推送 ready 事件；接收过慢时丢弃事件
+func (h *Handler) PresenceHandler(ginContext *gin.Context) {
+	events, cancel := h.svcCtx.Presence.Subscribe(presenceBufferSize)
+	defer cancel()
+	w := ginContext.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	writeSseEvent(w, "ready", []byte("{}"))
+	w.Flush()
+	heartbeat := time.NewTicker(presenceHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ginContext.Request.Context().Done():
+			return
+		case e := <-events:
+			data, _ := json.Marshal(e)
+			writeSseEvent(w, string(e.Type), data)
+			w.Flush()
+		case <-heartbeat.C:
+			_, _ = io.WriteString(w, ": ping\n\n")
+			w.Flush()
+		}
+	}
+}
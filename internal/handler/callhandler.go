@@ -2,20 +2,20 @@ package handler
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/internal/config"
 	"github.com/peergoim/signaling-server/internal/handler/wslogic"
 	"github.com/peergoim/signaling-server/internal/types"
+	"net/http"
 )
 
 func (h *Handler) CallHandler(context *gin.Context) {
-	wsOnce.Do(func() {
-		wslogic.Init(h.svcCtx)
-	})
+	limitBody(context, h.svcCtx.Config().WebSocket.Limits)
 	request := &types.CallRequest{}
 	if err := context.ShouldBindJSON(request); err != nil {
 		context.JSON(200, types.RequestUnmarshalErrorResponse)
 		return
 	}
-	response, err := wslogic.Instance.OnCall(context, request)
+	response, err := h.logic.OnCall(wslogic.WithClientIp(context, h.svcCtx.ClientIp.ClientIp(context.Request)), request)
 	if err != nil {
 		// 设置500
 		context.Writer.WriteHeader(500)
@@ -26,3 +26,8 @@ func (h *Handler) CallHandler(context *gin.Context) {
 	context.Header("Content-Type", "application/json")
 	context.Writer.Write(response)
 }
+
+// limitBody 限制http请求体的长度，超过后读取失败
+func limitBody(context *gin.Context, limits config.LimitsConfig) {
+	context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, limits.MaxBodySize())
+}
@@ -1,13 +1,18 @@
 package handler
 
 import (
+	"github.com/peergoim/signaling-server/internal/handler/wslogic"
 	"github.com/peergoim/signaling-server/internal/svc"
+	"sync"
 )
 
 type Handler struct {
 	svcCtx *svc.ServiceContext
+	logic  *wslogic.Logic
+	// sse、长轮询连接，connectionId -> *streamPeer
+	streamPeers sync.Map
 }
 
-func NewHandler(svcCtx *svc.ServiceContext) *Handler {
-	return &Handler{svcCtx: svcCtx}
+func NewHandler(svcCtx *svc.ServiceContext, logic *wslogic.Logic) *Handler {
+	return &Handler{svcCtx: svcCtx, logic: logic}
 }
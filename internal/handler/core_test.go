@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/handler/wslogic"
+	"github.com/peergoim/signaling-server/internal/svc"
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/logx"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logx.Disable()
+	gin.SetMode(gin.ReleaseMode)
+	os.Exit(m.Run())
+}
+
+// newTestServer 使用默认配置启动只包含peer接口的服务端
+func newTestServer(t *testing.T, configure func(c *config.Config)) (*httptest.Server, *wslogic.Logic) {
+	t.Helper()
+	c := &config.Config{}
+	if err := conf.LoadFromYamlBytes([]byte("Mode: dev\nWebSocket:\n  CallTimeout: 2\n"), c); err != nil {
+		t.Fatalf("load test config: %v", err)
+	}
+	configure(c)
+	if err := c.Validate(); err != nil {
+		t.Fatalf("validate test config: %v", err)
+	}
+	svcCtx := svc.NewServiceContext(c)
+	logic := wslogic.New(svcCtx, wslogic.Hooks{})
+	h := NewHandler(svcCtx, logic)
+	engine := gin.New()
+	engine.GET("/ws", h.WsHandler)
+	engine.POST("/call", h.CallHandler)
+	engine.GET("/sse", h.SseHandler)
+	engine.POST("/poll/connect", h.PollConnectHandler)
+	engine.GET("/poll", h.PollHandler)
+	engine.DELETE("/poll", h.PollDisconnectHandler)
+	engine.POST("/reply", h.ReplyHandler)
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+	return server, logic
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	"github.com/peergoim/signaling-server/internal/transport"
+	"github.com/peergoim/signaling-server/internal/types"
+	"sync"
+	"time"
+)
+
+// sentCalls 已经发给peer、等待响应的callId -> 过期时间，peer只能回复发给自己的请求
+type sentCalls struct {
+	calls map[string]time.Time
+	lock  sync.Mutex
+}
+
+func newSentCalls() *sentCalls {
+	return &sentCalls{calls: make(map[string]time.Time)}
+}
+
+// delivered 记录发给peer的请求，顺便清理超时未回复的callId
+func (c *sentCalls) delivered(frame transport.Frame, timeout time.Duration) {
+	if frame.Type != types.FrameRequest {
+		return
+	}
+	request := &types.CallRequest{}
+	if request.FromBytes(frame.Data) != nil {
+		return
+	}
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for callId, expireAt := range c.calls {
+		if now.After(expireAt) {
+			delete(c.calls, callId)
+		}
+	}
+	c.calls[request.CallId] = now.Add(timeout)
+}
+
+// replied 返回callId是否发给过此peer，每个callId只能回复一次
+func (c *sentCalls) replied(callId string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.calls[callId]; !ok {
+		return false
+	}
+	delete(c.calls, callId)
+	return true
+}
+
+// sentCallsTransport 记录通过此连接发给peer的请求，websocket连接只接受这些请求的回复
+type sentCallsTransport struct {
+	types.PeerTransport
+	calls *sentCalls
+	// 请求发出后等待响应的最长时间，每次发送时读取，配置热加载后生效
+	timeout func() time.Duration
+}
+
+func (t *sentCallsTransport) Send(ctx context.Context, typ types.FrameType, data []byte) error {
+	// 在发出之前记录，peer收到后立即回复也能匹配
+	t.calls.delivered(transport.Frame{Type: typ, Data: data}, t.timeout())
+	return t.PeerTransport.Send(ctx, typ, data)
+}
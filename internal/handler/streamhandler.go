@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/internal/transport"
+	"github.com/peergoim/signaling-server/internal/types"
+	"github.com/peergoim/signaling-server/internal/utils"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamPeer 通过sse或长轮询连接的peer
+type streamPeer struct {
+	conn      *types.PeerConnection
+	transport *transport.PipeTransport
+	// 长轮询连接的空闲计时器，sse连接为nil
+	idleTimer *time.Timer
+	// 启用会话恢复时的会话token
+	sessionToken string
+	// 同一连接的长轮询逐个处理
+	pollLock sync.Mutex
+	// 已经发给peer、等待响应的请求，peer只能回复发给自己的请求
+	calls *sentCalls
+}
+
+type streamFrame struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+func frameTypeName(typ types.FrameType) string {
+	if typ == types.FrameResponse {
+		return "response"
+	}
+	return "request"
+}
+
+// SseHandler peer端通过sse接收请求，通过 ReplyHandler 返回响应
+// 连接建立后首先推送 ready 事件，携带回复时需要的connectionId
+func (h *Handler) SseHandler(ginContext *gin.Context) {
+	peerId, clientIp, ok := h.checkPeer(ginContext)
+	if !ok {
+		return
+	}
+	var (
+		w        = ginContext.Writer
+		r        = ginContext.Request
+		fallback = h.svcCtx.Config().WebSocket.HttpFallback
+	)
+	connectionId, peer, err := h.addStreamPeer(ginContext, peerId, clientIp)
+	if err != nil {
+		writeSessionRejected(ginContext, err)
+		return
+	}
+	defer h.closeStreamPeer(connectionId, "sse closed", false)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// 禁止nginx缓冲
+	w.Header().Set("X-Accel-Buffering", "no")
+	if peer.sessionToken != "" {
+		w.Header().Set(SessionTokenHeader, peer.sessionToken)
+	}
+	w.WriteHeader(http.StatusOK)
+	ready, _ := json.Marshal(gin.H{"connectionId": connectionId, "sessionToken": peer.sessionToken})
+	writeSseEvent(w, "ready", ready)
+	w.Flush()
+
+	callTimeout := h.callTimeout()
+	heartbeat := time.NewTicker(time.Second * time.Duration(fallback.HeartbeatInterval))
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-peer.transport.Done():
+			code, reason := peer.transport.CloseReason()
+			data, _ := json.Marshal(gin.H{"code": code, "reason": reason})
+			writeSseEvent(w, "close", data)
+			w.Flush()
+			return
+		case frame := <-peer.transport.Frames():
+			peer.calls.delivered(frame, callTimeout)
+			writeSseEvent(w, frameTypeName(frame.Type), frame.Data)
+			w.Flush()
+		case <-heartbeat.C:
+			_, _ = io.WriteString(w, ": ping\n\n")
+			w.Flush()
+		}
+	}
+}
+
+// PollConnectHandler 注册长轮询peer，返回connectionId
+func (h *Handler) PollConnectHandler(ginContext *gin.Context) {
+	peerId, clientIp, ok := h.checkPeer(ginContext)
+	if !ok {
+		return
+	}
+	connectionId, peer, err := h.addStreamPeer(ginContext, peerId, clientIp)
+	if err != nil {
+		writeSessionRejected(ginContext, err)
+		return
+	}
+	idleTimeout := time.Second * time.Duration(h.svcCtx.Config().WebSocket.HttpFallback.PollIdleTimeout)
+	peer.idleTimer = time.AfterFunc(idleTimeout, func() {
+		h.closeStreamPeer(connectionId, "poll idle timeout", false)
+	})
+	ginContext.JSON(http.StatusOK, gin.H{"connectionId": connectionId, "sessionToken": peer.sessionToken})
+}
+
+// PollHandler 长轮询，等待发给peer的消息，没有消息时最多等待 PollTimeout 秒
+func (h *Handler) PollHandler(ginContext *gin.Context) {
+	peer, ok := h.getStreamPeer(ginContext)
+	if !ok || peer.idleTimer == nil {
+		ginContext.JSON(http.StatusGone, gin.H{"error": "connection closed"})
+		return
+	}
+	peer.pollLock.Lock()
+	defer peer.pollLock.Unlock()
+	var (
+		fallback    = h.svcCtx.Config().WebSocket.HttpFallback
+		callTimeout = h.callTimeout()
+	)
+	// 轮询期间不计空闲时间，若计时器已触发，下面会从transport.Done()得知连接已关闭
+	peer.idleTimer.Stop()
+	defer peer.idleTimer.Reset(time.Second * time.Duration(fallback.PollIdleTimeout))
+
+	frames := make([]streamFrame, 0)
+	appendFrame := func(frame transport.Frame) {
+		peer.calls.delivered(frame, callTimeout)
+		frames = append(frames, streamFrame{Type: frameTypeName(frame.Type), Data: frame.Data})
+	}
+	select {
+	case frame := <-peer.transport.Frames():
+		appendFrame(frame)
+	case <-peer.transport.Done():
+		code, reason := peer.transport.CloseReason()
+		ginContext.JSON(http.StatusGone, gin.H{"error": "connection closed", "code": code, "reason": reason})
+		return
+	case <-ginContext.Request.Context().Done():
+		return
+	case <-time.After(time.Second * time.Duration(fallback.PollTimeout)):
+	}
+	// 一次取出所有已缓冲的消息
+drain:
+	for len(frames) < fallback.BufferSize {
+		select {
+		case frame := <-peer.transport.Frames():
+			appendFrame(frame)
+		default:
+			break drain
+		}
+	}
+	ginContext.JSON(http.StatusOK, gin.H{"frames": frames})
+}
+
+// PollDisconnectHandler 长轮询peer主动下线
+func (h *Handler) PollDisconnectHandler(ginContext *gin.Context) {
+	connectionId := ginContext.Query("connectionId")
+	if _, ok := h.getStreamPeer(ginContext); !ok {
+		ginContext.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+	h.closeStreamPeer(connectionId, "peer offline", true)
+	ginContext.Status(http.StatusNoContent)
+}
+
+// ReplyHandler sse、长轮询peer返回响应，只能回复发给此连接的请求
+func (h *Handler) ReplyHandler(ginContext *gin.Context) {
+	peer, ok := h.getStreamPeer(ginContext)
+	if !ok {
+		ginContext.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+	limitBody(ginContext, h.svcCtx.Config().WebSocket.Limits)
+	response := &types.CallResponse{}
+	if err := ginContext.ShouldBindJSON(response); err != nil {
+		ginContext.JSON(http.StatusBadRequest, types.RequestUnmarshalErrorResponse)
+		return
+	}
+	if !peer.calls.replied(response.CallId) {
+		ginContext.JSON(http.StatusForbidden, gin.H{"error": "call was not sent to this connection"})
+		return
+	}
+	h.logic.OnReply(ginContext.Request.Context(), response)
+	ginContext.Status(http.StatusNoContent)
+}
+
+// addStreamPeer 注册sse、长轮询peer，被会话策略拒绝时返回错误
+func (h *Handler) addStreamPeer(ginContext *gin.Context, peerId string, clientIp string) (string, *streamPeer, error) {
+	r := ginContext.Request
+	peer := &streamPeer{
+		calls:     newSentCalls(),
+		transport: transport.NewPipeTransport(context.Background(), h.svcCtx.Config().WebSocket.HttpFallback.BufferSize),
+	}
+	peer.conn = &types.PeerConnection{
+		PeerId:      peerId,
+		DeviceId:    ginContext.Query("deviceId"),
+		Methods:     types.NewMethodSet(),
+		Transport:   peer.transport,
+		Headers:     requestHeaders(r),
+		Ctx:         peer.transport.Context(),
+		ConnectedAt: time.Now(),
+		RemoteIp:    r.RemoteAddr,
+		ClientIp:    clientIp,
+	}
+	if methods := ginContext.Query("methods"); methods != "" {
+		peer.conn.Methods.Announce(types.ParseMethods(methods))
+	}
+	// 携带 resumeToken 参数重连可以恢复之前的会话
+	peer.sessionToken = h.logic.ResumeToken(peerId, ginContext.Query("resumeToken"))
+	if _, err := h.logic.Connect(peer.conn, peer.sessionToken); err != nil {
+		return "", nil, err
+	}
+	connectionId := utils.RandomId()
+	h.streamPeers.Store(connectionId, peer)
+	return connectionId, peer, nil
+}
+
+// callTimeout 请求发出后等待响应的最长时间，超过后不再接受回复
+func (h *Handler) callTimeout() time.Duration {
+	return time.Second * time.Duration(h.svcCtx.Config().WebSocket.CallTimeout)
+}
+
+func (h *Handler) getStreamPeer(ginContext *gin.Context) (*streamPeer, bool) {
+	v, ok := h.streamPeers.Load(ginContext.Query("connectionId"))
+	if !ok {
+		return nil, false
+	}
+	return v.(*streamPeer), true
+}
+
+// closeStreamPeer graceful为peer主动下线，此时不保留会话
+func (h *Handler) closeStreamPeer(connectionId string, reason string, graceful bool) {
+	v, ok := h.streamPeers.LoadAndDelete(connectionId)
+	if !ok {
+		return
+	}
+	peer := v.(*streamPeer)
+	_ = peer.transport.Close(types.CloseNormal, reason)
+	h.logic.Disconnect(peer.conn, peer.sessionToken, graceful)
+}
+
+// writeSessionRejected 与websocket的关闭码保持一致
+func writeSessionRejected(ginContext *gin.Context, err error) {
+	ginContext.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": types.CloseSessionRejected})
+}
+
+func writeSseEvent(w io.Writer, event string, data []byte) {
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/types"
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func pollConnect(t *testing.T, serverUrl string, peerId string) string {
+	t.Helper()
+	resp, err := http.Post(serverUrl+"/poll/connect?peerId="+peerId, "application/json", nil)
+	if err != nil {
+		t.Fatalf("poll connect: %v", err)
+	}
+	defer resp.Body.Close()
+	body := struct {
+		ConnectionId string `json:"connectionId"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil || body.ConnectionId == "" {
+		t.Fatalf("unexpected poll connect response %d: %v", resp.StatusCode, err)
+	}
+	return body.ConnectionId
+}
+
+func poll(serverUrl string, connectionId string) ([]streamFrame, int, error) {
+	resp, err := http.Get(serverUrl + "/poll?connectionId=" + connectionId)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body := struct {
+		Frames []streamFrame `json:"frames"`
+	}{}
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	return body.Frames, resp.StatusCode, nil
+}
+
+func reply(t *testing.T, serverUrl string, connectionId string, response *types.CallResponse) int {
+	t.Helper()
+	resp, err := http.Post(serverUrl+"/reply?connectionId="+connectionId, "application/json", bytes.NewReader(response.ToBytes()))
+	if err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+func enableFallback(c *config.Config) {
+	c.WebSocket.HttpFallback = config.HttpFallbackConfig{
+		Enabled: true, BufferSize: 8, HeartbeatInterval: 15, PollTimeout: 1, PollIdleTimeout: 60,
+	}
+}
+
+func TestReplyOnlyToDeliveredCalls(t *testing.T) {
+	server, logic := newTestServer(t, enableFallback)
+	callee := pollConnect(t, server.URL, "callee")
+	other := pollConnect(t, server.URL, "other")
+
+	result := make(chan []byte, 1)
+	go func() {
+		data, _ := logic.OnCall(context.Background(), &types.CallRequest{PeerId: "callee", CallId: "c1", Method: "echo", Data: []byte("hi")})
+		result <- data
+	}()
+	frames, status, err := poll(server.URL, callee)
+	if err != nil || status != http.StatusOK || len(frames) != 1 {
+		t.Fatalf("expected the request frame, got %v, %d, %v", frames, status, err)
+	}
+
+	ok := &types.CallResponse{CallId: "c1", Method: "echo", Status: codes.OK, Data: []byte("hi")}
+	if status = reply(t, server.URL, other, ok); status != http.StatusForbidden {
+		t.Fatalf("another connection must not complete the call, got %d", status)
+	}
+	if status = reply(t, server.URL, callee, &types.CallResponse{CallId: "c2", Method: "echo"}); status != http.StatusForbidden {
+		t.Fatalf("unknown call id should be rejected, got %d", status)
+	}
+	if status = reply(t, server.URL, callee, ok); status != http.StatusNoContent {
+		t.Fatalf("callee reply should be accepted, got %d", status)
+	}
+	response := &types.CallResponse{}
+	if err = response.FromBytes(<-result); err != nil || response.Status != codes.OK || string(response.Data) != "hi" {
+		t.Fatalf("unexpected call result: %+v, %v", response, err)
+	}
+	if status = reply(t, server.URL, callee, ok); status != http.StatusForbidden {
+		t.Fatalf("a call can only be completed once, got %d", status)
+	}
+}
+
+func TestPollsAreSerialized(t *testing.T) {
+	server, _ := newTestServer(t, enableFallback)
+	connectionId := pollConnect(t, server.URL, "callee")
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, status, err := poll(server.URL, connectionId); err != nil || status != http.StatusOK {
+				t.Errorf("poll failed: %d, %v", status, err)
+			}
+		}()
+	}
+	wg.Wait()
+	// 每次轮询最多等待PollTimeout（1秒），逐个处理时共需2秒
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Fatalf("concurrent polls should be serialized, took %s", elapsed)
+	}
+}
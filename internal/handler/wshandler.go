@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/internal/audit"
+	"github.com/peergoim/signaling-server/internal/dispatch"
+	"github.com/peergoim/signaling-server/internal/fragment"
 	"github.com/peergoim/signaling-server/internal/handler/wslogic"
+	"github.com/peergoim/signaling-server/internal/transport"
 	"github.com/peergoim/signaling-server/internal/types"
-	"github.com/peergoim/signaling-server/internal/utils"
 	"github.com/zeromicro/go-zero/core/logx"
 	"github.com/zeromicro/go-zero/core/trace"
 	"go.opentelemetry.io/otel"
@@ -15,45 +18,45 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 	oteltrace "go.opentelemetry.io/otel/trace"
 	"io"
+	"net/http"
 	"nhooyr.io/websocket"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var wsOnce = sync.Once{}
+const (
+	// SessionTokenHeader 启用会话恢复时返回会话token的响应头
+	SessionTokenHeader = "X-Signaling-Session-Token"
+	// MaxFrameHeader 服务端接受的单个websocket消息的最大长度
+	MaxFrameHeader = "X-Signaling-Max-Frame"
+)
 
 // WsHandler peer端调用此接口，升级为websocket连接，接收消息
 func (h *Handler) WsHandler(ginContext *gin.Context) {
-	wsOnce.Do(func() {
-		wslogic.Init(h.svcCtx)
-	})
 	var (
 		w = ginContext.Writer
 		r = ginContext.Request
 	)
 	logger := logx.WithContext(r.Context())
-	headers := make(map[string]string)
-	for k, v := range r.Header {
-		if len(v) > 0 {
-			headers[k] = strings.Join(v, ",")
-		}
+	peerId, clientIp, ok := h.checkPeer(ginContext)
+	if !ok {
+		return
 	}
-	peerId := ginContext.Query("peerId")
-	clientIp := utils.GetClientIp(r)
-	// 连接前的检测
-	{
-		if peerId == "" {
-			logger.Errorf("peerId is empty")
-			ginContext.Redirect(302, "https://www.google.com")
-			return
-		}
-		if !h.svcCtx.Config.WebSocket.IpWhitelist.InIpWhitelist(clientIp) {
-			logger.Errorf("ip %s not in whitelist", clientIp)
-			// 直接重定向到google.com，防止其他人恶意访问
-			ginContext.Redirect(302, "https://www.google.com")
-			return
-		}
+	headers := requestHeaders(r)
+	if origin := r.Header.Get("Origin"); !h.svcCtx.Config().Cors.AllowWebSocketOrigin(h.svcCtx.Config().Mode, origin, r.Host) {
+		logger.Errorf("websocket origin %s not allowed, client ip: %s", origin, clientIp)
+		h.svcCtx.Audit.Log(audit.Record{
+			Type:     audit.TypeAuth,
+			Action:   "origin",
+			Result:   audit.ResultDeny,
+			PeerId:   peerId,
+			ClientIp: clientIp,
+			Reason:   "origin " + origin + " not allowed",
+		})
+		ginContext.AbortWithStatus(http.StatusForbidden)
+		return
 	}
 	compressionMode := websocket.CompressionNoContextTakeover
 	// https://github.com/nhooyr/websocket/issues/218
@@ -61,42 +64,120 @@ func (h *Handler) WsHandler(ginContext *gin.Context) {
 	if strings.Contains(r.UserAgent(), "Safari") {
 		compressionMode = websocket.CompressionDisabled
 	}
+	// 启用会话恢复时，通过响应头返回会话token，断线后携带 resumeToken 参数重连
+	sessionToken := h.logic.ResumeToken(peerId, ginContext.Query("resumeToken"))
+	if sessionToken != "" {
+		w.Header().Set(SessionTokenHeader, sessionToken)
+	}
+	// 单个消息超过此长度时，peer需要拆分为分片发送
+	limits := h.svcCtx.Config().WebSocket.Limits
+	w.Header().Set(MaxFrameHeader, strconv.Itoa(limits.MaxInboundFrame))
+	// Origin已经在上面由 CorsConfig.AllowWebSocketOrigin 校验，Accept再按host校验一次
+	corsConfig := h.svcCtx.Config().Cors
 	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
 		Subprotocols:         nil,
-		InsecureSkipVerify:   true,
-		OriginPatterns:       nil,
+		InsecureSkipVerify:   corsConfig.SkipWebSocketOriginCheck(h.svcCtx.Config().Mode),
+		OriginPatterns:       corsConfig.WebSocketOriginHosts(),
 		CompressionMode:      compressionMode,
 		CompressionThreshold: 0,
 	})
 	if err != nil {
 		return
 	}
-	c.SetReadLimit(32768) // 32k 防止恶意攻击
+	c.SetReadLimit(int64(limits.MaxInboundFrame)) // 防止恶意攻击，更大的消息通过分片发送
 	defer c.Close(websocket.StatusInternalError, "")
 	ctx, cancelFunc := context.WithCancel(r.Context())
+	var peerTransport types.PeerTransport = transport.NewWebSocketTransport(ctx, c)
+	// peer声明支持分片时，超过 MaxOutboundFrame 的消息拆分发送
+	if fragmentation, _ := strconv.ParseBool(ginContext.Query("fragmentation")); fragmentation {
+		peerTransport = transport.NewFragmentTransport(peerTransport, limits.MaxOutboundFrame)
+	}
+	// 由单独的goroutine发送，慢peer不阻塞调用方
+	writeQueue := h.svcCtx.Config().WebSocket.WriteQueue
+	peerTransport = transport.NewQueueTransport(peerTransport, transport.QueueOptions{
+		Size:         writeQueue.Size,
+		Overflow:     writeQueue.Overflow,
+		BlockTimeout: time.Second * time.Duration(writeQueue.BlockTimeout),
+		// 启用会话恢复时，未发出的请求由会话缓冲，恢复后重新发送
+		OnDiscard: func(frame transport.Frame) {
+			if sessionToken == "" {
+				h.logic.OnUndelivered(frame.Data)
+			}
+		},
+	})
+	// 记录发给此连接的请求，只接受这些请求的回复
+	calls := newSentCalls()
+	peerTransport = &sentCallsTransport{PeerTransport: peerTransport, calls: calls, timeout: h.callTimeout}
+	reassembler := fragment.NewReassembler(limits.MaxMessageSize, limits.MaxReassemblyMemory,
+		time.Second*time.Duration(limits.FragmentTimeout))
 	peerConn := &types.PeerConnection{
-		WenSocketConnection: c,
-		Headers:             headers,
-		Ctx:                 ctx,
-		ConnectedAt:         time.Now(),
-		RemoteIp:            r.RemoteAddr,
-		ClientIp:            clientIp,
-		PeerId:              peerId,
+		Transport:   peerTransport,
+		Headers:     headers,
+		Ctx:         ctx,
+		ConnectedAt: time.Now(),
+		RemoteIp:    r.RemoteAddr,
+		ClientIp:    clientIp,
+		PeerId:      peerId,
+		DeviceId:    ginContext.Query("deviceId"),
+		Methods:     types.NewMethodSet(),
+	}
+	// 连接时可以通过 methods 参数声明提供的方法，如 methods=echo,upload@2
+	if methods := ginContext.Query("methods"); methods != "" {
+		peerConn.Methods.Announce(types.ParseMethods(methods))
 	}
+	// peer主动关闭连接时不保留会话
+	var peerClosed atomic.Bool
 	wsReturn := func(ctx context.Context, conn *types.PeerConnection, resp *types.CallResponse) {
-		_ = conn.WenSocketConnection.Write(ctx, websocket.MessageBinary, resp.ToBytes())
+		_ = conn.Transport.Send(ctx, types.FrameResponse, resp.ToBytes())
+	}
+	// 请求并发处理，读取不会被慢请求阻塞
+	dispatchConfig := h.svcCtx.Config().WebSocket.Dispatch
+	dispatcher := dispatch.NewDispatcher(dispatchConfig.Concurrency, dispatchConfig.OrderedMethods)
+	handleCall := func(conn *types.PeerConnection, request *types.CallRequest) {
+		spanName := "WsHandler/" + request.Method
+		tracer := otel.Tracer(trace.TraceName)
+		propagator := otel.GetTextMapPropagator()
+		// 连接断开时取消，Disconnect之前等待所有请求结束
+		ctx := propagator.Extract(ctx, propagation.MapCarrier{
+			"data":      string(request.Data),
+			"callId":    request.CallId,
+			"peerId":    request.PeerId,
+			"clientIp":  clientIp,
+			"connectAt": peerConn.ConnectedAt.Format("2006-01-02 15:04:05.000"),
+		})
+		spanCtx, span := tracer.Start(
+			ctx,
+			spanName,
+			oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+			oteltrace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(
+				"signaling-server", spanName, r)...),
+		)
+		data, err := h.logic.OnCall(wslogic.WithCaller(spanCtx, conn), request)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetStatus(codes.Ok, "")
+		}
+		// 写入数据
+		if len(data) > 0 {
+			if err := conn.Transport.Send(ctx, types.FrameResponse, data); err != nil {
+				logger.Errorf("failed to write message: %v", err)
+			}
+		}
+		span.End()
 	}
 	loopRead := func(ctx context.Context, cancelFunc context.CancelFunc, conn *types.PeerConnection) {
 		defer cancelFunc()
 		for {
 			logx.WithContext(ctx).Debugf("start read")
-			typ, msg, err := conn.WenSocketConnection.Read(ctx)
+			typ, msg, err := c.Read(ctx)
 			if err != nil {
 				if errors.Is(err, io.EOF) {
 					// 正常关闭
 				} else if websocket.CloseStatus(err) == websocket.StatusNormalClosure ||
 					websocket.CloseStatus(err) == websocket.StatusGoingAway {
 					// 正常关闭
+					peerClosed.Store(true)
 					logx.Infof("websocket closed: %v", err)
 				} else if strings.Contains(err.Error(), "connection reset by peer") {
 					// 网络断开
@@ -122,7 +203,26 @@ func (h *Handler) WsHandler(ginContext *gin.Context) {
 					logx.WithContext(ctx).Errorf("failed to unmarshal response: %v", err)
 					return
 				}
-				wslogic.Instance.OnReply(ctx, response)
+				if response.Fragment != nil {
+					data, complete, err := reassembler.Add("response/"+response.CallId, response.Fragment, response.Data)
+					if err != nil {
+						// 通知调用方，不必等到超时
+						logx.WithContext(ctx).Errorf("failed to reassemble response %s: %v", response.CallId, err)
+						if calls.replied(response.CallId) {
+							h.logic.OnReply(ctx, fragmentErrorResponse(response.CallId, response.Method, err))
+						}
+						continue
+					}
+					if !complete {
+						continue
+					}
+					response.Data, response.Fragment = data, nil
+				}
+				if !calls.replied(response.CallId) {
+					logx.WithContext(ctx).Errorf("peer %s replied to call %s which was not sent to this connection", conn.PeerId, response.CallId)
+					continue
+				}
+				h.logic.OnReply(ctx, response)
 			} else if typ == websocket.MessageBinary {
 				// 请求
 				logx.WithContext(ctx).Debugf("read message.length: %d", len(msg))
@@ -138,44 +238,37 @@ func (h *Handler) WsHandler(ginContext *gin.Context) {
 					wsReturn(ctx, peerConn, resp)
 					continue
 				}
-				spanName := "WsHandler/" + request.Method
-				tracer := otel.Tracer(trace.TraceName)
-				propagator := otel.GetTextMapPropagator()
-				ctx := propagator.Extract(r.Context(), propagation.MapCarrier{
-					"data":      string(request.Data),
-					"callId":    request.CallId,
-					"peerId":    request.PeerId,
-					"clientIp":  clientIp,
-					"connectAt": peerConn.ConnectedAt.Format("2006-01-02 15:04:05.000"),
-				})
-				spanCtx, span := tracer.Start(
-					ctx,
-					spanName,
-					oteltrace.WithSpanKind(oteltrace.SpanKindServer),
-					oteltrace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(
-						"signaling-server", spanName, r)...),
-				)
-				var data []byte
-				data, err = wslogic.Instance.OnCall(spanCtx, request)
-				if err != nil {
-					span.SetStatus(codes.Error, err.Error())
-				} else {
-					span.SetStatus(codes.Ok, "")
-				}
-				// 写入数据
-				if len(data) > 0 {
-					err = conn.WenSocketConnection.Write(ctx, websocket.MessageText, data)
+				if request.Fragment != nil {
+					data, complete, err := reassembler.Add("request/"+request.CallId, request.Fragment, request.Data)
 					if err != nil {
-						logger.Errorf("failed to write message: %v", err)
+						wsReturn(ctx, peerConn, fragmentErrorResponse(request.CallId, request.Method, err))
+						continue
+					}
+					if !complete {
+						continue
 					}
+					request.Data, request.Fragment = data, nil
+				}
+				if !dispatcher.Dispatch(request.Method, func() { handleCall(conn, request) }) {
+					wsReturn(ctx, peerConn, types.ResourceExhaustedResponse(request.CallId, request.Method, []string{"too many concurrent calls"}))
 				}
-				span.End()
 			}
 		}
 	}
 	subscribe := func(ctx context.Context, peerConn *types.PeerConnection) error {
-		wslogic.Instance.AddSubscriber(peerConn)
-		defer wslogic.Instance.DeleteSubscriber(peerConn)
+		if _, err := h.logic.Connect(peerConn, sessionToken); err != nil {
+			// 被会话策略拒绝，连接已经携带原因码关闭
+			logger.Infof("peer %s rejected: %v", peerConn.PeerId, err)
+			return nil
+		}
+		defer func() {
+			// 此时ctx已取消，正在处理的请求结束后再下线
+			dispatcher.Close()
+			dispatcher.Wait()
+			h.logic.Disconnect(peerConn, sessionToken, peerClosed.Load())
+		}()
+		// 上线之后再开始读取，恢复会话时Connect会替换peerConn.Methods
+		go loopRead(ctx, cancelFunc, peerConn)
 		for {
 			select {
 			case <-ctx.Done():
@@ -183,7 +276,6 @@ func (h *Handler) WsHandler(ginContext *gin.Context) {
 			}
 		}
 	}
-	go loopRead(ctx, cancelFunc, peerConn)
 	err = subscribe(ctx, peerConn)
 	if errors.Is(err, context.Canceled) {
 		return
@@ -197,3 +289,58 @@ func (h *Handler) WsHandler(ginContext *gin.Context) {
 		return
 	}
 }
+
+// checkPeer 连接前的检测，不通过时已经写入了响应；ip过滤由 middleware.IpFilter 完成
+func (h *Handler) checkPeer(ginContext *gin.Context) (peerId string, clientIp string, ok bool) {
+	logger := logx.WithContext(ginContext.Request.Context())
+	peerId = ginContext.Query("peerId")
+	clientIp = h.svcCtx.ClientIp.ClientIp(ginContext.Request)
+	if tlsConfig := &h.svcCtx.Config().WebSocket.Tls; tlsConfig.PeerIdFromCert {
+		// 启用mTLS身份时，peerId以客户端证书为准
+		certPeerId, ok := tlsConfig.PeerIdFromRequest(ginContext.Request)
+		if !ok || (peerId != "" && peerId != certPeerId) {
+			logger.Errorf("peerId %s does not match client certificate %s", peerId, certPeerId)
+			h.svcCtx.Audit.Log(audit.Record{
+				Type:     audit.TypeAuth,
+				Action:   "cert",
+				Result:   audit.ResultDeny,
+				PeerId:   peerId,
+				ClientIp: clientIp,
+				Reason:   "peerId does not match client certificate",
+			})
+			ginContext.AbortWithStatus(http.StatusForbidden)
+			return "", "", false
+		}
+		peerId = certPeerId
+	}
+	if peerId == "" {
+		logger.Errorf("peerId is empty")
+		ginContext.Redirect(302, "https://www.google.com")
+		return "", "", false
+	}
+	// "/" 用于 peerId/deviceId 寻址
+	if strings.Contains(peerId, "/") || strings.Contains(ginContext.Query("deviceId"), "/") {
+		logger.Errorf("invalid peerId %s or deviceId %s", peerId, ginContext.Query("deviceId"))
+		ginContext.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "peerId and deviceId must not contain '/'"})
+		return "", "", false
+	}
+	return peerId, clientIp, true
+}
+
+// fragmentErrorResponse 分片重组失败，消息过大或超过内存限制时返回ResourceExhausted
+func fragmentErrorResponse(callId string, method string, err error) *types.CallResponse {
+	if errors.Is(err, fragment.ErrInvalidFragment) {
+		return types.InvalidArgumentResponse(callId, method, []string{err.Error()})
+	}
+	return types.ResourceExhaustedResponse(callId, method, []string{err.Error()})
+}
+
+func requestHeaders(r *http.Request) map[string]string {
+	headers := make(map[string]string)
+	for k, v := range r.Header {
+		if len(v) > 0 {
+			headers[k] = strings.Join(v, ",")
+		}
+	}
+	return headers
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"context"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/types"
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"nhooyr.io/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dialPeer(t *testing.T, serverUrl string, peerId string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(serverUrl, "http")+"/ws?peerId="+peerId, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", peerId, err)
+	}
+	t.Cleanup(func() { _ = conn.Close(websocket.StatusNormalClosure, "") })
+	return conn
+}
+
+// readRequest 读取服务端转发来的请求
+func readRequest(t *testing.T, conn *websocket.Conn) *types.CallRequest {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	typ, data, err := conn.Read(ctx)
+	if err != nil || typ != websocket.MessageBinary {
+		t.Fatalf("expected a request, got %v, %v", typ, err)
+	}
+	request := &types.CallRequest{}
+	if err = request.FromBytes(data); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+	return request
+}
+
+func TestWebSocketOriginCheck(t *testing.T) {
+	server, _ := newTestServer(t, func(c *config.Config) {
+		c.Mode = "pro"
+		c.Cors.AllowOrigins = []string{"*"}
+		c.Cors.OriginPatterns = []string{"https://*.example.com"}
+	})
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?peerId="
+	tests := []struct {
+		origin   string
+		expected int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{server.URL, http.StatusSwitchingProtocols},
+		{"https://web.example.com", http.StatusSwitchingProtocols},
+		{"http://web.example.com", http.StatusForbidden},
+		{"https://evil.com", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	}
+	for i, test := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		header := http.Header{}
+		if test.origin != "" {
+			header.Set("Origin", test.origin)
+		}
+		conn, resp, err := websocket.Dial(ctx, wsUrl+"peer-"+string(rune('a'+i)), &websocket.DialOptions{HTTPHeader: header})
+		cancel()
+		if resp == nil || resp.StatusCode != test.expected {
+			t.Fatalf("origin %q: expected %d, got %v, %v", test.origin, test.expected, resp, err)
+		}
+		if conn != nil {
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+		}
+	}
+}
+
+func TestSlowCallDoesNotBlockReplies(t *testing.T) {
+	server, logic := newTestServer(t, func(c *config.Config) {})
+	slow := dialPeer(t, server.URL, "slow")
+	alice := dialPeer(t, server.URL, "alice")
+	ctx := context.Background()
+
+	// alice调用从不回复的slow
+	request := &types.CallRequest{PeerId: "slow", CallId: "c1", Method: "echo"}
+	if err := alice.Write(ctx, websocket.MessageBinary, request.ToBytes()); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+	if got := readRequest(t, slow); got.CallId != "c1" {
+		t.Fatalf("expected c1, got %s", got.CallId)
+	}
+
+	// 同一连接上的响应不被正在等待的请求阻塞
+	result := make(chan []byte, 1)
+	go func() {
+		data, _ := logic.OnCall(ctx, &types.CallRequest{PeerId: "alice", CallId: "c2", Method: "echo"})
+		result <- data
+	}()
+	got := readRequest(t, alice)
+	response := &types.CallResponse{CallId: got.CallId, Method: got.Method, Status: codes.OK}
+	if err := alice.Write(ctx, websocket.MessageText, response.ToBytes()); err != nil {
+		t.Fatalf("write response: %v", err)
+	}
+	select {
+	case data := <-result:
+		if err := response.FromBytes(data); err != nil || response.Status != codes.OK {
+			t.Fatalf("unexpected response %s, %v", data, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the reply was blocked by the pending call")
+	}
+
+	// 断开时取消正在等待的请求，不必等到超时才下线
+	_ = alice.Close(websocket.StatusNormalClosure, "")
+	deadline := time.Now().Add(time.Second)
+	for len(logic.Devices("alice")) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("alice should go offline without waiting for the pending call")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebSocketReplyOnlyToSentCalls(t *testing.T) {
+	server, logic := newTestServer(t, func(c *config.Config) {})
+	callee := dialPeer(t, server.URL, "callee")
+	other := dialPeer(t, server.URL, "other")
+	ctx := context.Background()
+
+	result := make(chan []byte, 1)
+	go func() {
+		data, _ := logic.OnCall(ctx, &types.CallRequest{PeerId: "callee", CallId: "c1", Method: "echo", Data: []byte("hi")})
+		result <- data
+	}()
+	if got := readRequest(t, callee); got.CallId != "c1" {
+		t.Fatalf("expected c1, got %s", got.CallId)
+	}
+
+	// 其他连接不能回复发给callee的请求
+	forged := &types.CallResponse{CallId: "c1", Method: "echo", Status: codes.OK, Data: []byte("forged")}
+	if err := other.Write(ctx, websocket.MessageText, forged.ToBytes()); err != nil {
+		t.Fatalf("write forged response: %v", err)
+	}
+	select {
+	case data := <-result:
+		t.Fatalf("a reply from another connection should be dropped, got %s", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ok := &types.CallResponse{CallId: "c1", Method: "echo", Status: codes.OK, Data: []byte("hi")}
+	if err := callee.Write(ctx, websocket.MessageText, ok.ToBytes()); err != nil {
+		t.Fatalf("write response: %v", err)
+	}
+	response := &types.CallResponse{}
+	select {
+	case data := <-result:
+		if err := response.FromBytes(data); err != nil || response.Status != codes.OK || string(response.Data) != "hi" {
+			t.Fatalf("unexpected call result: %s, %v", data, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the callee reply should complete the call")
+	}
+}
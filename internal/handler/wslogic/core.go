@@ -2,58 +2,149 @@ package wslogic
 
 import (
 	"context"
+	"errors"
+	"github.com/peergoim/signaling-server/internal/audit"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/event"
+	"github.com/peergoim/signaling-server/internal/registry"
 	"github.com/peergoim/signaling-server/internal/svc"
 	"github.com/peergoim/signaling-server/internal/types"
 	"github.com/peergoim/signaling-server/internal/utils"
 	"github.com/zeromicro/go-zero/core/logx"
-	"nhooyr.io/websocket"
+	"google.golang.org/grpc/codes"
+	"sort"
 	"sync"
 	"time"
 )
 
-type wsLogic struct {
+// Logic 信令服务的业务逻辑：peer上下线、请求转发和响应回传，每个服务端实例一个
+type Logic struct {
 	svcCtx              *svc.ServiceContext
-	peerConnections     map[string][]*types.PeerConnection
-	peerConnectionsLock sync.RWMutex
+	hooks               Hooks
+	handle              CallHandler // 包裹了拦截器的onCall
+	serverMethods       map[string]CallHandler
+	peerConnections     *registry.Registry
 	callResponseChannel sync.Map
+	virtualPeers        map[string]*virtualPeer
+	virtualPeersLock    sync.RWMutex
+	sessions            map[string]*session
+	sessionsLock        sync.Mutex
+	closed              bool // 由sessionsLock保护
 }
 
-var Instance *wsLogic
+// ErrSessionRejected peerId的会话策略为oldest，且已有连接在线
+var ErrSessionRejected = errors.New("peer already has an active session")
 
-func Init(svcCtx *svc.ServiceContext) {
-	Instance = &wsLogic{
+func New(svcCtx *svc.ServiceContext, hooks Hooks) *Logic {
+	l := &Logic{
 		svcCtx:          svcCtx,
-		peerConnections: make(map[string][]*types.PeerConnection),
+		hooks:           hooks,
+		peerConnections: registry.New(),
+		virtualPeers:    make(map[string]*virtualPeer),
+		sessions:        make(map[string]*session),
 	}
+	l.serverMethods = l.newServerMethods(hooks.ServerMethods)
+	l.handle = l.newCallHandler(hooks.Interceptors)
+	for _, c := range svcCtx.Config().VirtualPeers {
+		l.AddVirtualPeer(c)
+	}
+	svcCtx.OnConfigReload(l.reloadVirtualPeers)
+	return l
+}
+
+// reloadVirtualPeers 同步配置文件中的虚拟peer，通过管理接口添加的虚拟peer不受影响
+func (l *Logic) reloadVirtualPeers(old *config.Config, new *config.Config) {
+	next := make(map[string]config.VirtualPeerConfig, len(new.VirtualPeers))
+	for _, c := range new.VirtualPeers {
+		next[c.PeerId] = c
+	}
+	for _, c := range old.VirtualPeers {
+		if _, ok := next[c.PeerId]; !ok {
+			l.DeleteVirtualPeer(c.PeerId)
+		}
+	}
+	for _, c := range old.VirtualPeers {
+		if n, ok := next[c.PeerId]; ok && n == c {
+			delete(next, c.PeerId)
+		}
+	}
+	for _, c := range next {
+		l.AddVirtualPeer(c)
+	}
+}
+
+// OnCall 处理一个请求，经过拦截器后转发给peer或由服务端处理，返回序列化的响应
+func (l *Logic) OnCall(ctx context.Context, request *types.CallRequest) ([]byte, error) {
+	if request.PeerId == "" && isServerMethod(request.Method) {
+		request.PeerId = ServerPeerId
+	}
+	resp, err := l.handle(ctx, request)
+	return resp.ToBytes(), err
+}
+
+func (l *Logic) onCall(ctx context.Context, request *types.CallRequest) (*types.CallResponse, error) {
+	if err := request.Validate(); err != nil {
+		return types.InvalidArgumentResponse(request.CallId, request.Method, []string{err.Error()}), types.InvalidArgumentResponseError
+	}
+	// 0. 服务端自身提供的方法
+	if isServerMethod(request.Method) {
+		return l.callServer(ctx, request)
+	}
+	// 按方法配置的schema在转发前后校验数据
+	methodSchema, ok := l.svcCtx.Config().SchemaOf(request.Method)
+	if !ok {
+		return l.forward(ctx, request)
+	}
+	if errs := methodSchema.ValidateRequest(request.Data); len(errs) > 0 {
+		return types.InvalidArgumentResponse(request.CallId, request.Method, errs), types.InvalidArgumentResponseError
+	}
+	resp, err := l.forward(ctx, request)
+	if err != nil || resp.Status != codes.OK {
+		return resp, err
+	}
+	if errs := methodSchema.ValidateResponse(resp.Data); len(errs) > 0 {
+		logx.WithContext(ctx).Errorf("peer %s returned invalid response for %s: %v", request.PeerId, request.Method, errs)
+		return types.InvalidResponse(request.CallId, request.Method, errs), types.InvalidResponseError
+	}
+	return resp, nil
 }
 
-func (l *wsLogic) OnCall(ctx context.Context, request *types.CallRequest) ([]byte, error) {
+// forward 把请求转发给虚拟peer或peer的连接，等待响应
+func (l *Logic) forward(ctx context.Context, request *types.CallRequest) (*types.CallResponse, error) {
 	var (
 		callId = request.CallId
-		peerId = request.PeerId
-
-		peerConnections []*types.PeerConnection
+		// peerId/deviceId 只发给指定设备
+		peerId, deviceId = types.SplitPeerAddress(request.PeerId)
 
-		peerConnection *types.PeerConnection
+		deviceOnline bool
 	)
-	// 1. 从peerConnections中获取peerId对应的所有连接
-	{
-		l.peerConnectionsLock.RLock()
-		if v, ok := l.peerConnections[peerId]; !ok {
-			l.peerConnectionsLock.RUnlock()
-			return types.PeerOfflineResponse(request.CallId, request.Method).ToBytes(), types.PeerOfflineResponseError
-		} else {
-			peerConnections = v
-			l.peerConnectionsLock.RUnlock()
+	// 0. 虚拟peer，通过webhook转发；虚拟peer没有设备，按设备寻址时视为设备不在线
+	if vp, ok := l.getVirtualPeer(peerId); ok {
+		if deviceId != "" {
+			return types.PeerOfflineResponse(request.CallId, request.Method), types.PeerOfflineResponseError
 		}
-		if len(peerConnections) == 0 {
-			return types.PeerOfflineResponse(request.CallId, request.Method).ToBytes(), types.PeerOfflineResponseError
+		return l.callVirtualPeer(ctx, vp, request)
+	}
+	// 1. 从peerId对应的所有连接中选择一个
+	peerConnection, ok := l.peerConnections.Select(peerId, func(conns []*types.PeerConnection) *types.PeerConnection {
+		if deviceId != "" {
+			devices := make([]*types.PeerConnection, 0, 1)
+			for _, c := range conns {
+				if c.DeviceId == deviceId {
+					devices = append(devices, c)
+				}
+			}
+			conns = devices
 		}
-		// 真随机取一个peerConnection
-		min := 0
-		max := len(peerConnections)
-		index := utils.RealRandInt(min, max)
-		peerConnection = peerConnections[index]
+		deviceOnline = len(conns) > 0
+		return selectConnection(conns, request.Method)
+	})
+	if !ok || !deviceOnline {
+		return types.PeerOfflineResponse(request.CallId, request.Method), types.PeerOfflineResponseError
+	}
+	if peerConnection == nil {
+		// 所有连接都声明了方法列表，且都不提供此方法，不必等到超时
+		return types.UnimplementedResponse(request.CallId, request.Method), types.UnimplementedResponseError
 	}
 	// 创建一个响应channel，注册
 	{
@@ -62,59 +153,206 @@ func (l *wsLogic) OnCall(ctx context.Context, request *types.CallRequest) ([]byt
 		// 2. 注册到peerConnection
 		l.registerCallResponseChannel(callId, ch)
 		// 3. 发送请求
-		err := peerConnection.WenSocketConnection.Write(ctx, websocket.MessageBinary, request.ToBytes())
+		complete, err := sendCall(ctx, peerConnection, request)
+		defer complete()
 		if err != nil {
-			return types.PeerOfflineResponse(request.CallId, request.Method).ToBytes(), types.PeerOfflineResponseError
+			l.unregisterCallResponseChannel(callId)
+			return types.PeerOfflineResponse(request.CallId, request.Method), types.PeerOfflineResponseError
 		}
 		// 4. 等待响应
 		select {
-		case <-time.After(time.Second * time.Duration(l.svcCtx.Config.WebSocket.CallTimeout)):
+		case <-time.After(time.Second * time.Duration(l.svcCtx.Config().WebSocket.CallTimeout)):
 			// 超时
 			l.unregisterCallResponseChannel(callId)
-			return types.CallTimeoutResponse(request.CallId, request.Method).ToBytes(), types.CallTimeoutResponseError
+			return types.CallTimeoutResponse(request.CallId, request.Method), types.CallTimeoutResponseError
+		case <-ctx.Done():
+			// 调用方已断开
+			l.unregisterCallResponseChannel(callId)
+			return types.CallTimeoutResponse(request.CallId, request.Method), ctx.Err()
 		case resp := <-ch:
 			// 收到响应
 			l.unregisterCallResponseChannel(callId)
-			return resp.ToBytes(), nil
+			return resp, nil
+		}
+	}
+}
+
+// selectConnection 优先选择声明提供method的连接，其次是未声明方法的连接，都没有时返回nil
+func selectConnection(peerConnections []*types.PeerConnection, method string) *types.PeerConnection {
+	serving := make([]*types.PeerConnection, 0, len(peerConnections))
+	unknown := make([]*types.PeerConnection, 0)
+	for _, c := range peerConnections {
+		if !c.Methods.Announced() {
+			unknown = append(unknown, c)
+		} else if c.Methods.Serves(method) {
+			serving = append(serving, c)
 		}
 	}
+	if len(serving) == 0 {
+		serving = unknown
+	}
+	if len(serving) == 0 {
+		return nil
+	}
+	// 真随机取一个peerConnection
+	return serving[utils.RealRandInt(0, len(serving))]
 }
 
-func (l *wsLogic) AddSubscriber(conn *types.PeerConnection) {
-	// peer端上线，加入到peerConnections
-	l.peerConnectionsLock.Lock()
-	peerId := conn.PeerId
-	if _, ok := l.peerConnections[peerId]; !ok {
-		l.peerConnections[peerId] = make([]*types.PeerConnection, 0)
+// AddSubscriber peer上线，按peerId的会话策略处理已有的连接
+// 策略为oldest且已有连接在线时，关闭新连接并返回 ErrSessionRejected；断开后保留中的会话不算在线
+func (l *Logic) AddSubscriber(conn *types.PeerConnection) error {
+	if conn.Methods == nil {
+		conn.Methods = types.NewMethodSet()
+	}
+	mode := registry.AddMultiple
+	switch l.svcCtx.Config().WebSocket.SessionPolicyOf(conn.PeerId) {
+	case config.SessionPolicyNewest:
+		mode = registry.AddReplace
+	case config.SessionPolicyOldest:
+		mode = registry.AddIfAbsent
+		l.expireDetachedSessions(conn.PeerId)
+	}
+	replaced, ok := l.peerConnections.Add(conn, mode)
+	if !ok {
+		_ = conn.Transport.Close(types.CloseSessionRejected, "session exists")
+		l.svcCtx.Audit.Log(audit.Record{
+			Type:     audit.TypeConnect,
+			Result:   audit.ResultDeny,
+			PeerId:   conn.PeerId,
+			DeviceId: conn.DeviceId,
+			ClientIp: conn.ClientIp,
+			Reason:   ErrSessionRejected.Error(),
+		})
+		return ErrSessionRejected
+	}
+	for _, c := range replaced {
+		_ = c.Transport.Close(types.CloseSessionReplaced, "session replaced")
+		l.publishDisconnect(c)
+	}
+	connectedAt := conn.ConnectedAt
+	l.publishPresence(event.Event{
+		Type:        event.TypeConnect,
+		PeerId:      conn.PeerId,
+		DeviceId:    conn.DeviceId,
+		ClientIp:    conn.ClientIp,
+		ConnectedAt: &connectedAt,
+	})
+	l.svcCtx.Audit.Log(audit.Record{
+		Type:     audit.TypeConnect,
+		Result:   audit.ResultAllow,
+		PeerId:   conn.PeerId,
+		DeviceId: conn.DeviceId,
+		ClientIp: conn.ClientIp,
+	})
+	if l.hooks.OnConnect != nil {
+		l.hooks.OnConnect(conn)
 	}
-	l.peerConnections[peerId] = append(l.peerConnections[peerId], conn)
-	l.peerConnectionsLock.Unlock()
+	return nil
 }
 
-func (l *wsLogic) DeleteSubscriber(conn *types.PeerConnection) {
+func (l *Logic) DeleteSubscriber(conn *types.PeerConnection) {
 	// peer端下线，从peerConnections删除
-	l.peerConnectionsLock.Lock()
-	if _, ok := l.peerConnections[conn.PeerId]; !ok {
+	if !l.peerConnections.Remove(conn) {
+		// 已经下线或被新连接替换
 		return
 	}
-	tmp := make([]*types.PeerConnection, 0)
-	for _, c := range l.peerConnections[conn.PeerId] {
-		if c != conn {
-			tmp = append(tmp, c)
-		} else {
-			// 关闭websocket连接
-			_ = c.WenSocketConnection.Close(websocket.StatusNormalClosure, "peer offline")
+	// 关闭连接
+	_ = conn.Transport.Close(types.CloseNormal, "peer offline")
+	l.publishDisconnect(conn)
+}
+
+func (l *Logic) publishDisconnect(conn *types.PeerConnection) {
+	connectedAt := conn.ConnectedAt
+	l.publishPresence(event.Event{
+		Type:        event.TypeDisconnect,
+		PeerId:      conn.PeerId,
+		DeviceId:    conn.DeviceId,
+		ClientIp:    conn.ClientIp,
+		ConnectedAt: &connectedAt,
+		DurationMs:  time.Since(conn.ConnectedAt).Milliseconds(),
+	})
+	l.svcCtx.Audit.Log(audit.Record{
+		Type:       audit.TypeDisconnect,
+		PeerId:     conn.PeerId,
+		DeviceId:   conn.DeviceId,
+		ClientIp:   conn.ClientIp,
+		DurationMs: time.Since(conn.ConnectedAt).Milliseconds(),
+	})
+	if l.hooks.OnDisconnect != nil {
+		l.hooks.OnDisconnect(conn)
+	}
+}
+
+// publishPresence 上下线事件同时推送给webhook和管理接口的订阅者
+func (l *Logic) publishPresence(e event.Event) {
+	e.Time = time.Now()
+	l.svcCtx.Events.Publish(e)
+	l.svcCtx.Presence.Publish(e)
+}
+
+// Devices 返回peer所有在线的设备，按上线时间排序；peer不在线时返回空列表
+func (l *Logic) Devices(peerId string) []types.DeviceInfo {
+	conns := l.peerConnections.Get(peerId)
+	devices := make([]types.DeviceInfo, 0, len(conns))
+	for _, c := range conns {
+		devices = append(devices, c.DeviceInfo())
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ConnectedAt.Before(devices[j].ConnectedAt)
+	})
+	return devices
+}
+
+// Peers 返回所有在线的peer及其设备，按peerId排序
+func (l *Logic) Peers() []types.PeerInfo {
+	peers := make([]types.PeerInfo, 0, l.peerConnections.Len())
+	l.peerConnections.Range(func(peerId string, conns []*types.PeerConnection) bool {
+		devices := make([]types.DeviceInfo, 0, len(conns))
+		for _, c := range conns {
+			devices = append(devices, c.DeviceInfo())
+		}
+		sort.Slice(devices, func(i, j int) bool {
+			return devices[i].ConnectedAt.Before(devices[j].ConnectedAt)
+		})
+		peers = append(peers, types.PeerInfo{PeerId: peerId, Devices: devices})
+		return true
+	})
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].PeerId < peers[j].PeerId
+	})
+	return peers
+}
+
+// Kick 踢下线peer的所有连接，deviceId不为空时只踢指定设备，返回踢下线的连接数量
+// 会话一并删除，peer不能通过resumeToken恢复
+func (l *Logic) Kick(peerId string, deviceId string) int {
+	kicked := 0
+	for _, conn := range l.peerConnections.Get(peerId) {
+		if deviceId != "" && conn.DeviceId != deviceId {
+			continue
+		}
+		l.sessionsLock.Lock()
+		if s := l.sessionOf(conn); s != nil {
+			if s.expireTimer != nil {
+				s.expireTimer.Stop()
+			}
+			delete(l.sessions, s.token)
 		}
+		l.sessionsLock.Unlock()
+		if !l.peerConnections.Remove(conn) {
+			continue
+		}
+		_ = conn.Transport.Close(types.CloseKicked, "kicked")
+		l.publishDisconnect(conn)
+		kicked++
 	}
-	if len(tmp) == 0 {
-		delete(l.peerConnections, conn.PeerId)
-	} else {
-		l.peerConnections[conn.PeerId] = tmp
+	if kicked > 0 {
+		logx.Infof("peer %s kicked %d connections", peerId, kicked)
 	}
-	l.peerConnectionsLock.Unlock()
+	return kicked
 }
 
-func (l *wsLogic) OnReply(ctx context.Context, response *types.CallResponse) {
+func (l *Logic) OnReply(ctx context.Context, response *types.CallResponse) {
 	defer func() {
 		if err := recover(); err != nil {
 			logx.WithContext(ctx).Errorf("OnReply panic: %v", err)
@@ -131,10 +369,80 @@ func (l *wsLogic) OnReply(ctx context.Context, response *types.CallResponse) {
 	}
 }
 
-func (l *wsLogic) registerCallResponseChannel(id string, ch chan *types.CallResponse) {
+// OnUndelivered 请求没有发给peer（连接断开时还在发送队列中），等待响应的调用方立即收到Unavailable
+func (l *Logic) OnUndelivered(data []byte) {
+	request := &types.CallRequest{}
+	if err := request.FromBytes(data); err != nil {
+		return
+	}
+	ch, ok := l.callResponseChannel.Load(request.CallId)
+	if !ok {
+		return
+	}
+	select {
+	case ch.(chan *types.CallResponse) <- types.PeerOfflineResponse(request.CallId, request.Method):
+	default:
+		// 已经收到了响应
+	}
+}
+
+func (l *Logic) registerCallResponseChannel(id string, ch chan *types.CallResponse) {
 	l.callResponseChannel.Store(id, ch)
 }
 
-func (l *wsLogic) unregisterCallResponseChannel(id string) {
+func (l *Logic) unregisterCallResponseChannel(id string) {
 	l.callResponseChannel.Delete(id)
 }
+
+func (l *Logic) callVirtualPeer(ctx context.Context, vp *virtualPeer, request *types.CallRequest) (*types.CallResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(l.svcCtx.Config().WebSocket.CallTimeout))
+	defer cancel()
+	resp, err := vp.call(ctx, request)
+	if err != nil && resp == nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return types.CallTimeoutResponse(request.CallId, request.Method), types.CallTimeoutResponseError
+		}
+		logx.WithContext(ctx).Errorf("virtual peer %s call failed: %v", vp.config.PeerId, err)
+		return types.PeerOfflineResponse(request.CallId, request.Method), types.PeerOfflineResponseError
+	}
+	return resp, err
+}
+
+// AddVirtualPeer 注册或替换一个虚拟peer
+func (l *Logic) AddVirtualPeer(c config.VirtualPeerConfig) {
+	l.virtualPeersLock.Lock()
+	defer l.virtualPeersLock.Unlock()
+	l.virtualPeers[c.PeerId] = newVirtualPeer(c)
+}
+
+// DeleteVirtualPeer 删除虚拟peer，返回是否存在
+func (l *Logic) DeleteVirtualPeer(peerId string) bool {
+	l.virtualPeersLock.Lock()
+	defer l.virtualPeersLock.Unlock()
+	if _, ok := l.virtualPeers[peerId]; !ok {
+		return false
+	}
+	delete(l.virtualPeers, peerId)
+	return true
+}
+
+// VirtualPeers 返回所有虚拟peer的配置
+func (l *Logic) VirtualPeers() []config.VirtualPeerConfig {
+	l.virtualPeersLock.RLock()
+	defer l.virtualPeersLock.RUnlock()
+	list := make([]config.VirtualPeerConfig, 0, len(l.virtualPeers))
+	for _, vp := range l.virtualPeers {
+		list = append(list, vp.config)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].PeerId < list[j].PeerId
+	})
+	return list
+}
+
+func (l *Logic) getVirtualPeer(peerId string) (*virtualPeer, bool) {
+	l.virtualPeersLock.RLock()
+	defer l.virtualPeersLock.RUnlock()
+	vp, ok := l.virtualPeers[peerId]
+	return vp, ok
+}
@@ -0,0 +1,674 @@
+package wslogic
+
+import (
+	"context"
+	"fmt"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/event"
+	"github.com/peergoim/signaling-server/internal/svc"
+	"github.com/peergoim/signaling-server/internal/transport"
+	"github.com/peergoim/signaling-server/internal/types"
+	"github.com/zeromicro/go-zero/core/conf"
+	"google.golang.org/grpc/codes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestLogic(t *testing.T) *Logic {
+	t.Helper()
+	return newTestLogicWithConfig(t, func(c *config.WebSocketConfig) {})
+}
+
+func newTestLogicWithConfig(t *testing.T, configure func(c *config.WebSocketConfig)) *Logic {
+	t.Helper()
+	c := newTestConfig(t, func(c *config.Config) {
+		configure(&c.WebSocket)
+	})
+	return New(svc.NewServiceContext(c), Hooks{})
+}
+
+// newTestConfig 与配置文件相同地填充默认值，CallTimeout为1秒，configure修改后校验
+// 新增的配置项有默认值时不需要修改已有的测试
+func newTestConfig(t *testing.T, configure func(c *config.Config)) *config.Config {
+	t.Helper()
+	c := &config.Config{}
+	if err := conf.LoadFromYamlBytes([]byte("Mode: dev\nWebSocket:\n  CallTimeout: 1\n"), c); err != nil {
+		t.Fatalf("load test config: %v", err)
+	}
+	configure(c)
+	if err := c.Validate(); err != nil {
+		t.Fatalf("validate test config: %v", err)
+	}
+	return c
+}
+
+func newTestPeer(t *testing.T, l *Logic, peerId string) (*types.PeerConnection, *transport.PipeTransport) {
+	t.Helper()
+	return newTestDevice(t, l, peerId, "")
+}
+
+// newTestDevice deviceId在注册前设置，与真实连接相同
+func newTestDevice(t *testing.T, l *Logic, peerId string, deviceId string) (*types.PeerConnection, *transport.PipeTransport) {
+	t.Helper()
+	pipe := transport.NewPipeTransport(context.Background(), 8)
+	conn := &types.PeerConnection{
+		PeerId:      peerId,
+		DeviceId:    deviceId,
+		Transport:   pipe,
+		Ctx:         pipe.Context(),
+		ConnectedAt: time.Now(),
+	}
+	l.AddSubscriber(conn)
+	t.Cleanup(func() {
+		l.DeleteSubscriber(conn)
+	})
+	return conn, pipe
+}
+
+// serveEcho 模拟peer：读取转发来的请求，原样返回数据
+func serveEcho(l *Logic, pipe *transport.PipeTransport) {
+	for {
+		frame, err := pipe.Recv(context.Background())
+		if err != nil {
+			return
+		}
+		request := &types.CallRequest{}
+		if request.FromBytes(frame.Data) != nil {
+			continue
+		}
+		l.OnReply(context.Background(), &types.CallResponse{
+			CallId: request.CallId,
+			Method: request.Method,
+			Status: codes.OK,
+			Data:   request.Data,
+		})
+	}
+}
+
+func call(t *testing.T, l *Logic, request *types.CallRequest) (*types.CallResponse, error) {
+	t.Helper()
+	data, err := l.OnCall(context.Background(), request)
+	response := &types.CallResponse{}
+	if e := response.FromBytes(data); e != nil {
+		t.Fatalf("unmarshal response: %v", e)
+	}
+	return response, err
+}
+
+type callResult struct {
+	response *types.CallResponse
+	err      error
+}
+
+// callAsync 在新的goroutine中调用，其中不能使用t.Fatal，反序列化失败也通过err返回
+func callAsync(l *Logic, request *types.CallRequest) <-chan callResult {
+	result := make(chan callResult, 1)
+	go func() {
+		data, err := l.OnCall(context.Background(), request)
+		response := &types.CallResponse{}
+		if e := response.FromBytes(data); e != nil && err == nil {
+			err = fmt.Errorf("unmarshal response: %w", e)
+		}
+		result <- callResult{response: response, err: err}
+	}()
+	return result
+}
+
+// waitFor 等待条件成立，超时则失败
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// sessionBuffered 会话断开期间缓冲的消息数量
+func sessionBuffered(l *Logic, token string) int {
+	l.sessionsLock.Lock()
+	s, ok := l.sessions[token]
+	l.sessionsLock.Unlock()
+	if !ok {
+		return 0
+	}
+	return int(s.transport.Stats().FramesSent)
+}
+
+func TestOnCallPeerOffline(t *testing.T) {
+	l := newTestLogic(t)
+	response, err := call(t, l, &types.CallRequest{PeerId: "nobody", CallId: "1", Method: "ping"})
+	if err != types.PeerOfflineResponseError {
+		t.Fatalf("expected peer offline error, got %v", err)
+	}
+	if response.Status != codes.Unavailable || response.CallId != "1" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestOnCallForwardsAndReplies(t *testing.T) {
+	l := newTestLogic(t)
+	_, pipe := newTestPeer(t, l, "callee")
+	go serveEcho(l, pipe)
+
+	response, err := call(t, l, &types.CallRequest{PeerId: "callee", CallId: "2", Method: "echo", Data: []byte("hello")})
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if response.Status != codes.OK || string(response.Data) != "hello" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if stats := pipe.Stats(); stats.FramesSent != 1 {
+		t.Fatalf("expected 1 frame sent, got %d", stats.FramesSent)
+	}
+}
+
+func TestOnCallTimeout(t *testing.T) {
+	l := newTestLogic(t)
+	// peer在线但从不回复
+	newTestPeer(t, l, "silent")
+
+	response, err := call(t, l, &types.CallRequest{PeerId: "silent", CallId: "3", Method: "echo"})
+	if err != types.CallTimeoutResponseError {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if response.Status != codes.DeadlineExceeded {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestOnCallClosedTransport(t *testing.T) {
+	l := newTestLogic(t)
+	_, pipe := newTestPeer(t, l, "closed")
+	_ = pipe.Close(types.CloseGoingAway, "gone")
+
+	_, err := call(t, l, &types.CallRequest{PeerId: "closed", CallId: "4", Method: "echo"})
+	if err != types.PeerOfflineResponseError {
+		t.Fatalf("expected peer offline error, got %v", err)
+	}
+}
+
+func TestOnCallFailsUndeliveredRequest(t *testing.T) {
+	l := newTestLogic(t)
+	// peer不读取，请求停留在发送队列中
+	pipe := transport.NewPipeTransport(context.Background(), 0)
+	queue := transport.NewQueueTransport(pipe, transport.QueueOptions{Size: 8, Overflow: transport.OverflowBlock,
+		BlockTimeout: time.Second, OnDiscard: func(frame transport.Frame) { l.OnUndelivered(frame.Data) }})
+	conn := &types.PeerConnection{PeerId: "stuck", Transport: queue, Ctx: queue.Context(), ConnectedAt: time.Now()}
+	_ = l.AddSubscriber(conn)
+	t.Cleanup(func() { l.DeleteSubscriber(conn) })
+
+	// 发送goroutine卡在第一个消息上
+	_ = queue.Send(context.Background(), types.FrameRequest, []byte("blocker"))
+	waitFor(t, time.Second, func() bool { return queue.Stats().Queued == 0 })
+	result := callAsync(l, &types.CallRequest{PeerId: "stuck", CallId: "5", Method: "echo"})
+	waitFor(t, time.Second, func() bool { return queue.Stats().Queued == 1 })
+	_ = pipe.Close(types.CloseGoingAway, "gone")
+	select {
+	case r := <-result:
+		if r.response.Status != codes.Unavailable {
+			t.Fatalf("expected unavailable, got %+v, %v", r.response, r.err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("an undelivered request should fail without waiting for the call timeout")
+	}
+}
+
+func TestDeleteSubscriberClosesTransport(t *testing.T) {
+	l := newTestLogic(t)
+	conn, pipe := newTestPeer(t, l, "leaving")
+	l.DeleteSubscriber(conn)
+
+	select {
+	case <-pipe.Done():
+	case <-time.After(time.Second):
+		t.Fatal("transport not closed")
+	}
+	if code, reason := pipe.CloseReason(); code != types.CloseNormal || reason != "peer offline" {
+		t.Fatalf("unexpected close reason: %d %s", code, reason)
+	}
+	if _, err := call(t, l, &types.CallRequest{PeerId: "leaving", CallId: "5", Method: "echo"}); err != types.PeerOfflineResponseError {
+		t.Fatalf("expected peer offline error, got %v", err)
+	}
+}
+
+func newResumeTestLogic(t *testing.T, graceWindow int) *Logic {
+	t.Helper()
+	return newTestLogicWithConfig(t, func(c *config.WebSocketConfig) {
+		c.CallTimeout = 2
+		c.Resume.Enabled, c.Resume.GraceWindow, c.Resume.BufferSize = true, graceWindow, 8
+	})
+}
+
+func newTestSessionPeer(l *Logic, peerId string, token string) (*types.PeerConnection, *transport.PipeTransport, bool) {
+	pipe := transport.NewPipeTransport(context.Background(), 8)
+	conn := &types.PeerConnection{
+		PeerId:      peerId,
+		Transport:   pipe,
+		Ctx:         pipe.Context(),
+		ConnectedAt: time.Now(),
+	}
+	resumed, _ := l.Connect(conn, token)
+	return conn, pipe, resumed
+}
+
+func TestResumeDeliversBufferedFrames(t *testing.T) {
+	l := newResumeTestLogic(t, 5)
+	token := l.ResumeToken("mobile", "")
+	conn, pipe, _ := newTestSessionPeer(l, "mobile", token)
+	// 网络断开
+	_ = pipe.Close(types.CloseGoingAway, "network lost")
+	l.Disconnect(conn, token, false)
+
+	result := callAsync(l, &types.CallRequest{PeerId: "mobile", CallId: "r1", Method: "echo", Data: []byte("hi")})
+	// 等待请求进入会话的缓冲
+	waitFor(t, time.Second, func() bool {
+		return sessionBuffered(l, token) == 1
+	})
+
+	if got := l.ResumeToken("mobile", token); got != token {
+		t.Fatalf("expected token %s to be resumable, got %s", token, got)
+	}
+	_, pipe, resumed := newTestSessionPeer(l, "mobile", token)
+	if !resumed {
+		t.Fatal("expected session to be resumed")
+	}
+	go serveEcho(l, pipe)
+	if r := <-result; r.err != nil || r.response.Status != codes.OK || string(r.response.Data) != "hi" {
+		t.Fatalf("unexpected response: %+v, %v", r.response, r.err)
+	}
+}
+
+func TestResumeReplaysInFlightCalls(t *testing.T) {
+	l := newResumeTestLogic(t, 5)
+	token := l.ResumeToken("mobile", "")
+	conn, pipe, _ := newTestSessionPeer(l, "mobile", token)
+
+	result := callAsync(l, &types.CallRequest{PeerId: "mobile", CallId: "r2", Method: "echo", Data: []byte("again")})
+	// 请求已经发出，peer回复前断开
+	if _, err := pipe.Recv(context.Background()); err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	_ = pipe.Close(types.CloseGoingAway, "network lost")
+	l.Disconnect(conn, token, false)
+
+	_, pipe, _ = newTestSessionPeer(l, "mobile", token)
+	go serveEcho(l, pipe)
+	if r := <-result; r.err != nil || r.response.Status != codes.OK || string(r.response.Data) != "again" {
+		t.Fatalf("unexpected response: %+v, %v", r.response, r.err)
+	}
+}
+
+func TestSessionExpires(t *testing.T) {
+	l := newResumeTestLogic(t, 1)
+	token := l.ResumeToken("mobile", "")
+	conn, pipe, _ := newTestSessionPeer(l, "mobile", token)
+	_ = pipe.Close(types.CloseGoingAway, "network lost")
+	l.Disconnect(conn, token, false)
+
+	// GraceWindow为1秒
+	waitFor(t, 3*time.Second, func() bool {
+		return l.ResumeToken("mobile", token) != token
+	})
+	if _, err := call(t, l, &types.CallRequest{PeerId: "mobile", CallId: "r3", Method: "echo"}); err != types.PeerOfflineResponseError {
+		t.Fatalf("expected peer offline error, got %v", err)
+	}
+}
+
+func TestResumeAfterExpireTimerFired(t *testing.T) {
+	l := newResumeTestLogic(t, 60)
+	token := l.ResumeToken("mobile", "")
+	conn, pipe, _ := newTestSessionPeer(l, "mobile", token)
+	_ = pipe.Close(types.CloseGoingAway, "network lost")
+	l.Disconnect(conn, token, false)
+
+	// 计时器已经触发，但expireSession还没有拿到锁时恢复会话
+	fired, release := make(chan struct{}), make(chan struct{})
+	l.sessionsLock.Lock()
+	s := l.sessions[token]
+	s.expireTimer.Stop()
+	s.expireTimer = time.AfterFunc(0, func() {
+		close(fired)
+		<-release
+		l.expireSession(s)
+	})
+	l.sessionsLock.Unlock()
+	<-fired
+
+	_, pipe, resumed := newTestSessionPeer(l, "mobile", token)
+	close(release)
+	if resumed {
+		t.Fatal("an expired session should not be resumed")
+	}
+	go serveEcho(l, pipe)
+	// expireSession不能删除新的会话
+	time.Sleep(50 * time.Millisecond)
+	if response, err := call(t, l, &types.CallRequest{PeerId: "mobile", CallId: "r4", Method: "echo", Data: []byte("new")}); err != nil || string(response.Data) != "new" {
+		t.Fatalf("the new session should be online, got %+v, %v", response, err)
+	}
+	if devices := l.Devices("mobile"); len(devices) != 1 {
+		t.Fatalf("expected only the new session, got %+v", devices)
+	}
+}
+
+func TestGracefulDisconnectEndsSession(t *testing.T) {
+	l := newResumeTestLogic(t, 5)
+	token := l.ResumeToken("mobile", "")
+	conn, _, _ := newTestSessionPeer(l, "mobile", token)
+	l.Disconnect(conn, token, true)
+
+	if _, _, resumed := newTestSessionPeer(l, "mobile", l.ResumeToken("mobile", token)); resumed {
+		t.Fatal("expected a new session after graceful disconnect")
+	}
+}
+
+func TestKickEndsSession(t *testing.T) {
+	l := newResumeTestLogic(t, 5)
+	events, cancel := l.svcCtx.Presence.Subscribe(4)
+	defer cancel()
+	token := l.ResumeToken("mobile", "")
+	conn, pipe, _ := newTestSessionPeer(l, "mobile", token)
+
+	if kicked := l.Kick("mobile", ""); kicked != 1 {
+		t.Fatalf("expected 1 kicked connection, got %d", kicked)
+	}
+	if code, _ := pipe.CloseReason(); code != types.CloseKicked {
+		t.Fatalf("expected close code %d, got %d", types.CloseKicked, code)
+	}
+	l.Disconnect(conn, token, false)
+	if got := l.ResumeToken("mobile", token); got == token {
+		t.Fatal("kicked session should not be resumable")
+	}
+	if len(l.Peers()) != 0 || l.Kick("mobile", "") != 0 {
+		t.Fatal("expected peer to be offline")
+	}
+	if e := <-events; e.Type != event.TypeConnect {
+		t.Fatalf("expected connect event, got %s", e.Type)
+	}
+	if e := <-events; e.Type != event.TypeDisconnect || e.PeerId != "mobile" {
+		t.Fatalf("expected disconnect event, got %+v", e)
+	}
+}
+
+func TestCloseEndsDetachedSessions(t *testing.T) {
+	l := newResumeTestLogic(t, 60)
+	detachedToken := l.ResumeToken("mobile", "")
+	detached, pipe, _ := newTestSessionPeer(l, "mobile", detachedToken)
+	_ = pipe.Close(types.CloseGoingAway, "network lost")
+	l.Disconnect(detached, detachedToken, false)
+	attachedToken := l.ResumeToken("desktop", "")
+	attached, _, _ := newTestSessionPeer(l, "desktop", attachedToken)
+
+	// 不必等到GraceWindow结束
+	l.Close()
+	if len(l.Devices("mobile")) != 0 || len(l.Devices("desktop")) != 1 {
+		t.Fatalf("only the detached session should end, got %+v", l.Peers())
+	}
+	// 关闭后断开的连接不再保留会话
+	l.Disconnect(attached, attachedToken, false)
+	if len(l.Peers()) != 0 || l.ResumeToken("desktop", attachedToken) == attachedToken {
+		t.Fatalf("expected no sessions after close, got %+v", l.Peers())
+	}
+}
+
+func TestSessionOfRegisteredConnection(t *testing.T) {
+	l := newResumeTestLogic(t, 5)
+	token := l.ResumeToken("mobile", "")
+	conn, _, _ := newTestSessionPeer(l, "mobile", token)
+	registered := l.peerConnections.Get("mobile")
+	if len(registered) != 1 || registered[0].SessionToken != token {
+		t.Fatalf("the registered connection should carry the session token, got %+v", registered)
+	}
+	l.sessionsLock.Lock()
+	defer l.sessionsLock.Unlock()
+	if s := l.sessionOf(registered[0]); s == nil || s.token != token {
+		t.Fatal("expected the session of the registered connection")
+	}
+	// 只有注册的会话连接属于会话，携带相同token的其他连接不算
+	if l.sessionOf(conn) != nil || l.sessionOf(&types.PeerConnection{PeerId: "mobile", SessionToken: token}) != nil {
+		t.Fatal("only the registered session connection belongs to the session")
+	}
+}
+
+func newPolicyTestLogic(t *testing.T) *Logic {
+	t.Helper()
+	return newTestLogicWithConfig(t, func(c *config.WebSocketConfig) {
+		c.SessionPolicies = []config.SessionPolicyConfig{
+			{PeerIds: []string{"phone-*"}, Policy: config.SessionPolicyNewest},
+			{PeerIds: []string{"kiosk-*"}, Policy: config.SessionPolicyOldest},
+		}
+	})
+}
+
+func TestSessionPolicyNewestReplacesOlder(t *testing.T) {
+	l := newPolicyTestLogic(t)
+	_, older := newTestPeer(t, l, "phone-1")
+	_, newer := newTestPeer(t, l, "phone-1")
+	go serveEcho(l, newer)
+
+	select {
+	case <-older.Done():
+	case <-time.After(time.Second):
+		t.Fatal("older connection not closed")
+	}
+	if code, reason := older.CloseReason(); code != types.CloseSessionReplaced || reason != "session replaced" {
+		t.Fatalf("unexpected close reason: %d %s", code, reason)
+	}
+	response, err := call(t, l, &types.CallRequest{PeerId: "phone-1", CallId: "p1", Method: "echo", Data: []byte("new")})
+	if err != nil || string(response.Data) != "new" {
+		t.Fatalf("expected call to reach the newer connection, got %+v %v", response, err)
+	}
+}
+
+func TestSessionPolicyOldestRejectsNewcomer(t *testing.T) {
+	l := newPolicyTestLogic(t)
+	_, older := newTestPeer(t, l, "kiosk-1")
+	pipe := transport.NewPipeTransport(context.Background(), 8)
+	err := l.AddSubscriber(&types.PeerConnection{PeerId: "kiosk-1", Transport: pipe, Ctx: pipe.Context()})
+	if err != ErrSessionRejected {
+		t.Fatalf("expected session rejected, got %v", err)
+	}
+	if code, _ := pipe.CloseReason(); code != types.CloseSessionRejected {
+		t.Fatalf("unexpected close code: %d", code)
+	}
+	select {
+	case <-older.Done():
+		t.Fatal("older connection should stay online")
+	default:
+	}
+}
+
+func TestSessionPolicyOldestReplacesDetachedSession(t *testing.T) {
+	l := newTestLogicWithConfig(t, func(c *config.WebSocketConfig) {
+		c.Resume.Enabled, c.Resume.GraceWindow = true, 30
+		c.SessionPolicy = config.SessionPolicyOldest
+	})
+	token := l.ResumeToken("kiosk-1", "")
+	conn, pipe, _ := newTestSessionPeer(l, "kiosk-1", token)
+	// 在线时拒绝新连接
+	if _, rejected, _ := newTestSessionPeer(l, "kiosk-1", l.ResumeToken("kiosk-1", "")); rejected.Context().Err() == nil {
+		t.Fatal("a newcomer should be rejected while the session is attached")
+	}
+
+	_ = pipe.Close(types.CloseGoingAway, "network lost")
+	l.Disconnect(conn, token, false)
+	// 不携带token重连，保留期内的旧会话不算在线
+	newConn, newPipe, resumed := newTestSessionPeer(l, "kiosk-1", l.ResumeToken("kiosk-1", ""))
+	if resumed || newPipe.Context().Err() != nil {
+		t.Fatal("a reconnect without token should replace the detached session")
+	}
+	if devices := l.Devices("kiosk-1"); len(devices) != 1 || devices[0].ConnectedAt != newConn.ConnectedAt {
+		t.Fatalf("expected only the new connection, got %+v", devices)
+	}
+	if got := l.ResumeToken("kiosk-1", token); got == token {
+		t.Fatal("the replaced session should not be resumable")
+	}
+}
+
+func TestSessionPolicyDefaultAllowsMultiple(t *testing.T) {
+	l := newPolicyTestLogic(t)
+	_, first := newTestPeer(t, l, "desktop-1")
+	_, second := newTestPeer(t, l, "desktop-1")
+	for _, pipe := range []*transport.PipeTransport{first, second} {
+		select {
+		case <-pipe.Done():
+			t.Fatal("connection should stay online")
+		default:
+		}
+	}
+}
+
+func TestOnCallAddressesDevice(t *testing.T) {
+	l := newTestLogic(t)
+	_, phonePipe := newTestDevice(t, l, "alice", "phone")
+	_, laptopPipe := newTestDevice(t, l, "alice", "laptop")
+	go serveEcho(l, phonePipe)
+	go serveEcho(l, laptopPipe)
+
+	for i := 0; i < 5; i++ {
+		if _, err := call(t, l, &types.CallRequest{PeerId: "alice/laptop", CallId: "d" + strconv.Itoa(i), Method: "echo"}); err != nil {
+			t.Fatalf("call failed: %v", err)
+		}
+	}
+	if phonePipe.Stats().FramesSent != 0 || laptopPipe.Stats().FramesSent != 5 {
+		t.Fatalf("expected all calls on laptop, phone=%d laptop=%d", phonePipe.Stats().FramesSent, laptopPipe.Stats().FramesSent)
+	}
+	if _, err := call(t, l, &types.CallRequest{PeerId: "alice/tablet", CallId: "d9", Method: "echo"}); err != types.PeerOfflineResponseError {
+		t.Fatalf("expected peer offline error, got %v", err)
+	}
+}
+
+func TestDevicesListsMetadata(t *testing.T) {
+	l := newTestLogic(t)
+	pipe := transport.NewPipeTransport(context.Background(), 8)
+	conn := &types.PeerConnection{
+		PeerId:      "bob",
+		DeviceId:    "desktop",
+		Transport:   pipe,
+		Ctx:         pipe.Context(),
+		ConnectedAt: time.Now(),
+		Headers:     map[string]string{"User-Agent": "test", "Authorization": "Bearer secret"},
+	}
+	_ = l.AddSubscriber(conn)
+	defer l.DeleteSubscriber(conn)
+
+	devices := l.Devices("bob")
+	if len(devices) != 1 || devices[0].DeviceId != "desktop" || devices[0].Headers["User-Agent"] != "test" {
+		t.Fatalf("unexpected devices: %+v", devices)
+	}
+	if _, ok := devices[0].Headers["Authorization"]; ok {
+		t.Fatal("authorization header should not be listed")
+	}
+	if len(l.Devices("nobody")) != 0 {
+		t.Fatal("expected no devices for offline peer")
+	}
+}
+
+func TestOnCallUnimplementedMethodFailsFast(t *testing.T) {
+	l := newTestLogic(t)
+	conn, _ := newTestPeer(t, l, "svc")
+	conn.Methods.Announce([]string{"echo"})
+
+	start := time.Now()
+	response, err := call(t, l, &types.CallRequest{PeerId: "svc", CallId: "m1", Method: "upload"})
+	if err != types.UnimplementedResponseError || response.Status != codes.Unimplemented {
+		t.Fatalf("expected unimplemented, got %+v %v", response, err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("unimplemented method should not wait for the call timeout")
+	}
+}
+
+func TestOnCallPrefersServingConnection(t *testing.T) {
+	l := newTestLogic(t)
+	_, legacyPipe := newTestPeer(t, l, "svc")
+	serving, servingPipe := newTestPeer(t, l, "svc")
+	serving.Methods.Announce([]string{"echo@2"})
+	go serveEcho(l, legacyPipe)
+	go serveEcho(l, servingPipe)
+
+	for i := 0; i < 5; i++ {
+		if _, err := call(t, l, &types.CallRequest{PeerId: "svc", CallId: "m" + strconv.Itoa(i+2), Method: "echo"}); err != nil {
+			t.Fatalf("call failed: %v", err)
+		}
+	}
+	if legacyPipe.Stats().FramesSent != 0 || servingPipe.Stats().FramesSent != 5 {
+		t.Fatalf("expected all calls on serving connection, legacy=%d serving=%d",
+			legacyPipe.Stats().FramesSent, servingPipe.Stats().FramesSent)
+	}
+}
+
+func TestAnnounceMethods(t *testing.T) {
+	l := newTestLogic(t)
+	conn, _ := newTestPeer(t, l, "svc")
+
+	data, err := l.OnCall(WithCaller(context.Background(), conn), &types.CallRequest{
+		PeerId: "$server", CallId: "a1", Method: "$server.announce", Data: []byte(`{"methods":["echo@2","ping"]}`),
+	})
+	if err != nil {
+		t.Fatalf("announce failed: %v", err)
+	}
+	response := &types.CallResponse{}
+	_ = response.FromBytes(data)
+	if response.Status != codes.OK || string(response.Data) != `{"methods":["echo@2","ping"]}` {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	for method, want := range map[string]bool{"echo": true, "echo@2": true, "echo@1": false, "ping@3": true, "upload": false} {
+		if got := conn.Methods.Serves(method); got != want {
+			t.Fatalf("Serves(%s) = %v, want %v", method, got, want)
+		}
+	}
+
+	// 匿名调用方没有连接，无法声明方法
+	if _, err = l.OnCall(context.Background(), &types.CallRequest{PeerId: "$server", CallId: "a2", Method: "$server.announce"}); err != ErrNoCaller {
+		t.Fatalf("expected no caller error, got %v", err)
+	}
+}
+
+func TestOnCallValidatesSchema(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "echo.json")
+	if err := os.WriteFile(file, []byte(`{"type": "object", "required": ["text"]}`), 0644); err != nil {
+		t.Fatalf("write schema: %v", err)
+	}
+	c := newTestConfig(t, func(c *config.Config) {
+		c.Schemas = []config.MethodSchemaConfig{
+			{Method: "echo", RequestSchema: file},
+			{Method: "render", ResponseSchema: file},
+		}
+	})
+	l := New(svc.NewServiceContext(c), Hooks{})
+	_, pipe := newTestPeer(t, l, "svc")
+	go serveEcho(l, pipe)
+
+	if _, err := call(t, l, &types.CallRequest{PeerId: "svc", CallId: "s1", Method: "echo@2", Data: []byte(`{"text": "hi"}`)}); err != nil {
+		t.Fatalf("valid request failed: %v", err)
+	}
+	response, err := call(t, l, &types.CallRequest{PeerId: "svc", CallId: "s2", Method: "echo", Data: []byte(`{}`)})
+	if err != types.InvalidArgumentResponseError || response.Status != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument, got %+v %v", response, err)
+	}
+	if string(response.Data) != `{"errors":["$.text: required property missing"]}` {
+		t.Fatalf("unexpected error list: %s", response.Data)
+	}
+	if pipe.Stats().FramesSent != 1 {
+		t.Fatalf("invalid request should not reach the peer, frames sent: %d", pipe.Stats().FramesSent)
+	}
+	// serveEcho原样返回请求数据，不满足响应的schema
+	response, err = call(t, l, &types.CallRequest{PeerId: "svc", CallId: "s3", Method: "render", Data: []byte(`[]`)})
+	if err != types.InvalidResponseError || response.Status != codes.Internal {
+		t.Fatalf("expected invalid response, got %+v %v", response, err)
+	}
+}
+
+func TestOnCallRejectsInvalidRequest(t *testing.T) {
+	l := newTestLogic(t)
+	response, err := call(t, l, &types.CallRequest{PeerId: "svc", Method: "echo"})
+	if err != types.InvalidArgumentResponseError || response.Status != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument, got %+v %v", response, err)
+	}
+}
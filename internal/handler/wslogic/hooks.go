@@ -0,0 +1,17 @@
+package wslogic
+
+import (
+	"github.com/peergoim/signaling-server/internal/types"
+)
+
+// Hooks 嵌入服务端时注册的回调，为nil的回调不调用
+type Hooks struct {
+	// OnConnect peer上线后调用，断线后恢复会话不会再次调用
+	OnConnect func(conn *types.PeerConnection)
+	// OnDisconnect peer下线（包括被踢下线、会话过期）后调用
+	OnDisconnect func(conn *types.PeerConnection)
+	// Interceptors 在内置拦截器之内按顺序包裹请求的处理，第一个在最外层
+	Interceptors []CallInterceptor
+	// ServerMethods 嵌入方提供的服务端方法，名称不带 $server. 前缀时自动补全，同名时替换内置方法
+	ServerMethods map[string]CallHandler
+}
@@ -0,0 +1,151 @@
+package wslogic
+
+import (
+	"context"
+	"expvar"
+	"github.com/peergoim/signaling-server/internal/audit"
+	"github.com/peergoim/signaling-server/internal/event"
+	"github.com/peergoim/signaling-server/internal/types"
+	"github.com/zeromicro/go-zero/core/logx"
+	"google.golang.org/grpc/codes"
+	"time"
+)
+
+var (
+	callsTotal    = expvar.NewMap("calls_total") // 按响应状态统计的调用次数
+	callsInFlight = expvar.NewInt("calls_in_flight")
+)
+
+// CallHandler 处理一个请求，返回响应
+type CallHandler func(ctx context.Context, request *types.CallRequest) (*types.CallResponse, error)
+
+// CallInterceptor 包裹请求的处理，可以检查、修改请求和响应，也可以不调用next直接返回响应
+// websocket和 /call 接口的请求都会经过拦截器
+type CallInterceptor func(ctx context.Context, request *types.CallRequest, next CallHandler) (*types.CallResponse, error)
+
+// chain 把拦截器依次包裹在handler外面，第一个在最外层
+func chain(interceptors []CallInterceptor, handler CallHandler) CallHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, request *types.CallRequest) (*types.CallResponse, error) {
+			return interceptor(ctx, request, next)
+		}
+	}
+	return handler
+}
+
+// newCallHandler 内置拦截器在最外层：日志 > 指标 > 审计 > 权限，之后是嵌入方注册的拦截器
+func (l *Logic) newCallHandler(interceptors []CallInterceptor) CallHandler {
+	return chain([]CallInterceptor{l.logInterceptor, l.metricsInterceptor, l.auditInterceptor, l.authInterceptor},
+		completing(chain(interceptors, l.onCall)))
+}
+
+// completing 嵌入方的拦截器可能只返回错误，或者返回的响应没有填写callId
+func completing(handler CallHandler) CallHandler {
+	return func(ctx context.Context, request *types.CallRequest) (*types.CallResponse, error) {
+		resp, err := handler(ctx, request)
+		if resp == nil {
+			status := codes.Internal
+			if err == nil {
+				status = codes.OK
+			}
+			resp = &types.CallResponse{Status: status, Data: []byte{}}
+		}
+		if resp.CallId == "" {
+			resp.CallId = request.CallId
+		}
+		if resp.Method == "" {
+			resp.Method = request.Method
+		}
+		return resp, err
+	}
+}
+
+// callerName 调用方的peerId，匿名调用方为空字符串
+func callerName(ctx context.Context) string {
+	if caller, ok := CallerFrom(ctx); ok {
+		return caller.PeerId
+	}
+	return ""
+}
+
+func (l *Logic) logInterceptor(ctx context.Context, request *types.CallRequest, next CallHandler) (*types.CallResponse, error) {
+	start := time.Now()
+	resp, err := next(ctx, request)
+	logger := logx.WithContext(ctx).WithDuration(time.Since(start))
+	if resp.Status == codes.OK {
+		logger.Debugf("call %s of %s from %q: %s", request.Method, request.PeerId, callerName(ctx), resp.Status)
+	} else {
+		logger.Infof("call %s of %s from %q: %s", request.Method, request.PeerId, callerName(ctx), resp.Status)
+	}
+	return resp, err
+}
+
+// metricsInterceptor 统计调用次数，并推送调用完成事件
+func (l *Logic) metricsInterceptor(ctx context.Context, request *types.CallRequest, next CallHandler) (*types.CallResponse, error) {
+	start := time.Now()
+	callsInFlight.Add(1)
+	resp, err := next(ctx, request)
+	callsInFlight.Add(-1)
+	callsTotal.Add(resp.Status.String(), 1)
+	peerId, deviceId := types.SplitPeerAddress(request.PeerId)
+	l.svcCtx.Events.Publish(event.Event{
+		Type:       event.TypeCall,
+		PeerId:     peerId,
+		DeviceId:   deviceId,
+		CallId:     request.CallId,
+		Method:     request.Method,
+		Status:     resp.Status,
+		DurationMs: time.Since(start).Milliseconds(),
+	})
+	return resp, err
+}
+
+// auditInterceptor 记录调用的元数据，不记录请求、响应的数据
+func (l *Logic) auditInterceptor(ctx context.Context, request *types.CallRequest, next CallHandler) (*types.CallResponse, error) {
+	start := time.Now()
+	resp, err := next(ctx, request)
+	peerId, deviceId := types.SplitPeerAddress(request.PeerId)
+	l.svcCtx.Audit.Log(audit.Record{
+		Type:          audit.TypeCall,
+		Result:        resp.Status.String(),
+		PeerId:        peerId,
+		DeviceId:      deviceId,
+		ClientIp:      ClientIpFrom(ctx),
+		Caller:        callerName(ctx),
+		Method:        request.Method,
+		CallId:        request.CallId,
+		DurationMs:    time.Since(start).Milliseconds(),
+		RequestBytes:  len(request.Data),
+		ResponseBytes: len(resp.Data),
+	})
+	return resp, err
+}
+
+// authInterceptor 按 CallAuth 配置检查调用方是否可以调用此peer的方法，启用时每次检查的结果写入审计日志
+func (l *Logic) authInterceptor(ctx context.Context, request *types.CallRequest, next CallHandler) (*types.CallResponse, error) {
+	peerId, deviceId := types.SplitPeerAddress(request.PeerId)
+	auth := l.svcCtx.Config().CallAuth
+	allowed := auth.Allowed(callerName(ctx), peerId, request.Method)
+	if auth.Enabled {
+		result := audit.ResultAllow
+		if !allowed {
+			result = audit.ResultDeny
+		}
+		l.svcCtx.Audit.Log(audit.Record{
+			Type:     audit.TypeAuth,
+			Action:   "call",
+			Result:   result,
+			PeerId:   peerId,
+			DeviceId: deviceId,
+			ClientIp: ClientIpFrom(ctx),
+			Caller:   callerName(ctx),
+			Method:   request.Method,
+			CallId:   request.CallId,
+		})
+	}
+	if !allowed {
+		return types.PermissionDeniedResponse(request.CallId, request.Method), types.PermissionDeniedResponseError
+	}
+	return next(ctx, request)
+}
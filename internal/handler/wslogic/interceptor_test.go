@@ -0,0 +1,140 @@
+package wslogic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/peergoim/signaling-server/internal/audit"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/svc"
+	"github.com/peergoim/signaling-server/internal/types"
+	"google.golang.org/grpc/codes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterceptorsWrapInOrder(t *testing.T) {
+	var order []string
+	record := func(name string) CallInterceptor {
+		return func(ctx context.Context, request *types.CallRequest, next CallHandler) (*types.CallResponse, error) {
+			order = append(order, name+">")
+			resp, err := next(ctx, request)
+			order = append(order, "<"+name)
+			return resp, err
+		}
+	}
+	shortCircuit := func(ctx context.Context, request *types.CallRequest, next CallHandler) (*types.CallResponse, error) {
+		if request.Method == "cached" {
+			return &types.CallResponse{Status: codes.OK, Data: []byte("from cache")}, nil
+		}
+		if request.Method == "broken" {
+			return nil, errors.New("broken")
+		}
+		return next(ctx, request)
+	}
+	c := newTestConfig(t, func(c *config.Config) {})
+	l := New(svc.NewServiceContext(c), Hooks{Interceptors: []CallInterceptor{record("a"), record("b"), shortCircuit}})
+
+	response, err := call(t, l, &types.CallRequest{PeerId: "nobody", CallId: "i1", Method: "cached"})
+	if err != nil || string(response.Data) != "from cache" || response.CallId != "i1" || response.Method != "cached" {
+		t.Fatalf("expected a cached response with the call id filled in, got %+v, %v", response, err)
+	}
+	if got := order; len(got) != 4 || got[0] != "a>" || got[1] != "b>" || got[2] != "<b" || got[3] != "<a" {
+		t.Fatalf("unexpected interceptor order: %v", got)
+	}
+	if response, _ = call(t, l, &types.CallRequest{PeerId: "nobody", CallId: "i2", Method: "echo"}); response.Status != codes.Unavailable {
+		t.Fatalf("expected the call to reach the peer lookup, got %+v", response)
+	}
+	if response, _ = call(t, l, &types.CallRequest{PeerId: "nobody", CallId: "i3", Method: "broken"}); response.Status != codes.Internal || response.CallId != "i3" {
+		t.Fatalf("expected an internal error response, got %+v", response)
+	}
+}
+
+func TestCallAuthInterceptor(t *testing.T) {
+	c := newTestConfig(t, func(c *config.Config) {
+		c.CallAuth = config.CallAuthConfig{
+			Enabled:                true,
+			AllowUnverifiedCallers: true,
+			DefaultAction:          config.CallAuthDeny,
+			Rules: []config.CallAuthRuleConfig{
+				{Callers: []string{config.AnonymousCaller}, Methods: []string{"admin.*"}, Action: config.CallAuthDeny},
+				{Callers: []string{config.AnonymousCaller, "app-*"}, PeerIds: []string{"callee"}, Action: config.CallAuthAllow},
+			},
+		}
+	})
+	l := New(svc.NewServiceContext(c), Hooks{})
+	_, pipe := newTestPeer(t, l, "callee")
+	go serveEcho(l, pipe)
+
+	if response, err := call(t, l, &types.CallRequest{PeerId: "callee", CallId: "a1", Method: "echo@2"}); err != nil || response.Status != codes.OK {
+		t.Fatalf("anonymous echo should be allowed: %+v, %v", response, err)
+	}
+	if _, err := call(t, l, &types.CallRequest{PeerId: "callee", CallId: "a2", Method: "admin.reset"}); err != types.PermissionDeniedResponseError {
+		t.Fatalf("anonymous admin call should be denied, got %v", err)
+	}
+	app := WithCaller(context.Background(), &types.PeerConnection{PeerId: "app-1"})
+	if data, err := l.OnCall(app, &types.CallRequest{PeerId: "callee/phone", CallId: "a3", Method: "admin.reset"}); err == types.PermissionDeniedResponseError {
+		t.Fatalf("app caller should be allowed: %s", data)
+	}
+	other := WithCaller(context.Background(), &types.PeerConnection{PeerId: "other"})
+	if _, err := l.OnCall(other, &types.CallRequest{PeerId: "callee", CallId: "a4", Method: "echo"}); err != types.PermissionDeniedResponseError {
+		t.Fatalf("unknown caller should be denied by default, got %v", err)
+	}
+}
+
+func TestAuditRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	c := newTestConfig(t, func(c *config.Config) {
+		c.CallAuth = config.CallAuthConfig{
+			Enabled:                true,
+			AllowUnverifiedCallers: true,
+			DefaultAction:          config.CallAuthAllow,
+			Rules:                  []config.CallAuthRuleConfig{{Methods: []string{"secret"}, Action: config.CallAuthDeny}},
+		}
+		c.Audit = config.AuditConfig{
+			Enabled:    true,
+			Output:     config.AuditOutputFile,
+			Path:       path,
+			Rotation:   "daily",
+			Types:      []string{audit.TypeConnect, audit.TypeDisconnect, audit.TypeCall, audit.TypeAuth},
+			Redact:     []string{"clientIp"},
+			RedactMode: config.AuditRedactMask,
+		}
+	})
+	svcCtx := svc.NewServiceContext(c)
+	l := New(svcCtx, Hooks{})
+	conn, pipe := newTestPeer(t, l, "callee")
+	go serveEcho(l, pipe)
+
+	ctx := WithClientIp(context.Background(), "192.0.2.1")
+	if _, err := l.OnCall(ctx, &types.CallRequest{PeerId: "callee", CallId: "c1", Method: "echo", Data: []byte("hello")}); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if _, err := l.OnCall(ctx, &types.CallRequest{PeerId: "callee", CallId: "c2", Method: "secret"}); err != types.PermissionDeniedResponseError {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+	l.DeleteSubscriber(conn)
+	_ = svcCtx.Audit.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		r := audit.Record{}
+		if err = json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("invalid record %q: %v", line, err)
+		}
+		if strings.Contains(line, "hello") || (r.ClientIp != "" && r.ClientIp != "***") {
+			t.Fatalf("record leaks payload or client ip: %s", line)
+		}
+		got = append(got, r.Type+":"+r.CallId+":"+r.Result)
+	}
+	want := []string{"connect::allow", "auth:c1:allow", "call:c1:OK", "auth:c2:deny", "call:c2:PermissionDenied", "disconnect::"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected audit records:\n got %v\nwant %v", got, want)
+	}
+}
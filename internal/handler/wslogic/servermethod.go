@@ -0,0 +1,183 @@
+package wslogic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/types"
+	"google.golang.org/grpc/codes"
+	"strings"
+	"time"
+)
+
+// serverMethodPrefix 以此为前缀的方法由服务端处理，不会转发给peer
+const serverMethodPrefix = "$server."
+
+// ServerPeerId 调用服务端方法时的peerId，peerId为空时使用此值
+const ServerPeerId = "$server"
+
+const (
+	methodAnnounce   = serverMethodPrefix + "announce"
+	methodPing       = serverMethodPrefix + "ping"
+	methodTime       = serverMethodPrefix + "time"
+	methodWhoami     = serverMethodPrefix + "whoami"
+	methodIceServers = serverMethodPrefix + "iceServers"
+)
+
+var ErrNoCaller = errors.New("method requires a registered peer connection")
+
+type (
+	callerKey   struct{}
+	clientIpKey struct{}
+)
+
+// WithCaller 标记请求来自哪个已注册的连接，$server.* 方法需要知道调用方
+func WithCaller(ctx context.Context, conn *types.PeerConnection) context.Context {
+	return context.WithValue(ctx, callerKey{}, conn)
+}
+
+// CallerFrom 返回请求来自哪个已注册的连接，匿名调用（/call 接口）时返回false
+func CallerFrom(ctx context.Context) (*types.PeerConnection, bool) {
+	conn, ok := ctx.Value(callerKey{}).(*types.PeerConnection)
+	return conn, ok && conn != nil
+}
+
+// WithClientIp 标记匿名调用方的ip，已注册的连接使用连接的ClientIp
+func WithClientIp(ctx context.Context, clientIp string) context.Context {
+	return context.WithValue(ctx, clientIpKey{}, clientIp)
+}
+
+// ClientIpFrom 返回调用方的ip，未知时返回空字符串
+func ClientIpFrom(ctx context.Context) string {
+	if caller, ok := CallerFrom(ctx); ok {
+		return caller.ClientIp
+	}
+	clientIp, _ := ctx.Value(clientIpKey{}).(string)
+	return clientIp
+}
+
+func isServerMethod(method string) bool {
+	return strings.HasPrefix(method, serverMethodPrefix)
+}
+
+// ServerMethodName 补全 $server. 前缀
+func ServerMethodName(name string) string {
+	if isServerMethod(name) {
+		return name
+	}
+	return serverMethodPrefix + name
+}
+
+// newServerMethods 内置的服务端方法，嵌入方注册的同名方法会替换内置方法
+func (l *Logic) newServerMethods(methods map[string]CallHandler) map[string]CallHandler {
+	all := map[string]CallHandler{
+		methodAnnounce:   l.announce,
+		methodPing:       ping,
+		methodTime:       serverTime,
+		methodWhoami:     whoami,
+		methodIceServers: l.iceServers,
+	}
+	for name, fn := range methods {
+		all[ServerMethodName(name)] = fn
+	}
+	return all
+}
+
+func (l *Logic) callServer(ctx context.Context, request *types.CallRequest) (*types.CallResponse, error) {
+	fn, ok := l.serverMethods[request.Method]
+	if !ok {
+		return types.UnimplementedResponse(request.CallId, request.Method), types.UnimplementedResponseError
+	}
+	return fn(ctx, request)
+}
+
+func okResponse(request *types.CallRequest, body any) (*types.CallResponse, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return &types.CallResponse{CallId: request.CallId, Method: request.Method, Status: codes.Internal}, err
+	}
+	return &types.CallResponse{CallId: request.CallId, Method: request.Method, Status: codes.OK, Data: data}, nil
+}
+
+// announceRequest $server.announce 的请求数据
+type announceRequest struct {
+	Methods []string `json:"methods"`
+}
+
+// announce 调用方声明自己提供的方法，替换之前的声明，返回生效的方法列表
+func (l *Logic) announce(ctx context.Context, request *types.CallRequest) (*types.CallResponse, error) {
+	caller, ok := CallerFrom(ctx)
+	if !ok || caller.Methods == nil {
+		return &types.CallResponse{CallId: request.CallId, Method: request.Method, Status: codes.FailedPrecondition}, ErrNoCaller
+	}
+	body := &announceRequest{}
+	if err := json.Unmarshal(request.Data, body); err != nil {
+		return &types.CallResponse{CallId: request.CallId, Method: request.Method, Status: codes.InvalidArgument}, err
+	}
+	caller.Methods.Announce(body.Methods)
+	return okResponse(request, announceRequest{Methods: caller.Methods.List()})
+}
+
+// ping 原样返回请求数据，用于测量往返时间
+func ping(_ context.Context, request *types.CallRequest) (*types.CallResponse, error) {
+	return &types.CallResponse{CallId: request.CallId, Method: request.Method, Status: codes.OK, Data: request.Data}, nil
+}
+
+type timeResponse struct {
+	UnixMs int64     `json:"unixMs"`
+	Time   time.Time `json:"time"`
+}
+
+// serverTime 服务端当前时间，用于peer校准时钟
+func serverTime(_ context.Context, request *types.CallRequest) (*types.CallResponse, error) {
+	now := time.Now()
+	return okResponse(request, timeResponse{UnixMs: now.UnixMilli(), Time: now})
+}
+
+type whoamiResponse struct {
+	Anonymous   bool       `json:"anonymous"`
+	PeerId      string     `json:"peerId,omitempty"`
+	DeviceId    string     `json:"deviceId,omitempty"`
+	ClientIp    string     `json:"clientIp,omitempty"`
+	ConnectedAt *time.Time `json:"connectedAt,omitempty"`
+	Methods     []string   `json:"methods,omitempty"`
+}
+
+// whoami 服务端看到的调用方信息，如经过代理后的ip
+func whoami(ctx context.Context, request *types.CallRequest) (*types.CallResponse, error) {
+	caller, ok := CallerFrom(ctx)
+	if !ok {
+		return okResponse(request, whoamiResponse{Anonymous: true, ClientIp: ClientIpFrom(ctx)})
+	}
+	connectedAt := caller.ConnectedAt
+	return okResponse(request, whoamiResponse{
+		PeerId:      caller.PeerId,
+		DeviceId:    caller.DeviceId,
+		ClientIp:    caller.ClientIp,
+		ConnectedAt: &connectedAt,
+		Methods:     caller.Methods.List(),
+	})
+}
+
+type iceServersResponse struct {
+	IceServers []config.IceServer `json:"iceServers"`
+}
+
+// iceServers 返回配置的STUN/TURN服务器，可以直接用作 RTCPeerConnection 的 iceServers
+// 只返回给已注册的peer，匿名调用方不能获取TURN临时凭证
+func (l *Logic) iceServers(ctx context.Context, request *types.CallRequest) (*types.CallResponse, error) {
+	caller, ok := CallerFrom(ctx)
+	if !ok {
+		return &types.CallResponse{CallId: request.CallId, Method: request.Method, Status: codes.FailedPrecondition}, ErrNoCaller
+	}
+	var (
+		now        = time.Now()
+		configured = l.svcCtx.Config().IceServers
+		servers    = make([]config.IceServer, 0, len(configured))
+	)
+	for i := range configured {
+		servers = append(servers, configured[i].IceServer(caller.PeerId, now))
+	}
+	return okResponse(request, iceServersResponse{IceServers: servers})
+}
@@ -0,0 +1,114 @@
+package wslogic
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/svc"
+	"github.com/peergoim/signaling-server/internal/types"
+	"google.golang.org/grpc/codes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuiltinServerMethods(t *testing.T) {
+	l := newTestLogic(t)
+	conn, _ := newTestPeer(t, l, "alice")
+	conn.ClientIp = "10.0.0.7"
+
+	// peerId为空时默认发给服务端
+	response, err := call(t, l, &types.CallRequest{CallId: "s1", Method: "$server.ping", Data: []byte(`{"n":1}`)})
+	if err != nil || response.Status != codes.OK || string(response.Data) != `{"n":1}` {
+		t.Fatalf("ping should echo the data: %+v, %v", response, err)
+	}
+
+	before := time.Now().UnixMilli()
+	response, err = call(t, l, &types.CallRequest{PeerId: ServerPeerId, CallId: "s2", Method: "$server.time"})
+	now := &timeResponse{}
+	if err != nil || json.Unmarshal(response.Data, now) != nil || now.UnixMs < before || now.UnixMs > time.Now().UnixMilli() {
+		t.Fatalf("unexpected time response: %+v, %v", response, err)
+	}
+
+	data, err := l.OnCall(WithCaller(context.Background(), conn), &types.CallRequest{CallId: "s3", Method: "$server.whoami"})
+	_ = response.FromBytes(data)
+	me := &whoamiResponse{}
+	if err != nil || json.Unmarshal(response.Data, me) != nil || me.Anonymous || me.PeerId != "alice" || me.ClientIp != "10.0.0.7" {
+		t.Fatalf("unexpected whoami response: %s, %v", response.Data, err)
+	}
+	data, _ = l.OnCall(WithClientIp(context.Background(), "192.0.2.1"), &types.CallRequest{CallId: "s4", Method: "$server.whoami"})
+	_ = response.FromBytes(data)
+	if string(response.Data) != `{"anonymous":true,"clientIp":"192.0.2.1"}` {
+		t.Fatalf("unexpected anonymous whoami response: %s", response.Data)
+	}
+
+	if response, err = call(t, l, &types.CallRequest{CallId: "s5", Method: "$server.missing"}); err != types.UnimplementedResponseError || response.Status != codes.Unimplemented {
+		t.Fatalf("unknown server method should be unimplemented: %+v, %v", response, err)
+	}
+}
+
+func TestIceServers(t *testing.T) {
+	c := newTestConfig(t, func(c *config.Config) {
+		c.IceServers = []config.IceServerConfig{
+			{Urls: []string{"stun:stun.example.com:3478"}},
+			{Urls: []string{"turn:turn.example.com:3478"}, Secret: "s3cret", Ttl: 600},
+		}
+	})
+	l := New(svc.NewServiceContext(c), Hooks{})
+	conn, _ := newTestPeer(t, l, "alice")
+
+	data, err := l.OnCall(WithCaller(context.Background(), conn), &types.CallRequest{CallId: "i1", Method: "$server.iceServers"})
+	response := &types.CallResponse{}
+	_ = response.FromBytes(data)
+	body := &iceServersResponse{}
+	if err != nil || json.Unmarshal(response.Data, body) != nil || len(body.IceServers) != 2 {
+		t.Fatalf("unexpected response: %s, %v", response.Data, err)
+	}
+	if stun := body.IceServers[0]; stun.Username != "" || stun.Credential != "" {
+		t.Fatalf("stun server should have no credential: %+v", stun)
+	}
+	turn := body.IceServers[1]
+	expiry, peerId, _ := strings.Cut(turn.Username, ":")
+	unix, _ := strconv.ParseInt(expiry, 10, 64)
+	if peerId != "alice" || unix <= time.Now().Unix() || unix > time.Now().Add(600*time.Second).Unix() {
+		t.Fatalf("unexpected turn username: %s", turn.Username)
+	}
+	mac := hmac.New(sha1.New, []byte("s3cret"))
+	mac.Write([]byte(turn.Username))
+	if turn.Credential != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("unexpected turn credential: %s", turn.Credential)
+	}
+
+	// 匿名调用方（/call 接口）不能获取凭证
+	response, err = call(t, l, &types.CallRequest{CallId: "i2", Method: "$server.iceServers"})
+	if err != ErrNoCaller || response.Status != codes.FailedPrecondition || len(response.Data) != 0 {
+		t.Fatalf("anonymous callers should be rejected: %+v, %v", response, err)
+	}
+}
+
+func TestEmbedderServerMethods(t *testing.T) {
+	c := newTestConfig(t, func(c *config.Config) {})
+	reply := func(data string) CallHandler {
+		return func(ctx context.Context, request *types.CallRequest) (*types.CallResponse, error) {
+			return &types.CallResponse{Status: codes.OK, Data: []byte(data)}, nil
+		}
+	}
+	l := New(svc.NewServiceContext(c), Hooks{ServerMethods: map[string]CallHandler{
+		"rooms":        reply(`["lobby"]`),
+		"$server.ping": reply(`"pong"`),
+	}})
+
+	if response, err := call(t, l, &types.CallRequest{CallId: "e1", Method: "$server.rooms"}); err != nil || string(response.Data) != `["lobby"]` || response.CallId != "e1" {
+		t.Fatalf("custom method should be served: %+v, %v", response, err)
+	}
+	if response, _ := call(t, l, &types.CallRequest{CallId: "e2", Method: "$server.ping", Data: []byte("1")}); string(response.Data) != `"pong"` {
+		t.Fatalf("embedder should replace the builtin ping: %+v", response)
+	}
+	if response, _ := call(t, l, &types.CallRequest{CallId: "e3", Method: "$server.time"}); response.Status != codes.OK {
+		t.Fatalf("other builtins should stay available: %+v", response)
+	}
+}
@@ -0,0 +1,200 @@
+package wslogic
+
+import (
+	"context"
+	"github.com/peergoim/signaling-server/internal/transport"
+	"github.com/peergoim/signaling-server/internal/types"
+	"github.com/peergoim/signaling-server/internal/utils"
+	"github.com/zeromicro/go-zero/core/logx"
+	"time"
+)
+
+// session 启用会话恢复时peer的一次会话
+// 注册到peerConnections的是session.conn，底层连接断开后保留 GraceWindow 秒，期间发给peer的消息被缓冲
+type session struct {
+	token     string
+	conn      *types.PeerConnection
+	transport *transport.SessionTransport
+	// 断开后的过期计时器，由sessionsLock保护
+	expireTimer *time.Timer
+}
+
+// ResumeToken 返回本次连接使用的会话token，未启用会话恢复时返回空字符串
+// resumeToken对应peerId的会话仍在保留期内则沿用，否则生成新的token
+func (l *Logic) ResumeToken(peerId string, resumeToken string) string {
+	if !l.svcCtx.Config().WebSocket.Resume.Enabled {
+		return ""
+	}
+	l.sessionsLock.Lock()
+	defer l.sessionsLock.Unlock()
+	if s, ok := l.sessions[resumeToken]; ok && s.conn.PeerId == peerId && s.transport.Context().Err() == nil {
+		return resumeToken
+	}
+	return utils.RandomId()
+}
+
+// Connect peer上线，token为 ResumeToken 的返回值，返回是否恢复了之前的会话
+// 被会话策略拒绝时返回 ErrSessionRejected，此时连接已关闭
+func (l *Logic) Connect(conn *types.PeerConnection, token string) (bool, error) {
+	if token == "" {
+		return false, l.AddSubscriber(conn)
+	}
+	l.sessionsLock.Lock()
+	if s, ok := l.sessions[token]; ok && s.conn.PeerId == conn.PeerId && s.transport.Context().Err() == nil {
+		if s.expireTimer != nil && !s.expireTimer.Stop() {
+			// 计时器已经触发，expireSession正在等待锁，会话按已过期处理
+			return l.connectSession(conn, token, s)
+		}
+		s.expireTimer = nil
+		l.sessionsLock.Unlock()
+		// 新连接与会话共用方法列表，重连时声明的方法覆盖之前的声明
+		if conn.Methods.Announced() {
+			s.conn.Methods.Announce(conn.Methods.List())
+		}
+		conn.Methods = s.conn.Methods
+		flushTimeout := time.Second * time.Duration(l.svcCtx.Config().WebSocket.Resume.FlushTimeout)
+		if old := s.transport.Attach(conn.Transport, flushTimeout); old != nil {
+			// 旧连接可能还没有检测到断开
+			_ = old.Close(types.CloseGoingAway, "session resumed")
+		}
+		logx.Infof("peer %s resumed session, client ip: %s", conn.PeerId, conn.ClientIp)
+		return true, nil
+	}
+	return l.connectSession(conn, token, nil)
+}
+
+// connectSession 为token创建新的会话，expired为被取代的已过期会话
+// 调用时需持有sessionsLock，返回前释放
+func (l *Logic) connectSession(conn *types.PeerConnection, token string, expired *session) (bool, error) {
+	s := &session{
+		token:     token,
+		transport: transport.NewSessionTransport(context.Background(), conn.Transport, l.svcCtx.Config().WebSocket.Resume.BufferSize),
+	}
+	if conn.Methods == nil {
+		conn.Methods = types.NewMethodSet()
+	}
+	// 复制后与会话共用同一个方法列表
+	sessionConn := *conn
+	sessionConn.Transport = s.transport
+	sessionConn.Ctx = s.transport.Context()
+	sessionConn.SessionToken = token
+	s.conn = &sessionConn
+	l.sessions[token] = s
+	l.sessionsLock.Unlock()
+	if expired != nil {
+		logx.Infof("peer %s session expired", expired.conn.PeerId)
+		l.DeleteSubscriber(expired.conn)
+	}
+	if err := l.AddSubscriber(s.conn); err != nil {
+		l.sessionsLock.Lock()
+		delete(l.sessions, token)
+		l.sessionsLock.Unlock()
+		return false, err
+	}
+	return false, nil
+}
+
+// Disconnect peer的连接断开，graceful为peer主动下线
+// 启用会话恢复且不是主动下线时，会话保留 GraceWindow 秒后才真正下线
+func (l *Logic) Disconnect(conn *types.PeerConnection, token string, graceful bool) {
+	if token == "" {
+		l.DeleteSubscriber(conn)
+		return
+	}
+	l.sessionsLock.Lock()
+	s, ok := l.sessions[token]
+	if !ok || !s.transport.Detach(conn.Transport) {
+		// 会话已过期，或者已经被新连接恢复
+		l.sessionsLock.Unlock()
+		return
+	}
+	if graceful || l.closed || s.transport.Context().Err() != nil {
+		// 主动下线，服务端已关闭，或会话已被关闭（如被新连接替换）
+		delete(l.sessions, token)
+		l.sessionsLock.Unlock()
+		l.DeleteSubscriber(s.conn)
+		return
+	}
+	if s.expireTimer != nil {
+		s.expireTimer.Stop()
+	}
+	graceWindow := time.Second * time.Duration(l.svcCtx.Config().WebSocket.Resume.GraceWindow)
+	s.expireTimer = time.AfterFunc(graceWindow, func() {
+		l.expireSession(s)
+	})
+	l.sessionsLock.Unlock()
+}
+
+func (l *Logic) expireSession(s *session) {
+	l.sessionsLock.Lock()
+	if l.sessions[s.token] != s || s.transport.Attached() {
+		l.sessionsLock.Unlock()
+		return
+	}
+	delete(l.sessions, s.token)
+	l.sessionsLock.Unlock()
+	logx.Infof("peer %s session expired", s.conn.PeerId)
+	l.DeleteSubscriber(s.conn)
+}
+
+// Close 立即结束所有断开后还在保留期内的会话并停止过期计时器，之后断开的连接不再保留会话
+func (l *Logic) Close() {
+	l.sessionsLock.Lock()
+	l.closed = true
+	detached := make([]*session, 0)
+	for token, s := range l.sessions {
+		if s.transport.Attached() {
+			continue
+		}
+		if s.expireTimer != nil {
+			s.expireTimer.Stop()
+		}
+		delete(l.sessions, token)
+		detached = append(detached, s)
+	}
+	l.sessionsLock.Unlock()
+	for _, s := range detached {
+		l.DeleteSubscriber(s.conn)
+	}
+}
+
+// expireDetachedSessions 立即结束peerId已断开、还在保留期内的会话，由新连接取代
+func (l *Logic) expireDetachedSessions(peerId string) {
+	for _, conn := range l.peerConnections.Get(peerId) {
+		l.sessionsLock.Lock()
+		s := l.sessionOf(conn)
+		if s == nil || s.transport.Attached() {
+			l.sessionsLock.Unlock()
+			continue
+		}
+		if s.expireTimer != nil {
+			s.expireTimer.Stop()
+		}
+		delete(l.sessions, s.token)
+		l.sessionsLock.Unlock()
+		logx.Infof("peer %s detached session replaced by a new connection", peerId)
+		l.DeleteSubscriber(s.conn)
+	}
+}
+
+// sessionOf 返回注册的连接所属的会话，调用时需持有sessionsLock
+func (l *Logic) sessionOf(conn *types.PeerConnection) *session {
+	if conn.SessionToken == "" {
+		return nil
+	}
+	if s, ok := l.sessions[conn.SessionToken]; ok && s.conn == conn {
+		return s
+	}
+	return nil
+}
+
+// sendCall 会话连接的请求在回复前断线重连会重发，返回结束等待时的清理函数
+func sendCall(ctx context.Context, conn *types.PeerConnection, request *types.CallRequest) (func(), error) {
+	if st, ok := conn.Transport.(*transport.SessionTransport); ok {
+		err := st.SendCall(ctx, request.CallId, request.ToBytes())
+		return func() {
+			st.Complete(request.CallId)
+		}, err
+	}
+	return func() {}, conn.Transport.Send(ctx, types.FrameRequest, request.ToBytes())
+}
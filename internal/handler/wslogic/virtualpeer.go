@@ -0,0 +1,138 @@
+package wslogic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/types"
+	"github.com/peergoim/signaling-server/internal/utils"
+	"github.com/zeromicro/go-zero/core/logx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc/codes"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	headerPeerId = "X-Signaling-Peer-Id"
+
+	// webhook响应体最大读取长度，防止后端返回超大内容
+	maxWebhookResponseSize = 1 << 20
+)
+
+var errWebhookStatus = errors.New("webhook returned unexpected status")
+
+// virtualPeer 通过http webhook接收请求的peer
+type virtualPeer struct {
+	config config.VirtualPeerConfig
+	client *http.Client
+}
+
+func newVirtualPeer(c config.VirtualPeerConfig) *virtualPeer {
+	return &virtualPeer{
+		config: c,
+		client: &http.Client{Timeout: time.Second * time.Duration(c.Timeout)},
+	}
+}
+
+// call 把请求POST到webhook，失败时按指数退避重试，并把webhook的响应转换为CallResponse
+func (p *virtualPeer) call(ctx context.Context, request *types.CallRequest) (*types.CallResponse, error) {
+	var (
+		payload    = request.ToBytes()
+		interval   = time.Millisecond * time.Duration(p.config.RetryInterval)
+		statusCode int
+		body       []byte
+		err        error
+	)
+	for attempt := 0; attempt <= p.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(interval):
+			}
+			interval *= 2
+		}
+		statusCode, body, err = p.post(ctx, payload)
+		if err == nil && !retryableStatus(statusCode) {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logx.WithContext(ctx).Errorf("virtual peer %s webhook attempt %d failed, status: %d, err: %v",
+			p.config.PeerId, attempt+1, statusCode, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	response := &types.CallResponse{}
+	if statusCode >= 200 && statusCode < 300 {
+		// webhook可以直接返回CallResponse，否则把响应体作为数据原样返回
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.DisallowUnknownFields()
+		if decoder.Decode(response) != nil {
+			response = &types.CallResponse{Status: codes.OK, Data: body}
+		}
+	} else {
+		response.Status = httpStatusToCode(statusCode)
+		response.Data = body
+		err = errWebhookStatus
+	}
+	response.CallId = request.CallId
+	response.Method = request.Method
+	return response, err
+}
+
+func (p *virtualPeer) post(ctx context.Context, payload []byte) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.Url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerPeerId, p.config.PeerId)
+	utils.SignRequest(req, p.config.Secret, payload)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseSize))
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+func retryableStatus(statusCode int) bool {
+	// 501表示webhook不支持此方法，重试也不会成功
+	return statusCode == http.StatusTooManyRequests || (statusCode >= 500 && statusCode != http.StatusNotImplemented)
+}
+
+func httpStatusToCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
@@ -0,0 +1,132 @@
+package wslogic
+
+import (
+	"context"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/svc"
+	"github.com/peergoim/signaling-server/internal/types"
+	"github.com/peergoim/signaling-server/internal/utils"
+	"google.golang.org/grpc/codes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestWebhook(t *testing.T, handler http.HandlerFunc) config.VirtualPeerConfig {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return config.VirtualPeerConfig{PeerId: "backend", Url: server.URL, Secret: "s3cret", Timeout: 1, MaxRetries: 2, RetryInterval: 10}
+}
+
+func TestVirtualPeerSignsRequests(t *testing.T) {
+	c := newTestWebhook(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(utils.HeaderSignatureTimestamp), 10, 64)
+		if r.Header.Get(headerPeerId) != "backend" || r.Header.Get(utils.HeaderSignature) != utils.SignPayload("s3cret", timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	})
+	response, err := newVirtualPeer(c).call(context.Background(), &types.CallRequest{PeerId: "backend", CallId: "v1", Method: "echo", Data: []byte(`{}`)})
+	if err != nil || response.Status != codes.OK || string(response.Data) != `{"ok":true}` || response.CallId != "v1" {
+		t.Fatalf("signed request should be accepted: %+v, %v", response, err)
+	}
+}
+
+func TestVirtualPeerRetries(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestWebhook(t, func(w http.ResponseWriter, r *http.Request) {
+		switch attempts.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			// 超过客户端超时
+			select {
+			case <-r.Context().Done():
+			case <-time.After(1500 * time.Millisecond):
+			}
+		default:
+			_, _ = w.Write([]byte(`{"callId":"ignored","method":"echo","status":0,"data":"aGk="}`))
+		}
+	})
+	response, err := newVirtualPeer(c).call(context.Background(), &types.CallRequest{CallId: "r1", Method: "echo"})
+	if err != nil || attempts.Load() != 3 {
+		t.Fatalf("expected success on the third attempt, got %v after %d attempts", err, attempts.Load())
+	}
+	if response.Status != codes.OK || string(response.Data) != "hi" || response.CallId != "r1" {
+		t.Fatalf("a CallResponse body should be used as is, keeping the call id: %+v", response)
+	}
+
+	attempts.Store(0)
+	c = newTestWebhook(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	response, err = newVirtualPeer(c).call(context.Background(), &types.CallRequest{CallId: "r2", Method: "echo"})
+	if err != errWebhookStatus || response.Status != codes.Unavailable || attempts.Load() != 3 {
+		t.Fatalf("expected Unavailable after all retries, got %+v, %v, %d attempts", response, err, attempts.Load())
+	}
+}
+
+func TestVirtualPeerStatusMapping(t *testing.T) {
+	cases := []struct {
+		status int
+		want   codes.Code
+	}{
+		{http.StatusBadRequest, codes.InvalidArgument},
+		{http.StatusUnauthorized, codes.Unauthenticated},
+		{http.StatusForbidden, codes.PermissionDenied},
+		{http.StatusNotFound, codes.NotFound},
+		{http.StatusNotImplemented, codes.Unimplemented},
+		{http.StatusConflict, codes.Unknown},
+	}
+	for _, c := range cases {
+		var attempts atomic.Int32
+		vp := newVirtualPeer(newTestWebhook(t, func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(c.status)
+			_, _ = w.Write([]byte("details"))
+		}))
+		response, err := vp.call(context.Background(), &types.CallRequest{CallId: "m1", Method: "echo"})
+		if err != errWebhookStatus || response.Status != c.want || string(response.Data) != "details" || attempts.Load() != 1 {
+			t.Fatalf("status %d: got %+v, %v after %d attempts, want %s without retry", c.status, response, err, attempts.Load(), c.want)
+		}
+	}
+	// 2xx但不是CallResponse时，响应体原样作为数据
+	vp := newVirtualPeer(newTestWebhook(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("plain text"))
+	}))
+	if response, err := vp.call(context.Background(), &types.CallRequest{CallId: "m2", Method: "echo"}); err != nil || string(response.Data) != "plain text" {
+		t.Fatalf("unexpected raw response: %+v, %v", response, err)
+	}
+	for status, want := range map[int]codes.Code{http.StatusTooManyRequests: codes.ResourceExhausted, http.StatusGatewayTimeout: codes.DeadlineExceeded} {
+		if got := httpStatusToCode(status); got != want {
+			t.Fatalf("httpStatusToCode(%d) = %s, want %s", status, got, want)
+		}
+	}
+}
+
+func TestVirtualPeerRejectsDeviceAddress(t *testing.T) {
+	var requests atomic.Int32
+	c := newTestWebhook(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte(`{}`))
+	})
+	l := New(svc.NewServiceContext(newTestConfig(t, func(cfg *config.Config) {
+		cfg.VirtualPeers = []config.VirtualPeerConfig{c}
+	})), Hooks{})
+
+	response, err := call(t, l, &types.CallRequest{PeerId: "backend/phone", CallId: "v9", Method: "echo"})
+	if err != types.PeerOfflineResponseError || response.Status != codes.Unavailable {
+		t.Fatalf("virtual peers have no devices, got %+v, %v", response, err)
+	}
+	if requests.Load() != 0 {
+		t.Fatal("device addressed calls should not reach the webhook")
+	}
+}
@@ -0,0 +1,118 @@
+package ipfilter
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+)
+
+const (
+	// PrecedenceDeny 同时命中允许和禁止名单时禁止
+	PrecedenceDeny = "deny"
+	// PrecedenceAllow 同时命中允许和禁止名单时允许
+	PrecedenceAllow = "allow"
+	// PrecedenceSpecific 同时命中时前缀更长（更具体）的规则生效，长度相同时禁止
+	PrecedenceSpecific = "specific"
+)
+
+var ErrInvalidRule = errors.New("invalid ip or cidr")
+
+// Filter 基于允许/禁止名单的ip过滤器，创建后只读，可并发使用
+type Filter struct {
+	allow        *Tree
+	deny         *Tree
+	precedence   string
+	defaultAllow bool
+}
+
+// New defaultAllow 为两个名单都没有命中时的结果
+func New(allow []netip.Prefix, deny []netip.Prefix, precedence string, defaultAllow bool) *Filter {
+	f := &Filter{
+		allow:        NewTree(),
+		deny:         NewTree(),
+		precedence:   precedence,
+		defaultAllow: defaultAllow,
+	}
+	for _, p := range allow {
+		f.allow.Insert(p)
+	}
+	for _, p := range deny {
+		f.deny.Insert(p)
+	}
+	return f
+}
+
+// Allowed 判断ip是否允许访问
+func (f *Filter) Allowed(addr netip.Addr) bool {
+	allowBits, allowed := f.allow.Lookup(addr)
+	denyBits, denied := f.deny.Lookup(addr)
+	switch {
+	case allowed && denied:
+		switch f.precedence {
+		case PrecedenceAllow:
+			return true
+		case PrecedenceSpecific:
+			return allowBits > denyBits
+		default:
+			return false
+		}
+	case allowed:
+		return true
+	case denied:
+		return false
+	default:
+		return f.defaultAllow
+	}
+}
+
+// AllowedString 同 Allowed，无法解析的ip视为不允许
+func (f *Filter) AllowedString(ip string) bool {
+	addr, err := ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return f.Allowed(addr)
+}
+
+// ParseRule 解析一条名单规则，支持IPv4、IPv6、CIDR，以及带端口的旧格式（如 127.0.0.1:8080）
+func ParseRule(rule string) (netip.Prefix, error) {
+	rule = strings.TrimSpace(rule)
+	if strings.Contains(rule, "/") {
+		p, err := netip.ParsePrefix(rule)
+		if err != nil {
+			return netip.Prefix{}, ErrInvalidRule
+		}
+		return p, nil
+	}
+	addr, err := ParseAddr(rule)
+	if err != nil {
+		return netip.Prefix{}, ErrInvalidRule
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ParseAddr 解析ip，兼容带端口和方括号的写法（如 [::1]:8080）
+func ParseAddr(ip string) (netip.Addr, error) {
+	ip = strings.TrimSpace(ip)
+	if addrPort, err := netip.ParseAddrPort(ip); err == nil {
+		return addrPort.Addr().Unmap(), nil
+	}
+	addr, err := netip.ParseAddr(strings.Trim(ip, "[]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// ParseRules 解析多条规则，返回无法解析的规则
+func ParseRules(rules []string) (prefixes []netip.Prefix, invalid []string) {
+	for _, rule := range rules {
+		p, err := ParseRule(rule)
+		if err != nil {
+			invalid = append(invalid, rule)
+			continue
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, invalid
+}
@@ -0,0 +1,73 @@
+package ipfilter
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+)
+
+func mustParseRules(t *testing.T, rules ...string) []netip.Prefix {
+	t.Helper()
+	prefixes, invalid := ParseRules(rules)
+	if len(invalid) > 0 {
+		t.Fatalf("invalid rules: %v", invalid)
+	}
+	return prefixes
+}
+
+func TestFilterAllowed(t *testing.T) {
+	allow := mustParseRules(t, "10.0.0.0/8", "192.168.1.1", "2001:db8::/32", "10.1.2.0/24")
+	deny := mustParseRules(t, "10.1.0.0/16", "2001:db8:dead::/48")
+	cases := []struct {
+		precedence string
+		ip         string
+		want       bool
+	}{
+		{PrecedenceDeny, "10.2.3.4", true},
+		{PrecedenceDeny, "10.1.3.4", false},
+		{PrecedenceDeny, "10.1.2.3", false},
+		{PrecedenceAllow, "10.1.3.4", true},
+		{PrecedenceSpecific, "10.1.3.4", false},
+		{PrecedenceSpecific, "10.1.2.3", true},
+		{PrecedenceDeny, "192.168.1.1", true},
+		{PrecedenceDeny, "192.168.1.2", false},
+		{PrecedenceDeny, "192.168.1.1:8080", true},
+		{PrecedenceDeny, "::ffff:192.168.1.1", true},
+		{PrecedenceDeny, "2001:db8:1::1", true},
+		{PrecedenceDeny, "[2001:db8:1::1]:443", true},
+		{PrecedenceDeny, "2001:db8:dead::1", false},
+		{PrecedenceDeny, "2001:db9::1", false},
+		{PrecedenceDeny, "not-an-ip", false},
+	}
+	for _, c := range cases {
+		f := New(allow, deny, c.precedence, false)
+		if got := f.AllowedString(c.ip); got != c.want {
+			t.Errorf("%s %s: got %v, want %v", c.precedence, c.ip, got, c.want)
+		}
+	}
+}
+
+func TestFilterDefaultAction(t *testing.T) {
+	deny := mustParseRules(t, "203.0.113.0/24")
+	f := New(nil, deny, PrecedenceDeny, true)
+	if !f.AllowedString("198.51.100.1") {
+		t.Error("unlisted ip should be allowed by default action")
+	}
+	if f.AllowedString("203.0.113.7") {
+		t.Error("denied ip should not be allowed")
+	}
+}
+
+func BenchmarkFilterAllowed(b *testing.B) {
+	// 10万条规则
+	allow := make([]netip.Prefix, 0, 100000)
+	for i := 0; i < 100000; i++ {
+		allow = append(allow, netip.MustParsePrefix(fmt.Sprintf("10.%d.%d.0/24", i/256%256, i%256)))
+	}
+	f := New(allow, nil, PrecedenceDeny, false)
+	addr := netip.MustParseAddr("10.200.100.1")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Allowed(addr)
+	}
+}
@@ -0,0 +1,81 @@
+package ipfilter
+
+import (
+	"net/netip"
+)
+
+// Tree 二进制前缀树（基数为2的radix tree），IPv4地址按IPv4-mapped IPv6存储
+// 查询耗时只与地址位数有关，与规则数量无关，适合很大的名单
+type Tree struct {
+	root *node
+	size int
+}
+
+type node struct {
+	children [2]*node
+	// 有规则在此结束
+	terminal bool
+}
+
+func NewTree() *Tree {
+	return &Tree{root: &node{}}
+}
+
+// Insert 插入一个CIDR
+func (t *Tree) Insert(prefix netip.Prefix) {
+	prefix = prefix.Masked()
+	addr, bits := to16(prefix.Addr()), prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+	n := t.root
+	for i := 0; i < bits; i++ {
+		b := bit(addr, i)
+		if n.children[b] == nil {
+			n.children[b] = &node{}
+		}
+		n = n.children[b]
+	}
+	if !n.terminal {
+		n.terminal = true
+		t.size++
+	}
+}
+
+// Lookup 返回覆盖addr的最长前缀长度（按128位计算），没有匹配时ok为false
+func (t *Tree) Lookup(addr netip.Addr) (bits int, ok bool) {
+	if !addr.IsValid() {
+		return 0, false
+	}
+	a := to16(addr.Unmap())
+	n := t.root
+	for i := 0; n != nil; i++ {
+		if n.terminal {
+			bits, ok = i, true
+		}
+		if i == 128 {
+			break
+		}
+		n = n.children[bit(a, i)]
+	}
+	return bits, ok
+}
+
+// Contains 是否有前缀覆盖addr
+func (t *Tree) Contains(addr netip.Addr) bool {
+	_, ok := t.Lookup(addr)
+	return ok
+}
+
+// Len 树中前缀的数量
+func (t *Tree) Len() int {
+	return t.size
+}
+
+func to16(addr netip.Addr) [16]byte {
+	return addr.As16()
+}
+
+func bit(addr [16]byte, i int) int {
+	return int(addr[i/8]>>(7-uint(i%8))) & 1
+}
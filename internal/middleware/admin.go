@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/internal/config"
+	"net/http"
+	"strings"
+)
+
+// AdminAuth 校验管理接口的Bearer Token
+func AdminAuth(getConfig func() config.AdminConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := []byte(getConfig().Token)
+		auth := c.GetHeader("Authorization")
+		given := []byte(strings.TrimPrefix(auth, "Bearer "))
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare(given, token) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
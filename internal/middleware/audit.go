@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/internal/audit"
+	"github.com/peergoim/signaling-server/internal/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AdminAudit 管理接口的请求写入审计日志，需要在AdminAuth之前，token校验失败记录为鉴权拒绝
+func AdminAudit(logger *audit.Logger, resolver *utils.ClientIpResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		record := audit.Record{
+			Type:       audit.TypeAdmin,
+			Action:     c.Request.Method + " " + c.FullPath(),
+			Result:     strconv.Itoa(c.Writer.Status()),
+			PeerId:     c.Param("peerId"),
+			DeviceId:   c.Query("deviceId"),
+			ClientIp:   resolver.ClientIp(c.Request),
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if c.Writer.Status() == http.StatusUnauthorized {
+			record.Type, record.Action, record.Result, record.Reason = audit.TypeAuth, "admin", audit.ResultDeny, "invalid admin token"
+		}
+		logger.Log(record)
+	}
+}
@@ -7,15 +7,20 @@ import (
 	"strings"
 )
 
-func Cors(config config.CorsConfig) gin.HandlerFunc {
-	if !config.Enabled {
-		return func(c *gin.Context) {
+// Cors getConfig每次请求时调用，配置热加载后立即生效
+func Cors(getConfig func() config.CorsConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config := getConfig()
+		if !config.Enabled {
 			c.Next()
+			return
 		}
-	}
-	return func(c *gin.Context) {
 		method := c.Request.Method
-		c.Header("Access-Control-Allow-Origin", strings.Join(config.AllowOrigins, ","))
+		// 只回显允许的Origin，多个Origin拼接在一起浏览器无法识别
+		if origin := c.GetHeader("Origin"); config.AllowOrigin(origin) {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Vary", "Origin")
+		}
 		c.Header("Access-Control-Allow-Headers", strings.Join(config.AllowHeaders, ","))
 		c.Header("Access-Control-Allow-Methods", strings.Join(config.AllowMethods, ","))
 		c.Header("Access-Control-Expose-Headers", strings.Join(config.ExposeHeaders, ","))
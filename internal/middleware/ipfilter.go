@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/internal/audit"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/utils"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// IpFilter 对配置中的路由做ip过滤，拒绝的请求写入审计日志
+func IpFilter(getConfig func() *config.IpWhitelistConfig, resolver *utils.ClientIpResolver, logger *audit.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config := getConfig()
+		if !config.ShouldFilter(c.FullPath()) {
+			c.Next()
+			return
+		}
+		clientIp := resolver.ClientIp(c.Request)
+		if !config.InIpWhitelist(c.FullPath(), clientIp) {
+			logx.WithContext(c.Request.Context()).Errorf("ip %s not allowed to access %s", clientIp, c.FullPath())
+			logger.Log(audit.Record{
+				Type:     audit.TypeAuth,
+				Action:   "ip",
+				Result:   audit.ResultDeny,
+				ClientIp: clientIp,
+				Reason:   "ip not allowed to access " + c.FullPath(),
+			})
+			// 直接重定向到google.com，防止其他人恶意访问
+			c.Redirect(302, "https://www.google.com")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
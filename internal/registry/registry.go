@@ -0,0 +1,139 @@
+package registry
+
+import (
+	"github.com/peergoim/signaling-server/internal/types"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+// shardCount 分片数量，需要是2的幂
+const shardCount = 256
+
+// AddMode 同一peerId已有连接时如何添加新连接
+type AddMode int
+
+const (
+	AddMultiple AddMode = iota // 保留已有的连接
+	AddReplace                 // 移除已有的连接
+	AddIfAbsent                // 已有连接时不添加
+)
+
+// Registry 在线连接注册表，按peerId分片，不同peer的上下线不会互相争用锁
+type Registry struct {
+	seed   maphash.Seed
+	shards [shardCount]shard
+	size   atomic.Int64
+}
+
+type shard struct {
+	lock  sync.RWMutex
+	peers map[string][]*types.PeerConnection
+	// 连接在peers[peerId]中的下标，删除时不需要遍历
+	index map[*types.PeerConnection]int
+}
+
+func New() *Registry {
+	r := &Registry{seed: maphash.MakeSeed()}
+	for i := range r.shards {
+		r.shards[i].peers = make(map[string][]*types.PeerConnection)
+		r.shards[i].index = make(map[*types.PeerConnection]int)
+	}
+	return r
+}
+
+func (r *Registry) shard(peerId string) *shard {
+	return &r.shards[maphash.String(r.seed, peerId)&(shardCount-1)]
+}
+
+// Add 添加连接，返回被移除的已有连接；mode为AddIfAbsent且已有连接时不添加，返回false
+func (r *Registry) Add(conn *types.PeerConnection, mode AddMode) ([]*types.PeerConnection, bool) {
+	s := r.shard(conn.PeerId)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	existing := s.peers[conn.PeerId]
+	var replaced []*types.PeerConnection
+	switch {
+	case mode == AddIfAbsent && len(existing) > 0:
+		return nil, false
+	case mode == AddReplace && len(existing) > 0:
+		replaced, existing = existing, nil
+		for _, c := range replaced {
+			delete(s.index, c)
+		}
+		r.size.Add(int64(-len(replaced)))
+	}
+	s.index[conn] = len(existing)
+	s.peers[conn.PeerId] = append(existing, conn)
+	r.size.Add(1)
+	return replaced, true
+}
+
+// Remove 移除连接，连接不存在（已经移除或被替换）时返回false
+func (r *Registry) Remove(conn *types.PeerConnection) bool {
+	s := r.shard(conn.PeerId)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i, ok := s.index[conn]
+	if !ok {
+		return false
+	}
+	delete(s.index, conn)
+	conns := s.peers[conn.PeerId]
+	last := len(conns) - 1
+	if last == 0 {
+		delete(s.peers, conn.PeerId)
+	} else {
+		// 和最后一个交换后删除，连接的顺序会变化
+		conns[i] = conns[last]
+		s.index[conns[i]] = i
+		conns[last] = nil
+		s.peers[conn.PeerId] = conns[:last]
+	}
+	r.size.Add(-1)
+	return true
+}
+
+// Select 在持有读锁时调用fn选择peer的一个连接，fn不能持有或修改conns；peer不在线时不调用fn
+func (r *Registry) Select(peerId string, fn func(conns []*types.PeerConnection) *types.PeerConnection) (*types.PeerConnection, bool) {
+	s := r.shard(peerId)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	conns, ok := s.peers[peerId]
+	if !ok {
+		return nil, false
+	}
+	return fn(conns), true
+}
+
+// Get 返回peer所有在线连接的副本
+func (r *Registry) Get(peerId string) []*types.PeerConnection {
+	s := r.shard(peerId)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]*types.PeerConnection(nil), s.peers[peerId]...)
+}
+
+// Len 在线连接的数量
+func (r *Registry) Len() int {
+	return int(r.size.Load())
+}
+
+// Range 逐个分片遍历所有在线peer，fn收到的是连接的副本，返回false时停止遍历
+// 遍历期间上下线的peer可能不会出现在结果中
+func (r *Registry) Range(fn func(peerId string, conns []*types.PeerConnection) bool) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.lock.RLock()
+		peers := make(map[string][]*types.PeerConnection, len(s.peers))
+		for peerId, conns := range s.peers {
+			peers[peerId] = append([]*types.PeerConnection(nil), conns...)
+		}
+		s.lock.RUnlock()
+		for peerId, conns := range peers {
+			if !fn(peerId, conns) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package registry
+
+import (
+	"github.com/peergoim/signaling-server/internal/types"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func newConn(peerId string, deviceId string) *types.PeerConnection {
+	return &types.PeerConnection{PeerId: peerId, DeviceId: deviceId}
+}
+
+func TestRegistryAddRemove(t *testing.T) {
+	r := New()
+	a, b, c := newConn("p", "a"), newConn("p", "b"), newConn("p", "c")
+	for _, conn := range []*types.PeerConnection{a, b, c} {
+		if _, ok := r.Add(conn, AddMultiple); !ok {
+			t.Fatal("add failed")
+		}
+	}
+	if !r.Remove(a) || r.Remove(a) {
+		t.Fatal("expected the first remove to succeed and the second to fail")
+	}
+	// 删除后被交换位置的连接仍然可以删除
+	if !r.Remove(c) || len(r.Get("p")) != 1 || r.Get("p")[0] != b {
+		t.Fatalf("unexpected connections after remove: %v", r.Get("p"))
+	}
+	if !r.Remove(b) || r.Len() != 0 {
+		t.Fatalf("expected registry to be empty, got %d", r.Len())
+	}
+	if _, ok := r.Select("p", func([]*types.PeerConnection) *types.PeerConnection { return nil }); ok {
+		t.Fatal("expected offline peer")
+	}
+}
+
+func TestRegistryAddModes(t *testing.T) {
+	r := New()
+	old := newConn("p", "old")
+	r.Add(old, AddMultiple)
+	if _, ok := r.Add(newConn("p", "rejected"), AddIfAbsent); ok {
+		t.Fatal("expected AddIfAbsent to be rejected")
+	}
+	latest := newConn("p", "latest")
+	replaced, ok := r.Add(latest, AddReplace)
+	if !ok || len(replaced) != 1 || replaced[0] != old {
+		t.Fatalf("expected old connection replaced, got %v", replaced)
+	}
+	if r.Remove(old) {
+		t.Fatal("replaced connection should already be removed")
+	}
+	conn, ok := r.Select("p", func(conns []*types.PeerConnection) *types.PeerConnection { return conns[0] })
+	if !ok || conn != latest || r.Len() != 1 {
+		t.Fatalf("unexpected registry state: %v, len %d", conn, r.Len())
+	}
+}
+
+func TestRegistryRange(t *testing.T) {
+	r := New()
+	for i := 0; i < 100; i++ {
+		r.Add(newConn("peer-"+strconv.Itoa(i), "a"), AddMultiple)
+		r.Add(newConn("peer-"+strconv.Itoa(i), "b"), AddMultiple)
+	}
+	peers, conns := 0, 0
+	r.Range(func(peerId string, list []*types.PeerConnection) bool {
+		peers++
+		conns += len(list)
+		return true
+	})
+	if peers != 100 || conns != 200 {
+		t.Fatalf("expected 100 peers with 200 connections, got %d and %d", peers, conns)
+	}
+	visited := 0
+	r.Range(func(string, []*types.PeerConnection) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Fatalf("expected range to stop after 3 peers, got %d", visited)
+	}
+}
+
+// benchmarkConnections 模拟的在线连接数量
+const benchmarkConnections = 100000
+
+func newBenchmarkRegistry(b *testing.B) (*Registry, []*types.PeerConnection) {
+	b.Helper()
+	r := New()
+	conns := make([]*types.PeerConnection, benchmarkConnections)
+	for i := range conns {
+		conns[i] = newConn("peer-"+strconv.Itoa(i), "")
+		r.Add(conns[i], AddMultiple)
+	}
+	b.ResetTimer()
+	return r, conns
+}
+
+func BenchmarkRegistryRegister(b *testing.B) {
+	r, _ := newBenchmarkRegistry(b)
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Add(newConn("new-"+strconv.FormatInt(n.Add(1), 10), ""), AddMultiple)
+		}
+	})
+}
+
+func BenchmarkRegistryRegisterUnregister(b *testing.B) {
+	r, conns := newBenchmarkRegistry(b)
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			// 每个连接下线后重新上线，保持在线数量不变
+			conn := conns[n.Add(1)%benchmarkConnections]
+			if r.Remove(conn) {
+				r.Add(conn, AddMultiple)
+			}
+		}
+	})
+}
+
+func BenchmarkRegistryLookup(b *testing.B) {
+	r, conns := newBenchmarkRegistry(b)
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			peerId := conns[n.Add(1)%benchmarkConnections].PeerId
+			r.Select(peerId, func(conns []*types.PeerConnection) *types.PeerConnection { return conns[0] })
+		}
+	})
+}
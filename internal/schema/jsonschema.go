@@ -0,0 +1,411 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+var ErrUnsupportedKeyword = errors.New("unsupported json schema keyword")
+
+// Validator 校验消息数据，返回所有错误，校验通过时返回空列表
+type Validator interface {
+	Validate(data []byte) []string
+}
+
+// JsonSchema JSON Schema的常用子集：
+// type、enum、const、properties、required、additionalProperties、items、
+// minItems、maxItems、minLength、maxLength、pattern、minimum、maximum、
+// exclusiveMinimum、exclusiveMaximum、allOf、anyOf、oneOf、not
+// title、description、format等注解关键字会被忽略，其他关键字（如$ref、patternProperties）返回 ErrUnsupportedKeyword
+type JsonSchema struct {
+	// 布尔schema，true接受任何值，false拒绝任何值
+	boolean *bool
+
+	types                []string
+	enum                 []any
+	constValue           *any
+	properties           map[string]*JsonSchema
+	required             []string
+	additionalProperties *JsonSchema
+	items                *JsonSchema
+	minItems, maxItems   *int
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+	exclusiveMin         *float64
+	exclusiveMax         *float64
+	allOf, anyOf, oneOf  []*JsonSchema
+	not                  *JsonSchema
+}
+
+// knownKeywords 支持的校验关键字，以及被忽略的注解关键字
+// 不在其中的关键字不能静默忽略，否则schema会比作者预期的宽松
+var knownKeywords = map[string]bool{
+	"type": true, "enum": true, "const": true, "properties": true, "required": true, "additionalProperties": true,
+	"items": true, "minItems": true, "maxItems": true, "minLength": true, "maxLength": true, "pattern": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true,
+	"allOf": true, "anyOf": true, "oneOf": true, "not": true,
+	// 注解
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true, "format": true,
+	"default": true, "examples": true, "deprecated": true, "readOnly": true, "writeOnly": true,
+}
+
+var errTrailingData = errors.New("unexpected data after json value")
+
+// CompileJsonSchema 解析JSON Schema
+func CompileJsonSchema(data []byte) (*JsonSchema, error) {
+	raw, err := decodeJson(data)
+	if err != nil {
+		return nil, err
+	}
+	return compile(raw, "$")
+}
+
+// decodeJson 解析一个完整的JSON值，之后只允许空白
+func decodeJson(data []byte) (any, error) {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errTrailingData
+	}
+	return value, nil
+}
+
+func compile(raw any, path string) (*JsonSchema, error) {
+	if b, ok := raw.(bool); ok {
+		return &JsonSchema{boolean: &b}, nil
+	}
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or boolean", path)
+	}
+	keywords := make([]string, 0, len(m))
+	for keyword := range m {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	for _, keyword := range keywords {
+		if !knownKeywords[keyword] {
+			return nil, fmt.Errorf("%w: %s.%s", ErrUnsupportedKeyword, path, keyword)
+		}
+	}
+	s := &JsonSchema{}
+	var err error
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s.type: must be a string or an array of strings", path)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s.type: must be a string or an array of strings", path)
+	}
+	if v, ok := m["enum"]; ok {
+		if s.enum, ok = v.([]any); !ok {
+			return nil, fmt.Errorf("%s.enum: must be an array", path)
+		}
+	}
+	if v, ok := m["const"]; ok {
+		s.constValue = &v
+	}
+	if v, ok := m["properties"]; ok {
+		props, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s.properties: must be an object", path)
+		}
+		s.properties = make(map[string]*JsonSchema, len(props))
+		for name, prop := range props {
+			if s.properties[name], err = compile(prop, path+".properties."+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := m["required"]; ok {
+		list, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s.required: must be an array of strings", path)
+		}
+		for _, name := range list {
+			str, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s.required: must be an array of strings", path)
+			}
+			s.required = append(s.required, str)
+		}
+	}
+	if v, ok := m["additionalProperties"]; ok {
+		if s.additionalProperties, err = compile(v, path+".additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := m["items"]; ok {
+		if s.items, err = compile(v, path+".items"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := m["not"]; ok {
+		if s.not, err = compile(v, path+".not"); err != nil {
+			return nil, err
+		}
+	}
+	for keyword, target := range map[string]*[]*JsonSchema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		v, ok := m[keyword]
+		if !ok {
+			continue
+		}
+		list, ok := v.([]any)
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s.%s: must be a non-empty array", path, keyword)
+		}
+		for i, sub := range list {
+			compiled, err := compile(sub, path+"."+keyword+"["+strconv.Itoa(i)+"]")
+			if err != nil {
+				return nil, err
+			}
+			*target = append(*target, compiled)
+		}
+	}
+	for keyword, target := range map[string]**int{"minItems": &s.minItems, "maxItems": &s.maxItems, "minLength": &s.minLength, "maxLength": &s.maxLength} {
+		if v, ok := m[keyword]; ok {
+			n, ok := toFloat(v)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				return nil, fmt.Errorf("%s.%s: must be a non-negative integer", path, keyword)
+			}
+			i := int(n)
+			*target = &i
+		}
+	}
+	for keyword, target := range map[string]**float64{"minimum": &s.minimum, "maximum": &s.maximum, "exclusiveMinimum": &s.exclusiveMin, "exclusiveMaximum": &s.exclusiveMax} {
+		if v, ok := m[keyword]; ok {
+			n, ok := toFloat(v)
+			if !ok {
+				return nil, fmt.Errorf("%s.%s: must be a number", path, keyword)
+			}
+			*target = &n
+		}
+	}
+	if v, ok := m["pattern"]; ok {
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s.pattern: must be a string", path)
+		}
+		if s.pattern, err = regexp.Compile(str); err != nil {
+			return nil, fmt.Errorf("%s.pattern: %v", path, err)
+		}
+	}
+	return s, nil
+}
+
+// Validate data必须是JSON，错误格式如 $.user.name: expected string, got number
+func (s *JsonSchema) Validate(data []byte) []string {
+	value, err := decodeJson(data)
+	if err != nil {
+		return []string{"$: invalid json: " + err.Error()}
+	}
+	errs := make([]string, 0)
+	s.validate(value, "$", &errs)
+	return errs
+}
+
+func (s *JsonSchema) validate(value any, path string, errs *[]string) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+	if s.boolean != nil {
+		if !*s.boolean {
+			fail("value not allowed")
+		}
+		return
+	}
+	if len(s.types) > 0 && !s.matchType(value) {
+		fail("expected %s, got %s", joinTypes(s.types), typeOf(value))
+		return
+	}
+	if s.enum != nil && !containsValue(s.enum, value) {
+		fail("value is not one of the allowed values")
+	}
+	if s.constValue != nil && !equalValue(*s.constValue, value) {
+		fail("value does not match const")
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		s.validateObject(v, path, errs)
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("expected at least %d items, got %d", *s.minItems, len(v))
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("expected at most %d items, got %d", *s.maxItems, len(v))
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, path+"["+strconv.Itoa(i)+"]", errs)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			fail("expected at least %d characters, got %d", *s.minLength, length)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("expected at most %d characters, got %d", *s.maxLength, length)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("does not match pattern %s", s.pattern.String())
+		}
+	case json.Number:
+		n, _ := v.Float64()
+		if s.minimum != nil && n < *s.minimum {
+			fail("must be >= %v", *s.minimum)
+		}
+		if s.maximum != nil && n > *s.maximum {
+			fail("must be <= %v", *s.maximum)
+		}
+		if s.exclusiveMin != nil && n <= *s.exclusiveMin {
+			fail("must be > %v", *s.exclusiveMin)
+		}
+		if s.exclusiveMax != nil && n >= *s.exclusiveMax {
+			fail("must be < %v", *s.exclusiveMax)
+		}
+	}
+	for _, sub := range s.allOf {
+		sub.validate(value, path, errs)
+	}
+	if len(s.anyOf) > 0 && countMatches(s.anyOf, value, path) == 0 {
+		fail("does not match any schema in anyOf")
+	}
+	if len(s.oneOf) > 0 {
+		if n := countMatches(s.oneOf, value, path); n != 1 {
+			fail("must match exactly one schema in oneOf, matched %d", n)
+		}
+	}
+	if s.not != nil && countMatches([]*JsonSchema{s.not}, value, path) == 1 {
+		fail("must not match the schema in not")
+	}
+}
+
+func (s *JsonSchema) validateObject(v map[string]any, path string, errs *[]string) {
+	for _, name := range s.required {
+		if _, ok := v[name]; !ok {
+			*errs = append(*errs, path+"."+name+": required property missing")
+		}
+	}
+	// 按属性名排序，错误列表的顺序保持稳定
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if prop, ok := s.properties[name]; ok {
+			prop.validate(v[name], path+"."+name, errs)
+		} else if s.additionalProperties != nil {
+			if b := s.additionalProperties.boolean; b != nil && !*b {
+				*errs = append(*errs, path+"."+name+": additional property not allowed")
+			} else {
+				s.additionalProperties.validate(v[name], path+"."+name, errs)
+			}
+		}
+	}
+}
+
+func (s *JsonSchema) matchType(value any) bool {
+	for _, t := range s.types {
+		switch t {
+		case "integer":
+			if n, ok := value.(json.Number); ok {
+				f, err := n.Float64()
+				if err == nil && f == math.Trunc(f) {
+					return true
+				}
+			}
+		case typeOf(value):
+			return true
+		}
+	}
+	return false
+}
+
+func countMatches(schemas []*JsonSchema, value any, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		errs := make([]string, 0)
+		sub.validate(value, path, &errs)
+		if len(errs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func typeOf(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+func joinTypes(types []string) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	return fmt.Sprintf("one of %v", types)
+}
+
+func toFloat(v any) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+func containsValue(list []any, value any) bool {
+	for _, v := range list {
+		if equalValue(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalValue 数字按数值比较，如 1 与 1.0 相等
+func equalValue(a any, b any) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"os"
+)
+
+// LoadDescriptorSet 读取 protoc --include_imports --descriptor_set_out 生成的文件
+func LoadDescriptorSet(file string) (*protoregistry.Files, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("parse descriptor set %s: %w", file, err)
+	}
+	return protodesc.NewFiles(set)
+}
+
+// ProtoMessage 按protobuf消息类型校验二进制数据
+type ProtoMessage struct {
+	desc protoreflect.MessageDescriptor
+}
+
+// NewProtoMessage name为消息全名，如 example.v1.EchoRequest
+func NewProtoMessage(files *protoregistry.Files, name string) (*ProtoMessage, error) {
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("find message %s: %w", name, err)
+	}
+	desc, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", name)
+	}
+	return &ProtoMessage{desc: desc}, nil
+}
+
+// Validate 数据必须能解析为该消息，proto2的required字段必须存在，不允许未知字段
+func (m *ProtoMessage) Validate(data []byte) []string {
+	msg := dynamicpb.NewMessage(m.desc)
+	if err := (proto.UnmarshalOptions{AllowPartial: true}).Unmarshal(data, msg); err != nil {
+		return []string{"$: invalid " + string(m.desc.FullName()) + ": " + err.Error()}
+	}
+	errs := make([]string, 0)
+	if err := proto.CheckInitialized(msg); err != nil {
+		errs = append(errs, "$: "+err.Error())
+	}
+	if len(msg.GetUnknown()) > 0 {
+		errs = append(errs, "$: unknown fields in "+string(m.desc.FullName()))
+	}
+	return errs
+}
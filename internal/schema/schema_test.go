@@ -0,0 +1,140 @@
+package schema
+
+import (
+	"errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const userSchema = `{
+	"type": "object",
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+		"age": {"type": "integer", "minimum": 0, "maximum": 150},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+		"id": {"oneOf": [{"type": "string"}, {"type": "integer"}]}
+	}
+}`
+
+func TestJsonSchemaValidate(t *testing.T) {
+	s, err := CompileJsonSchema([]byte(userSchema))
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	tests := []struct {
+		data string
+		errs []string
+	}{
+		{`{"name": "alice", "age": 30, "role": "admin", "tags": ["a"], "id": 1}`, []string{}},
+		{`{"name": "alice"}`, []string{"$.age: required property missing"}},
+		{`{"name": "Alice", "age": 1.5}`, []string{
+			"$.age: expected integer, got number",
+			"$.name: does not match pattern ^[a-z]+$",
+		}},
+		{`{"name": "bob", "age": 200, "extra": true}`, []string{
+			"$.age: must be <= 150",
+			"$.extra: additional property not allowed",
+		}},
+		{`{"name": "bob", "age": 1, "role": "root", "tags": ["a", 1, "c"]}`, []string{
+			"$.role: value is not one of the allowed values",
+			"$.tags: expected at most 2 items, got 3",
+			"$.tags[1]: expected string, got number",
+		}},
+		{`{"name": "bob", "age": 1, "id": true}`, []string{"$.id: must match exactly one schema in oneOf, matched 0"}},
+		{`[]`, []string{"$: expected object, got array"}},
+		{`{`, []string{"$: invalid json: unexpected EOF"}},
+		{"{\"name\": \"bob\", \"age\": 1}\n", []string{}},
+		{`{"name": "bob", "age": 1} garbage`, []string{"$: invalid json: unexpected data after json value"}},
+		{`{"name": "bob", "age": 1}{}`, []string{"$: invalid json: unexpected data after json value"}},
+	}
+	for _, test := range tests {
+		if errs := s.Validate([]byte(test.data)); !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("Validate(%s) = %q, want %q", test.data, errs, test.errs)
+		}
+	}
+}
+
+func TestCompileJsonSchemaRejectsUnsupportedKeywords(t *testing.T) {
+	for _, schema := range []string{
+		`{"properties": {"a": {"$ref": "#/defs/a"}}}`,
+		`{"patternProperties": {"^a": {"type": "string"}}}`,
+		`{"type": "array", "uniqueItems": true}`,
+		`{"minProperties": 1}`,
+		`{"$defs": {"a": {}}}`,
+		`{"dependentRequired": {"a": ["b"]}}`,
+		`{"items": {"typo": "string"}}`,
+	} {
+		if _, err := CompileJsonSchema([]byte(schema)); !errors.Is(err, ErrUnsupportedKeyword) {
+			t.Errorf("CompileJsonSchema(%s) = %v, want unsupported keyword", schema, err)
+		}
+	}
+	// 注解关键字被忽略
+	if _, err := CompileJsonSchema([]byte(`{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "user", "type": "string", "format": "email", "examples": ["a@b.c"]}`)); err != nil {
+		t.Fatalf("annotations should be ignored: %v", err)
+	}
+	if _, err := CompileJsonSchema([]byte(`{"type": "string"} {}`)); err == nil {
+		t.Fatal("trailing data after the schema should be rejected")
+	}
+}
+
+func writeDescriptorSet(t *testing.T) string {
+	t.Helper()
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("echo.proto"),
+		Package: proto.String("example.v1"),
+		Syntax:  proto.String("proto2"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("EchoRequest"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:   proto.String("text"),
+				Number: proto.Int32(1),
+				Label:  descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum(),
+				Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			}},
+		}},
+	}}}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatalf("marshal descriptor set: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "echo.pb")
+	if err = os.WriteFile(file, data, 0644); err != nil {
+		t.Fatalf("write descriptor set: %v", err)
+	}
+	return file
+}
+
+func TestProtoMessageValidate(t *testing.T) {
+	files, err := LoadDescriptorSet(writeDescriptorSet(t))
+	if err != nil {
+		t.Fatalf("load descriptor set: %v", err)
+	}
+	m, err := NewProtoMessage(files, "example.v1.EchoRequest")
+	if err != nil {
+		t.Fatalf("find message: %v", err)
+	}
+	// field 1, wire type 2, "hi"
+	if errs := m.Validate([]byte{0x0a, 0x02, 'h', 'i'}); len(errs) != 0 {
+		t.Fatalf("expected valid message, got %v", errs)
+	}
+	if errs := m.Validate([]byte{}); len(errs) != 1 {
+		t.Fatalf("expected missing required field, got %v", errs)
+	}
+	// field 2 未定义
+	if errs := m.Validate([]byte{0x0a, 0x00, 0x10, 0x01}); len(errs) != 1 {
+		t.Fatalf("expected unknown field error, got %v", errs)
+	}
+	if errs := m.Validate([]byte{0x0a, 0x05}); len(errs) != 1 {
+		t.Fatalf("expected parse error, got %v", errs)
+	}
+	if _, err = NewProtoMessage(files, "example.v1.Missing"); err == nil {
+		t.Fatal("expected unknown message error")
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+)
+
+const (
+	// v1头部最大长度，见 https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+	proxyProtocolV1MaxLength = 107
+)
+
+// proxyProtocolListener 解析PROXY protocol v1/v2头部，把连接的RemoteAddr替换为头部中的源地址
+// 只解析来自受信任代理的连接，其他连接原样返回，防止伪造
+type proxyProtocolListener struct {
+	net.Listener
+	trusted func(addr netip.Addr) bool
+	timeout time.Duration
+}
+
+func newProxyProtocolListener(l net.Listener, trusted func(addr netip.Addr) bool, timeout time.Duration) net.Listener {
+	return &proxyProtocolListener{Listener: l, trusted: trusted, timeout: timeout}
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{Conn: conn, listener: l}, nil
+}
+
+// proxyProtocolConn 头部在第一次Read或RemoteAddr时解析，避免慢客户端阻塞Accept
+type proxyProtocolConn struct {
+	net.Conn
+	listener   *proxyProtocolListener
+	once       sync.Once
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	return c.remoteAddr
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	c.reader = bufio.NewReader(c.Conn)
+	c.remoteAddr = c.Conn.RemoteAddr()
+	source, ok := c.remoteAddr.(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	addr, _ := netip.AddrFromSlice(source.IP)
+	if !c.listener.trusted(addr.Unmap()) {
+		return
+	}
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.listener.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+	first, err := c.reader.Peek(1)
+	if err != nil {
+		c.err = err
+		return
+	}
+	var remote net.Addr
+	switch first[0] {
+	case 'P':
+		remote, err = c.readV1()
+	case proxyProtocolV2Signature[0]:
+		remote, err = c.readV2()
+	default:
+		// 受信任的代理没有发送头部，按普通连接处理
+		return
+	}
+	if err != nil {
+		c.err = err
+		_ = c.Conn.Close()
+		return
+	}
+	if remote != nil {
+		c.remoteAddr = remote
+	}
+}
+
+// readV1 解析文本格式：PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func (c *proxyProtocolConn) readV1() (net.Addr, error) {
+	if prefix, err := c.reader.Peek(6); err != nil || string(prefix) != "PROXY " {
+		// 不是PROXY头部（如 POST 请求），按普通连接处理
+		return nil, nil
+	}
+	line := make([]byte, 0, proxyProtocolV1MaxLength)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyProtocolV1MaxLength {
+			return nil, ErrInvalidProxyHeader
+		}
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrInvalidProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readV2 解析二进制格式，LOCAL命令（如负载均衡的健康检查）保留原地址
+func (c *proxyProtocolConn) readV2() (net.Addr, error) {
+	header, err := c.reader.Peek(16)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:12], proxyProtocolV2Signature) {
+		// 不是PROXY头部，按普通连接处理
+		return nil, nil
+	}
+	var (
+		versionCommand = header[12]
+		family         = header[13] >> 4
+		length         = int(binary.BigEndian.Uint16(header[14:16]))
+	)
+	if versionCommand>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+	if _, err = c.reader.Discard(16); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return nil, err
+	}
+	if versionCommand&0x0f == 0x00 {
+		// LOCAL
+		return nil, nil
+	}
+	switch family {
+	case 0x1: // AF_INET
+		if length < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		ip := netip.AddrFrom4([4]byte(payload[0:4]))
+		port := binary.BigEndian.Uint16(payload[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	case 0x2: // AF_INET6
+		if length < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		ip := netip.AddrFrom16([16]byte(payload[0:16]))
+		port := binary.BigEndian.Uint16(payload[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	default:
+		// AF_UNSPEC、AF_UNIX 保留原地址
+		return nil, nil
+	}
+}
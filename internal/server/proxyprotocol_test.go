@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+)
+
+// proxyConn 客户端发送data后，返回服务端解析PROXY头部后的连接
+func proxyConn(t *testing.T, trusted bool, timeout time.Duration, data []byte, closeWrite bool) (server net.Conn, client net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	listener := newProxyProtocolListener(l, func(netip.Addr) bool { return trusted }, timeout)
+	client, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	if _, err = client.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if closeWrite {
+		_ = client.(*net.TCPConn).CloseWrite()
+	}
+	server, err = listener.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+	return server, client
+}
+
+func proxyV2Header(command byte, family byte, payload []byte) []byte {
+	header := append([]byte(nil), proxyProtocolV2Signature...)
+	header = append(header, 0x20|command, family<<4|0x1)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+func proxyV2Addresses(source netip.AddrPort, destination netip.AddrPort) []byte {
+	payload := append(source.Addr().AsSlice(), destination.Addr().AsSlice()...)
+	payload = binary.BigEndian.AppendUint16(payload, source.Port())
+	return binary.BigEndian.AppendUint16(payload, destination.Port())
+}
+
+func TestProxyProtocolHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		// 为空表示保留直连地址
+		remote string
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"), "192.168.0.1:56324"},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), "[2001:db8::1]:56324"},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), ""},
+		{"v1 unknown with addresses", []byte("PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\n"), ""},
+		{"v2 proxy ipv4", proxyV2Header(0x1, 0x1, proxyV2Addresses(
+			netip.MustParseAddrPort("203.0.113.7:40000"), netip.MustParseAddrPort("10.0.0.1:443"))), "203.0.113.7:40000"},
+		{"v2 proxy ipv6", proxyV2Header(0x1, 0x2, proxyV2Addresses(
+			netip.MustParseAddrPort("[2001:db8::7]:40000"), netip.MustParseAddrPort("[2001:db8::1]:443"))), "[2001:db8::7]:40000"},
+		// 健康检查，带TLV的地址块也会被跳过
+		{"v2 local", proxyV2Header(0x0, 0x1, make([]byte, 20)), ""},
+		{"v2 unspec", proxyV2Header(0x1, 0x0, nil), ""},
+		// 受信任的代理没有发送头部
+		{"no header", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := append(append([]byte(nil), test.header...), "GET / HTTP/1.1\r\n"...)
+			server, client := proxyConn(t, true, time.Second, data, false)
+			remote := test.remote
+			if remote == "" {
+				remote = client.LocalAddr().String()
+			}
+			if addr := server.RemoteAddr().String(); addr != remote {
+				t.Fatalf("expected remote address %s, got %s", remote, addr)
+			}
+			body := make([]byte, len("GET / HTTP/1.1\r\n"))
+			if _, err := io.ReadFull(server, body); err != nil || string(body) != "GET / HTTP/1.1\r\n" {
+				t.Fatalf("the request after the header should be intact, got %q, %v", body, err)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolInvalidHeaders(t *testing.T) {
+	// 版本号不是2
+	badVersion := proxyV2Header(0x1, 0x1, make([]byte, 12))
+	badVersion[12] = 0x11
+	tests := []struct {
+		name     string
+		header   []byte
+		expected error
+	}{
+		{"v1 too long", append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), proxyProtocolV1MaxLength)...), ErrInvalidProxyHeader},
+		{"v1 bad protocol", []byte("PROXY UDP4 192.168.0.1 192.168.0.11 56324 443\r\n"), ErrInvalidProxyHeader},
+		{"v1 bad address", []byte("PROXY TCP4 192.168.0.x 192.168.0.11 56324 443\r\n"), ErrInvalidProxyHeader},
+		{"v1 bad port", []byte("PROXY TCP4 192.168.0.1 192.168.0.11 65536 443\r\n"), ErrInvalidProxyHeader},
+		{"v1 missing fields", []byte("PROXY TCP4 192.168.0.1\r\n"), ErrInvalidProxyHeader},
+		{"v1 truncated", []byte("PROXY TCP4 192.168.0.1 19"), io.EOF},
+		{"v2 bad version", badVersion, ErrInvalidProxyHeader},
+		{"v2 short ipv4 block", proxyV2Header(0x1, 0x1, make([]byte, 8)), ErrInvalidProxyHeader},
+		{"v2 short ipv6 block", proxyV2Header(0x1, 0x2, make([]byte, 12)), ErrInvalidProxyHeader},
+		{"v2 truncated payload", proxyV2Header(0x1, 0x1, make([]byte, 12))[:20], io.ErrUnexpectedEOF},
+		{"v2 truncated signature", proxyProtocolV2Signature[:8], io.EOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := proxyConn(t, true, time.Second, test.header, true)
+			if _, err := server.Read(make([]byte, 1)); !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolUntrustedSource(t *testing.T) {
+	header := "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+	server, client := proxyConn(t, false, time.Second, []byte(header), false)
+	// 不受信任的来源不解析头部，防止伪造地址
+	if addr := server.RemoteAddr().String(); addr != client.LocalAddr().String() {
+		t.Fatalf("untrusted header must be ignored, got %s", addr)
+	}
+	data := make([]byte, len(header))
+	if _, err := io.ReadFull(server, data); err != nil || string(data) != header {
+		t.Fatalf("untrusted header should be passed through, got %q, %v", data, err)
+	}
+}
+
+func TestProxyProtocolTimeout(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"no data":          nil,
+		"partial v1":       []byte("PROXY TCP4 192.168.0.1"),
+		"partial v2":       proxyProtocolV2Signature[:6],
+		"partial v2 block": proxyV2Header(0x1, 0x1, make([]byte, 12))[:18],
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, _ := proxyConn(t, true, 100*time.Millisecond, data, false)
+			start := time.Now()
+			_, err := server.Read(make([]byte, 1))
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("expected a timeout, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("header timeout took %s", elapsed)
+			}
+		})
+	}
+}
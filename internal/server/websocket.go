@@ -1,49 +1,136 @@
 package server
 
 import (
+	"crypto/tls"
+	"expvar"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/internal/config"
 	"github.com/peergoim/signaling-server/internal/handler"
+	"github.com/peergoim/signaling-server/internal/handler/wslogic"
 	"github.com/peergoim/signaling-server/internal/middleware"
 	"github.com/peergoim/signaling-server/internal/svc"
 	"log"
+	"net"
+	"net/http"
+	"time"
 )
 
 type WebSocketServer struct {
 	svcCtx *svc.ServiceContext
+	logic  *wslogic.Logic
 	engine *gin.Engine
 }
 
-func NewWebSocketServer(svcCtx *svc.ServiceContext) *WebSocketServer {
-	w := &WebSocketServer{svcCtx: svcCtx}
-	w.initGin()
-	return w
+// NewWebSocketServer 不修改gin的全局运行模式，由调用方设置
+func NewWebSocketServer(svcCtx *svc.ServiceContext, logic *wslogic.Logic) (*WebSocketServer, error) {
+	w := &WebSocketServer{svcCtx: svcCtx, logic: logic}
+	if err := w.initGin(); err != nil {
+		return nil, err
+	}
+	return w, nil
 }
 
-func (w *WebSocketServer) initGin() {
-	if w.svcCtx.Config.Mode == "pro" {
-		gin.SetMode(gin.ReleaseMode)
-	} else {
-		gin.SetMode(gin.DebugMode)
-	}
+func (w *WebSocketServer) initGin() error {
 	engine := gin.New()
-	engine.Use(middleware.Logger(), middleware.Recovery(), middleware.Cors(w.svcCtx.Config.Cors), middleware.Tracer())
+	// 与ClientIpResolver使用同一份受信任代理，访问日志中的ip才不会被伪造
+	if err := engine.SetTrustedProxies(w.svcCtx.Config().WebSocket.Proxy.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	// 中间件每次请求时读取配置，配置热加载后立即生效
+	engine.Use(middleware.Logger(), middleware.Recovery(), middleware.Cors(w.corsConfig), middleware.Tracer(),
+		middleware.IpFilter(w.ipWhitelistConfig, w.svcCtx.ClientIp, w.svcCtx.Audit))
 	// routes
 	w.initRoutes(engine.Group(""))
 	w.engine = engine
+	return nil
+}
+
+// Handler 返回服务端的http.Handler，用于嵌入已有的http服务或在测试中使用httptest启动
+func (w *WebSocketServer) Handler() http.Handler {
+	return w.engine
 }
 
 func (w *WebSocketServer) Start() {
-	listenOn := w.svcCtx.Config.WebSocket.ListenOn
-	log.Printf("websocket server start at %s\n", listenOn)
-	err := w.engine.Run(listenOn)
+	listenOn := w.svcCtx.Config().WebSocket.ListenOn
+	listener, err := net.Listen("tcp", listenOn)
 	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", listenOn, err)
+	}
+	if err = w.Serve(listener); err != nil {
 		log.Fatalf("failed to start websocket server: %v", err)
 	}
 }
 
+// Serve 在已经监听的listener上提供服务，按配置解析PROXY protocol和启用tls，与http.Server.Serve一样总是返回非nil的错误
+func (w *WebSocketServer) Serve(listener net.Listener) error {
+	var (
+		tlsEnabled  = w.svcCtx.Config().WebSocket.Tls.Enabled
+		proxyConfig = w.svcCtx.Config().WebSocket.Proxy
+		server      = &http.Server{Handler: w.engine}
+	)
+	if proxyConfig.ProxyProtocol {
+		// PROXY protocol头部在TLS握手之前，所以在TLS之下解析
+		listener = newProxyProtocolListener(listener, w.svcCtx.ClientIp.IsTrustedProxy,
+			time.Second*time.Duration(proxyConfig.ProxyProtocolTimeout))
+	}
+	if tlsEnabled {
+		log.Printf("websocket server start at %s with tls\n", listener.Addr())
+		server.TLSConfig = w.tlsConfig()
+		return server.ServeTLS(listener, "", "")
+	}
+	log.Printf("websocket server start at %s\n", listener.Addr())
+	return server.Serve(listener)
+}
+
+// tlsConfig 每次握手时读取当前配置，证书、客户端CA等热加载后无需重启
+// go1.21之前ServeTLS只通过Certificates或GetCertificate判断是否已配置证书，所以同时设置GetCertificate
+func (w *WebSocketServer) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return w.svcCtx.Config().WebSocket.Tls.GetCertificate(hello)
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return w.svcCtx.Config().WebSocket.Tls.ConfigForClient()
+		},
+	}
+}
+
 func (w *WebSocketServer) initRoutes(group *gin.RouterGroup) {
-	h := handler.NewHandler(w.svcCtx)
+	h := handler.NewHandler(w.svcCtx, w.logic)
 	// "已注册的peer-A" 向 "已注册的peer-B" 发送request, 可以使用ws连接，也可以使用http接口
 	group.GET("/ws", h.WsHandler)      // 需要被动接收消息的peer端，需要调用此接口，注册peer
 	group.POST("/call", h.CallHandler) // 匿名peer，向"已注册的peer"发送request, "已注册的peer"返回response
+	// 无法使用websocket的peer，通过sse或长轮询接收请求
+	if w.svcCtx.Config().WebSocket.HttpFallback.Enabled {
+		group.GET("/sse", h.SseHandler)
+		group.POST("/poll/connect", h.PollConnectHandler)
+		group.GET("/poll", h.PollHandler)
+		group.DELETE("/poll", h.PollDisconnectHandler)
+		group.POST("/reply", h.ReplyHandler) // sse、长轮询peer返回response
+	}
+	// 管理接口
+	if w.svcCtx.Config().Admin.Enabled {
+		adminGroup := group.Group("/admin", middleware.AdminAudit(w.svcCtx.Audit, w.svcCtx.ClientIp), middleware.AdminAuth(w.adminConfig))
+		adminGroup.GET("/metrics", gin.WrapH(expvar.Handler()))
+		adminGroup.GET("/virtual-peers", h.ListVirtualPeersHandler)
+		adminGroup.PUT("/virtual-peers", h.PutVirtualPeerHandler)
+		adminGroup.DELETE("/virtual-peers/:peerId", h.DeleteVirtualPeerHandler)
+		adminGroup.GET("/peers", h.ListPeersHandler)
+		adminGroup.DELETE("/peers/:peerId", h.KickPeerHandler)
+		adminGroup.GET("/peers/:peerId/devices", h.ListDevicesHandler)
+		adminGroup.GET("/presence", h.PresenceHandler)
+	}
+}
+
+func (w *WebSocketServer) corsConfig() config.CorsConfig {
+	return w.svcCtx.Config().Cors
+}
+
+func (w *WebSocketServer) ipWhitelistConfig() *config.IpWhitelistConfig {
+	return w.svcCtx.Config().WebSocket.IpWhitelist
+}
+
+func (w *WebSocketServer) adminConfig() config.AdminConfig {
+	return w.svcCtx.Config().Admin
 }
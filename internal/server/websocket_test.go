@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/handler/wslogic"
+	"github.com/peergoim/signaling-server/internal/svc"
+	"github.com/zeromicro/go-zero/core/logx"
+	"math/big"
+	"net"
+	"net/http"
+	"nhooyr.io/websocket"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logx.Disable()
+	gin.SetMode(gin.ReleaseMode)
+	os.Exit(m.Run())
+}
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert parent为nil时生成自签名CA
+func newTestCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write 写入证书和私钥文件，返回文件路径
+func (c *testCert) write(t *testing.T, dir string, name string) (certFile string, keyFile string) {
+	t.Helper()
+	keyDer, _ := x509.MarshalECPrivateKey(c.key)
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func writeTlsConfig(t *testing.T, path string, certFile string, keyFile string, caFile string) {
+	t.Helper()
+	content := fmt.Sprintf(`Mode: pro
+WebSocket:
+  CallTimeout: 2
+  Tls:
+    Enabled: true
+    CertFile: %s
+    KeyFile: %s
+    ClientCaFile: %s
+    PeerIdFromCert: true
+`, certFile, keyFile, caFile)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+}
+
+func TestServeTlsReloadAndPeerIdFromCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	server1Cert, server1Key := newTestCert(t, "server-1", ca).write(t, dir, "server-1")
+	server2Cert, server2Key := newTestCert(t, "server-2", ca).write(t, dir, "server-2")
+	configPath := filepath.Join(dir, "config.yaml")
+	writeTlsConfig(t, configPath, server1Cert, server1Key, caFile)
+
+	c, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	svcCtx := svc.NewServiceContext(c)
+	logic := wslogic.New(svcCtx, wslogic.Hooks{})
+	w, err := NewWebSocketServer(svcCtx, logic)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	// 与Start相同，只通过TLSConfig提供证书
+	httpServer := &http.Server{Handler: w.Handler(), TLSConfig: w.tlsConfig()}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ServeTLS(listener, "", "")
+	}()
+	t.Cleanup(func() { _ = httpServer.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{newTestCert(t, "alice", ca).tlsCertificate()},
+	}}}
+	serverName := func() string {
+		t.Helper()
+		resp, err := httpClient.Get("https://" + listener.Addr().String() + "/ws?peerId=mallory")
+		if err != nil {
+			select {
+			case e := <-serveErr:
+				t.Fatalf("serve tls: %v", e)
+			default:
+			}
+			t.Fatalf("request: %v", err)
+		}
+		defer resp.Body.Close()
+		// peerId与客户端证书不一致
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected 403 for a peerId not matching the certificate, got %d", resp.StatusCode)
+		}
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if name := serverName(); name != "server-1" {
+		t.Fatalf("expected server-1, got %s", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "wss://"+listener.Addr().String()+"/ws", &websocket.DialOptions{HTTPClient: httpClient})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	deadline := time.Now().Add(time.Second)
+	for peers := logic.Peers(); len(peers) != 1 || peers[0].PeerId != "alice"; peers = logic.Peers() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the peerId from the client certificate, got %v", peers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 热加载后新的握手使用新证书
+	writeTlsConfig(t, configPath, server2Cert, server2Key, caFile)
+	if err = config.NewWatcher(configPath, c, svcCtx.UpdateConfig).Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	httpClient.CloseIdleConnections()
+	if name := serverName(); name != "server-2" {
+		t.Fatalf("expected the reloaded certificate, got %s", name)
+	}
+}
@@ -1,12 +1,80 @@
 package svc
 
-import "github.com/peergoim/signaling-server/internal/config"
+import (
+	"github.com/peergoim/signaling-server/internal/audit"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/event"
+	"github.com/peergoim/signaling-server/internal/utils"
+	"github.com/zeromicro/go-zero/core/logx"
+	"sync"
+	"sync/atomic"
+)
 
 type ServiceContext struct {
-	Config *config.Config
+	config   atomic.Pointer[config.Config]
+	Events   *event.Dispatcher
+	Presence *event.Hub // peer上下线事件的进程内订阅
+	ClientIp *utils.ClientIpResolver
+	Audit    *audit.Logger
+
+	reloadListenersLock sync.RWMutex
+	reloadListeners     []func(old *config.Config, new *config.Config)
 }
 
 func NewServiceContext(c *config.Config) *ServiceContext {
-	s := &ServiceContext{Config: c}
+	// 代理地址已经在config.Validate中校验过
+	clientIp, _ := utils.NewClientIpResolver(c.WebSocket.Proxy.TrustedProxies)
+	s := &ServiceContext{
+		Events:   event.NewDispatcher(c.Events),
+		Presence: event.NewHub(),
+		ClientIp: clientIp,
+		Audit:    audit.New(c.Audit),
+	}
+	s.config.Store(c)
 	return s
 }
+
+// Config 当前生效的配置，配置热加载后返回新的配置，不要长期持有返回值
+func (s *ServiceContext) Config() *config.Config {
+	return s.config.Load()
+}
+
+// OnConfigReload 注册配置热加载后的回调
+func (s *ServiceContext) OnConfigReload(fn func(old *config.Config, new *config.Config)) {
+	s.reloadListenersLock.Lock()
+	defer s.reloadListenersLock.Unlock()
+	s.reloadListeners = append(s.reloadListeners, fn)
+}
+
+// UpdateConfig 替换为已经校验过的新配置
+func (s *ServiceContext) UpdateConfig(old *config.Config, new *config.Config) {
+	s.config.Store(new)
+	s.Audit.Update(new.Audit)
+	if old.Log.Level != new.Log.Level {
+		logx.SetLevel(logLevel(new.Log.Level))
+	}
+	s.reloadListenersLock.RLock()
+	defer s.reloadListenersLock.RUnlock()
+	for _, fn := range s.reloadListeners {
+		fn(old, new)
+	}
+}
+
+// Close 退出前调用，投递缓冲中的事件并关闭审计日志
+func (s *ServiceContext) Close() error {
+	s.Events.Close()
+	return s.Audit.Close()
+}
+
+func logLevel(level string) uint32 {
+	switch level {
+	case "debug":
+		return logx.DebugLevel
+	case "error":
+		return logx.ErrorLevel
+	case "severe":
+		return logx.SevereLevel
+	default:
+		return logx.InfoLevel
+	}
+}
@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"context"
+	"github.com/peergoim/signaling-server/internal/fragment"
+	"github.com/peergoim/signaling-server/internal/types"
+)
+
+// FragmentTransport 超过maxFrame的消息按Data拆分为多个分片发送，peer需要在连接时声明支持分片
+type FragmentTransport struct {
+	types.PeerTransport
+	maxFrame int
+}
+
+func NewFragmentTransport(inner types.PeerTransport, maxFrame int) *FragmentTransport {
+	return &FragmentTransport{PeerTransport: inner, maxFrame: maxFrame}
+}
+
+func (t *FragmentTransport) Send(ctx context.Context, typ types.FrameType, data []byte) error {
+	frames, err := fragment.Split(typ, data, t.maxFrame)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		if err = t.PeerTransport.Send(ctx, typ, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"github.com/peergoim/signaling-server/internal/types"
+	"sync"
+)
+
+var ErrTransportClosed = errors.New("transport closed")
+
+type Frame struct {
+	Type types.FrameType
+	Data []byte
+}
+
+// PipeTransport 基于内存channel的传输层
+// sse、长轮询由http请求从管道中取出消息发给peer；测试中直接用它模拟peer
+type PipeTransport struct {
+	frames    chan Frame
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	closeLock sync.Mutex
+	code      types.CloseCode
+	reason    string
+	stats     stats
+}
+
+func NewPipeTransport(ctx context.Context, bufferSize int) *PipeTransport {
+	ctx, cancel := context.WithCancel(ctx)
+	return &PipeTransport{
+		frames: make(chan Frame, bufferSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (t *PipeTransport) Send(ctx context.Context, typ types.FrameType, data []byte) error {
+	err := t.send(ctx, typ, data)
+	t.stats.record(len(data), err)
+	return err
+}
+
+func (t *PipeTransport) send(ctx context.Context, typ types.FrameType, data []byte) error {
+	select {
+	case <-t.ctx.Done():
+		return ErrTransportClosed
+	default:
+	}
+	select {
+	case t.frames <- Frame{Type: typ, Data: data}:
+		return nil
+	case <-t.ctx.Done():
+		return ErrTransportClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *PipeTransport) Close(code types.CloseCode, reason string) error {
+	t.closeOnce.Do(func() {
+		t.closeLock.Lock()
+		t.code = code
+		t.reason = reason
+		t.closeLock.Unlock()
+		t.cancel()
+	})
+	return nil
+}
+
+func (t *PipeTransport) Context() context.Context {
+	return t.ctx
+}
+
+func (t *PipeTransport) Stats() types.TransportStats {
+	return t.stats.snapshot()
+}
+
+// Frames 待发送给peer的消息
+func (t *PipeTransport) Frames() <-chan Frame {
+	return t.frames
+}
+
+// Recv 读取下一条发给peer的消息，连接关闭或ctx取消时返回错误
+func (t *PipeTransport) Recv(ctx context.Context) (Frame, error) {
+	select {
+	case frame := <-t.frames:
+		return frame, nil
+	case <-t.ctx.Done():
+		return Frame{}, ErrTransportClosed
+	case <-ctx.Done():
+		return Frame{}, ctx.Err()
+	}
+}
+
+// Done 连接关闭后此channel被关闭
+func (t *PipeTransport) Done() <-chan struct{} {
+	return t.ctx.Done()
+}
+
+// CloseReason 连接关闭的原因，需在Done()之后调用
+func (t *PipeTransport) CloseReason() (types.CloseCode, string) {
+	<-t.ctx.Done()
+	t.closeLock.Lock()
+	defer t.closeLock.Unlock()
+	return t.code, t.reason
+}
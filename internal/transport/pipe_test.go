@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"context"
+	"github.com/peergoim/signaling-server/internal/types"
+	"testing"
+	"time"
+)
+
+func TestPipeTransportSendRecv(t *testing.T) {
+	pipe := NewPipeTransport(context.Background(), 1)
+	if err := pipe.Send(context.Background(), types.FrameRequest, []byte("hello")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	// 缓冲已满时，发送受ctx控制
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := pipe.Send(ctx, types.FrameRequest, []byte("full")); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	frame, err := pipe.Recv(context.Background())
+	if err != nil || frame.Type != types.FrameRequest || string(frame.Data) != "hello" {
+		t.Fatalf("unexpected frame %+v, %v", frame, err)
+	}
+	stats := pipe.Stats()
+	if stats.FramesSent != 1 || stats.BytesSent != 5 || stats.SendErrors != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestPipeTransportClose(t *testing.T) {
+	pipe := NewPipeTransport(context.Background(), 1)
+	_ = pipe.Close(types.CloseGoingAway, "bye")
+	_ = pipe.Close(types.CloseNormal, "ignored")
+	select {
+	case <-pipe.Done():
+	default:
+		t.Fatal("expected pipe to be done after close")
+	}
+	if code, reason := pipe.CloseReason(); code != types.CloseGoingAway || reason != "bye" {
+		t.Fatalf("the first close should win, got %d %s", code, reason)
+	}
+	if err := pipe.Send(context.Background(), types.FrameRequest, []byte("late")); err != ErrTransportClosed {
+		t.Fatalf("expected closed error, got %v", err)
+	}
+	if _, err := pipe.Recv(context.Background()); err != ErrTransportClosed {
+		t.Fatalf("expected closed error, got %v", err)
+	}
+}
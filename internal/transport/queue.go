@@ -0,0 +1,296 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"github.com/peergoim/signaling-server/internal/types"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrQueueFull = errors.New("write queue full")
+
+const (
+	OverflowDrop       = "drop"       // 队列满时直接失败
+	OverflowBlock      = "block"      // 队列满时等待，超时后失败
+	OverflowDisconnect = "disconnect" // 队列满时断开慢消费者
+)
+
+var (
+	queueDepth         = expvar.NewInt("write_queue_depth")
+	queueDropped       = expvar.NewInt("write_queue_dropped")
+	queueSlowConsumers = expvar.NewInt("write_queue_slow_consumers")
+)
+
+// 优先级从高到低
+const (
+	priorityControl = iota
+	priorityReply
+	priorityRequest
+	priorityCount
+)
+
+type QueueOptions struct {
+	Size         int           // 队列长度，不含控制消息
+	Overflow     string        // drop | block | disconnect
+	BlockTimeout time.Duration // block时的最长等待时间，也是关闭时等待队列中的响应发送完成的最长时间
+	// OnDiscard 已入队但没有发出的请求被丢弃时调用，调用方可以立即失败而不必等到超时
+	OnDiscard func(frame Frame)
+}
+
+type queuedFrame struct {
+	Frame
+	close  bool
+	code   types.CloseCode
+	reason string
+}
+
+// QueueTransport 带发送队列的传输层，由单独的goroutine按优先级发送：控制消息 > 响应 > 请求
+// 慢peer不会阻塞调用方，多个调用方也不会争用底层连接
+type QueueTransport struct {
+	inner   types.PeerTransport
+	options QueueOptions
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	lock   sync.Mutex
+	lanes  [priorityCount][]queuedFrame
+	queued int
+	closed bool
+	// 队列有空位时关闭并替换，唤醒所有等待的Send
+	space chan struct{}
+	wake  chan struct{}
+	done  chan struct{}
+	// Close之后队列中的响应已经发送完
+	flushed     chan struct{}
+	flushedOnce sync.Once
+
+	closeOnce sync.Once
+	closeSent atomic.Bool
+}
+
+func NewQueueTransport(inner types.PeerTransport, options QueueOptions) *QueueTransport {
+	ctx, cancel := context.WithCancel(inner.Context())
+	t := &QueueTransport{
+		inner:   inner,
+		options: options,
+		ctx:     ctx,
+		cancel:  cancel,
+		space:   make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		flushed: make(chan struct{}),
+	}
+	go t.loopWrite()
+	return t
+}
+
+func (t *QueueTransport) Send(ctx context.Context, typ types.FrameType, data []byte) error {
+	priority := priorityRequest
+	if typ == types.FrameResponse {
+		priority = priorityReply
+	}
+	var timeout <-chan time.Time
+	t.lock.Lock()
+	for {
+		if t.closed || t.ctx.Err() != nil {
+			t.lock.Unlock()
+			return ErrTransportClosed
+		}
+		if t.queued < t.options.Size {
+			t.push(priority, queuedFrame{Frame: Frame{Type: typ, Data: data}})
+			t.queued++
+			queueDepth.Add(1)
+			t.lock.Unlock()
+			return nil
+		}
+		switch t.options.Overflow {
+		case OverflowDrop:
+			t.lock.Unlock()
+			queueDropped.Add(1)
+			return ErrQueueFull
+		case OverflowDisconnect:
+			t.lock.Unlock()
+			queueSlowConsumers.Add(1)
+			_ = t.Close(types.CloseSlowConsumer, "slow consumer")
+			return ErrQueueFull
+		}
+		space := t.space
+		t.lock.Unlock()
+		if timeout == nil {
+			timer := time.NewTimer(t.options.BlockTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-space:
+		case <-timeout:
+			queueDropped.Add(1)
+			return ErrQueueFull
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.ctx.Done():
+			return ErrTransportClosed
+		}
+		t.lock.Lock()
+	}
+}
+
+// push 调用时需持有t.lock
+func (t *QueueTransport) push(priority int, frame queuedFrame) {
+	t.lanes[priority] = append(t.lanes[priority], frame)
+	t.notify()
+}
+
+// notify 唤醒发送goroutine
+func (t *QueueTransport) notify() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop 取出优先级最高的消息，调用时需持有t.lock
+func (t *QueueTransport) pop() (queuedFrame, bool) {
+	for priority := range t.lanes {
+		if len(t.lanes[priority]) == 0 {
+			continue
+		}
+		frame := t.lanes[priority][0]
+		t.lanes[priority][0] = queuedFrame{}
+		t.lanes[priority] = t.lanes[priority][1:]
+		if priority != priorityControl {
+			if t.queued == t.options.Size {
+				close(t.space)
+				t.space = make(chan struct{})
+			}
+			t.queued--
+			queueDepth.Add(-1)
+		}
+		return frame, true
+	}
+	return queuedFrame{}, false
+}
+
+func (t *QueueTransport) loopWrite() {
+	defer close(t.done)
+	defer t.discard()
+	for {
+		t.lock.Lock()
+		frame, ok := t.pop()
+		closed := t.closed
+		t.lock.Unlock()
+		if !ok {
+			if closed {
+				// 响应已经发送完，等待Close放入关闭消息
+				t.flushedOnce.Do(func() {
+					close(t.flushed)
+				})
+			}
+			select {
+			case <-t.wake:
+				continue
+			case <-t.inner.Context().Done():
+				t.markClosed()
+				return
+			}
+		}
+		if frame.close {
+			t.closeSent.Store(true)
+			_ = t.inner.Close(frame.code, frame.reason)
+			return
+		}
+		// 使用底层连接的ctx，关闭时不中断正在发送的消息
+		if err := t.inner.Send(t.inner.Context(), frame.Type, frame.Data); err != nil {
+			// 底层连接已断开，发送失败的请求同样通知调用方
+			t.markClosed()
+			if frame.Type == types.FrameRequest {
+				t.discardRequests([]queuedFrame{frame})
+			}
+			return
+		}
+	}
+}
+
+func (t *QueueTransport) markClosed() {
+	t.lock.Lock()
+	t.closed = true
+	t.lock.Unlock()
+	t.cancel()
+}
+
+// discard 丢弃未发送的消息
+func (t *QueueTransport) discard() {
+	t.lock.Lock()
+	requests := t.lanes[priorityRequest]
+	queueDepth.Add(int64(-t.queued))
+	t.queued = 0
+	t.lanes = [priorityCount][]queuedFrame{}
+	t.lock.Unlock()
+	t.discardRequests(requests)
+}
+
+// discardRequests 通知调用方请求没有发出，不能持有t.lock
+func (t *QueueTransport) discardRequests(requests []queuedFrame) {
+	if t.options.OnDiscard == nil {
+		return
+	}
+	for _, frame := range requests {
+		t.options.OnDiscard(frame.Frame)
+	}
+}
+
+// Close 丢弃未发出的请求，队列中的响应发送完后再发送关闭消息，不等待发送完成
+// 响应在BlockTimeout内没有发送完或发送goroutine已经退出时直接关闭底层连接
+func (t *QueueTransport) Close(code types.CloseCode, reason string) error {
+	t.closeOnce.Do(func() {
+		t.lock.Lock()
+		t.closed = true
+		requests := t.lanes[priorityRequest]
+		t.lanes[priorityRequest] = nil
+		t.queued -= len(requests)
+		queueDepth.Add(int64(-len(requests)))
+		t.lock.Unlock()
+		// 唤醒空闲的发送goroutine确认响应已经发送完
+		t.notify()
+		t.discardRequests(requests)
+		t.cancel()
+		go t.flushAndClose(code, reason)
+	})
+	return nil
+}
+
+// flushAndClose 等待队列中的响应发送完，再以最高优先级发送关闭消息
+func (t *QueueTransport) flushAndClose(code types.CloseCode, reason string) {
+	timer := time.NewTimer(t.options.BlockTimeout)
+	defer timer.Stop()
+	select {
+	case <-t.flushed:
+		t.lock.Lock()
+		t.push(priorityControl, queuedFrame{close: true, code: code, reason: reason})
+		t.lock.Unlock()
+		select {
+		case <-t.done:
+		case <-timer.C:
+		}
+	case <-t.done:
+	case <-timer.C:
+	}
+	if !t.closeSent.Load() {
+		_ = t.inner.Close(code, reason)
+	}
+}
+
+func (t *QueueTransport) Context() context.Context {
+	return t.ctx
+}
+
+func (t *QueueTransport) Stats() types.TransportStats {
+	stats := t.inner.Stats()
+	t.lock.Lock()
+	stats.Queued = t.queued
+	t.lock.Unlock()
+	return stats
+}
@@ -0,0 +1,189 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"github.com/peergoim/signaling-server/internal/types"
+	"testing"
+	"time"
+)
+
+func newTestQueue(t *testing.T, size int, overflow string) (*QueueTransport, *PipeTransport) {
+	t.Helper()
+	// 无缓冲的管道，peer不读取时发送goroutine会卡住
+	pipe := NewPipeTransport(context.Background(), 0)
+	queue := NewQueueTransport(pipe, QueueOptions{Size: size, Overflow: overflow, BlockTimeout: 50 * time.Millisecond})
+	t.Cleanup(func() {
+		_ = queue.Close(types.CloseNormal, "")
+		_ = pipe.Close(types.CloseNormal, "")
+	})
+	return queue, pipe
+}
+
+func recv(t *testing.T, pipe *PipeTransport) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	frame, err := pipe.Recv(ctx)
+	if err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	return string(frame.Data)
+}
+
+// waitQueued 等待发送goroutine取走正在发送的消息
+func waitQueued(t *testing.T, queue *QueueTransport, n int) {
+	t.Helper()
+	for i := 0; i < 100 && queue.Stats().Queued != n; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if queued := queue.Stats().Queued; queued != n {
+		t.Fatalf("expected %d queued frames, got %d", n, queued)
+	}
+}
+
+func TestQueueTransportPriority(t *testing.T) {
+	queue, pipe := newTestQueue(t, 8, OverflowBlock)
+	ctx := context.Background()
+	_ = queue.Send(ctx, types.FrameRequest, []byte("r1"))
+	waitQueued(t, queue, 0)
+	_ = queue.Send(ctx, types.FrameRequest, []byte("r2"))
+	_ = queue.Send(ctx, types.FrameResponse, []byte("p1"))
+	_ = queue.Send(ctx, types.FrameRequest, []byte("r3"))
+	_ = queue.Send(ctx, types.FrameResponse, []byte("p2"))
+	var got []string
+	for i := 0; i < 5; i++ {
+		got = append(got, recv(t, pipe))
+	}
+	want := []string{"r1", "p1", "p2", "r2", "r3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("unexpected order %v, want %v", got, want)
+		}
+	}
+}
+
+func TestQueueTransportOverflow(t *testing.T) {
+	ctx := context.Background()
+
+	queue, _ := newTestQueue(t, 1, OverflowDrop)
+	_ = queue.Send(ctx, types.FrameRequest, []byte("sending"))
+	waitQueued(t, queue, 0)
+	_ = queue.Send(ctx, types.FrameRequest, []byte("queued"))
+	if err := queue.Send(ctx, types.FrameRequest, []byte("dropped")); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("drop: expected ErrQueueFull, got %v", err)
+	}
+
+	queue, pipe := newTestQueue(t, 1, OverflowBlock)
+	_ = queue.Send(ctx, types.FrameRequest, []byte("sending"))
+	waitQueued(t, queue, 0)
+	_ = queue.Send(ctx, types.FrameRequest, []byte("queued"))
+	start := time.Now()
+	if err := queue.Send(ctx, types.FrameRequest, []byte("timeout")); !errors.Is(err, ErrQueueFull) || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("block: expected ErrQueueFull after the block timeout, got %v", err)
+	}
+	// peer读取后有了空位
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		recv(t, pipe)
+	}()
+	if err := queue.Send(ctx, types.FrameRequest, []byte("unblocked")); err != nil {
+		t.Fatalf("block: expected send to succeed once there is space, got %v", err)
+	}
+
+	queue, pipe = newTestQueue(t, 1, OverflowDisconnect)
+	_ = queue.Send(ctx, types.FrameRequest, []byte("sending"))
+	waitQueued(t, queue, 0)
+	_ = queue.Send(ctx, types.FrameRequest, []byte("queued"))
+	if err := queue.Send(ctx, types.FrameRequest, []byte("slow")); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("disconnect: expected ErrQueueFull, got %v", err)
+	}
+	// 正在发送的消息卡住，超过BlockTimeout后直接关闭底层连接
+	if code, _ := pipe.CloseReason(); code != types.CloseSlowConsumer {
+		t.Fatalf("disconnect: expected close code %d, got %d", types.CloseSlowConsumer, code)
+	}
+	if err := queue.Send(ctx, types.FrameRequest, []byte("closed")); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("disconnect: expected ErrTransportClosed, got %v", err)
+	}
+}
+
+func TestQueueTransportClosesWithUnderlyingTransport(t *testing.T) {
+	queue, pipe := newTestQueue(t, 4, OverflowBlock)
+	_ = pipe.Close(types.CloseNormal, "")
+	select {
+	case <-queue.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected queue to close with the underlying transport")
+	}
+	if err := queue.Send(context.Background(), types.FrameRequest, []byte("x")); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("expected ErrTransportClosed, got %v", err)
+	}
+}
+
+func TestQueueTransportCloseFlushesReplies(t *testing.T) {
+	pipe := NewPipeTransport(context.Background(), 0)
+	discarded := make(chan string, 4)
+	queue := NewQueueTransport(pipe, QueueOptions{Size: 8, Overflow: OverflowBlock, BlockTimeout: time.Second,
+		OnDiscard: func(frame Frame) { discarded <- string(frame.Data) }})
+	defer pipe.Close(types.CloseNormal, "")
+	ctx := context.Background()
+	_ = queue.Send(ctx, types.FrameRequest, []byte("r1"))
+	waitQueued(t, queue, 0)
+	_ = queue.Send(ctx, types.FrameRequest, []byte("r2"))
+	_ = queue.Send(ctx, types.FrameResponse, []byte("p1"))
+	_ = queue.Send(ctx, types.FrameResponse, []byte("p2"))
+	_ = queue.Close(types.CloseGoingAway, "bye")
+
+	// 未发出的请求立即通知调用方
+	select {
+	case got := <-discarded:
+		if got != "r2" {
+			t.Fatalf("expected r2 to be discarded, got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the queued request to be discarded")
+	}
+	// 正在发送的请求和队列中的响应在关闭消息之前发送
+	for _, want := range []string{"r1", "p1", "p2"} {
+		if got := recv(t, pipe); got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	}
+	select {
+	case <-pipe.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the close frame after the replies")
+	}
+	if code, reason := pipe.CloseReason(); code != types.CloseGoingAway || reason != "bye" {
+		t.Fatalf("unexpected close %d %s", code, reason)
+	}
+	if len(discarded) != 0 {
+		t.Fatalf("replies should not be discarded, got %s", <-discarded)
+	}
+}
+
+func TestQueueTransportDiscardsRequestsOnDisconnect(t *testing.T) {
+	pipe := NewPipeTransport(context.Background(), 0)
+	discarded := make(chan string, 4)
+	queue := NewQueueTransport(pipe, QueueOptions{Size: 8, Overflow: OverflowBlock, BlockTimeout: time.Second,
+		OnDiscard: func(frame Frame) { discarded <- string(frame.Data) }})
+	defer queue.Close(types.CloseNormal, "")
+	ctx := context.Background()
+	_ = queue.Send(ctx, types.FrameRequest, []byte("r1"))
+	waitQueued(t, queue, 0)
+	_ = queue.Send(ctx, types.FrameRequest, []byte("r2"))
+	_ = queue.Send(ctx, types.FrameResponse, []byte("p1"))
+	_ = queue.Send(ctx, types.FrameRequest, []byte("r3"))
+	// 底层连接断开，正在发送的r1失败
+	_ = pipe.Close(types.CloseNormal, "")
+	for _, want := range []string{"r1", "r2", "r3"} {
+		select {
+		case got := <-discarded:
+			if got != want {
+				t.Fatalf("expected %s to be discarded, got %s", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s to be discarded", want)
+		}
+	}
+}
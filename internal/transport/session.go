@@ -0,0 +1,230 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"github.com/peergoim/signaling-server/internal/types"
+	"sync"
+	"time"
+)
+
+var ErrSessionBufferFull = errors.New("session buffer full")
+
+// sessionFrame callId不为空的请求在peer回复前都算作未完成
+type sessionFrame struct {
+	Frame
+	callId string
+}
+
+// SessionTransport 可恢复的会话传输层
+// 底层连接断开后消息先缓冲，peer重连后 Attach 新连接，重发未完成的请求，再发送缓冲的消息
+// lock只保护状态，不在持有lock时向底层连接写入，慢连接不会阻塞 Complete、Detach 等调用
+type SessionTransport struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	lock    sync.Mutex
+	current types.PeerTransport
+	// Attach 中、正在发送缓冲消息的新连接，发送完成后成为current，期间新的消息继续缓冲以保证顺序
+	attaching  types.PeerTransport
+	bufferSize int
+	buffer     []sessionFrame
+	inFlight   []sessionFrame
+	closeOnce  sync.Once
+	stats      stats
+}
+
+func NewSessionTransport(ctx context.Context, current types.PeerTransport, bufferSize int) *SessionTransport {
+	ctx, cancel := context.WithCancel(ctx)
+	return &SessionTransport{
+		ctx:        ctx,
+		cancel:     cancel,
+		current:    current,
+		bufferSize: bufferSize,
+	}
+}
+
+func (t *SessionTransport) Send(ctx context.Context, typ types.FrameType, data []byte) error {
+	return t.send(ctx, sessionFrame{Frame: Frame{Type: typ, Data: data}})
+}
+
+// SendCall 发送请求，在 Complete 之前断线重连会重发
+func (t *SessionTransport) SendCall(ctx context.Context, callId string, data []byte) error {
+	return t.send(ctx, sessionFrame{Frame: Frame{Type: types.FrameRequest, Data: data}, callId: callId})
+}
+
+// Complete 请求已收到回复或不再等待
+func (t *SessionTransport) Complete(callId string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.inFlight = removeCall(t.inFlight, callId)
+	t.buffer = removeCall(t.buffer, callId)
+}
+
+func (t *SessionTransport) send(ctx context.Context, frame sessionFrame) error {
+	t.lock.Lock()
+	if t.ctx.Err() != nil {
+		t.lock.Unlock()
+		t.stats.record(0, ErrTransportClosed)
+		return ErrTransportClosed
+	}
+	current := t.current
+	if current == nil {
+		err := t.bufferFrame(frame)
+		t.lock.Unlock()
+		return err
+	}
+	// 发送前记录，避免peer的回复先于记录到达
+	t.track(frame)
+	t.lock.Unlock()
+
+	err := current.Send(ctx, frame.Type, frame.Data)
+	if err == nil {
+		t.stats.record(len(frame.Data), nil)
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.untrack(frame)
+	if ctx.Err() != nil || t.ctx.Err() != nil {
+		t.stats.record(0, err)
+		return err
+	}
+	// 底层连接已断开，转为缓冲，等待peer恢复会话
+	if t.current == current {
+		t.current = nil
+	}
+	return t.bufferFrame(frame)
+}
+
+// bufferFrame 调用时需持有t.lock
+func (t *SessionTransport) bufferFrame(frame sessionFrame) error {
+	if len(t.buffer) >= t.bufferSize {
+		t.stats.record(0, ErrSessionBufferFull)
+		return ErrSessionBufferFull
+	}
+	t.buffer = append(t.buffer, frame)
+	t.stats.record(len(frame.Data), nil)
+	return nil
+}
+
+func (t *SessionTransport) track(frame sessionFrame) {
+	if frame.callId != "" {
+		t.inFlight = append(t.inFlight, frame)
+	}
+}
+
+func (t *SessionTransport) untrack(frame sessionFrame) {
+	if frame.callId != "" {
+		t.inFlight = removeCall(t.inFlight, frame.callId)
+	}
+}
+
+// Attach 恢复会话，返回被替换的旧连接（可能为nil）
+// 先重发未完成的请求，再按顺序发送断开期间缓冲的消息，peer需要按callId去重
+// 缓冲的消息需在timeout内发送完成，否则关闭新连接，剩余的消息继续缓冲，peer可以再次恢复会话
+func (t *SessionTransport) Attach(current types.PeerTransport, timeout time.Duration) types.PeerTransport {
+	t.lock.Lock()
+	old := t.current
+	if old == nil {
+		old = t.attaching
+	}
+	t.current, t.attaching = nil, current
+	pending := append(t.inFlight, t.buffer...)
+	t.inFlight, t.buffer = nil, nil
+	t.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(t.ctx, timeout)
+	defer cancel()
+	for {
+		for i, frame := range pending {
+			t.lock.Lock()
+			t.track(frame)
+			t.lock.Unlock()
+			if err := current.Send(ctx, frame.Type, frame.Data); err != nil {
+				t.lock.Lock()
+				t.untrack(frame)
+				// 新连接也断开了或发送超时，剩余的消息放回缓冲的最前面
+				t.buffer = append(append([]sessionFrame(nil), pending[i:]...), t.buffer...)
+				if t.attaching == current {
+					t.attaching = nil
+				}
+				t.lock.Unlock()
+				_ = current.Close(types.CloseGoingAway, "session resume failed")
+				return old
+			}
+		}
+		t.lock.Lock()
+		if t.attaching != current {
+			// 发送期间被 Detach、Close 或更新的 Attach 替换
+			t.lock.Unlock()
+			return old
+		}
+		// 发送期间新缓冲的消息
+		pending, t.buffer = t.buffer, nil
+		if len(pending) == 0 {
+			t.current, t.attaching = current, nil
+			t.lock.Unlock()
+			return old
+		}
+		t.lock.Unlock()
+	}
+}
+
+// Detach 底层连接断开，之后的消息进入缓冲；transport不是当前连接时（已被新连接替换）返回false
+func (t *SessionTransport) Detach(transport types.PeerTransport) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.attaching == transport {
+		t.attaching = nil
+		return t.current == nil
+	}
+	if t.current != transport {
+		// 发送失败时已经置为nil
+		return t.current == nil && t.attaching == nil
+	}
+	t.current = nil
+	return t.attaching == nil
+}
+
+// Attached 是否有可用（或正在恢复）的底层连接
+func (t *SessionTransport) Attached() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.current != nil || t.attaching != nil
+}
+
+// Close 关闭会话及当前的底层连接
+func (t *SessionTransport) Close(code types.CloseCode, reason string) error {
+	var err error
+	t.closeOnce.Do(func() {
+		t.lock.Lock()
+		current, attaching := t.current, t.attaching
+		t.current, t.attaching, t.buffer, t.inFlight = nil, nil, nil, nil
+		t.lock.Unlock()
+		t.cancel()
+		if attaching != nil {
+			_ = attaching.Close(code, reason)
+		}
+		if current != nil {
+			err = current.Close(code, reason)
+		}
+	})
+	return err
+}
+
+func (t *SessionTransport) Context() context.Context {
+	return t.ctx
+}
+
+func (t *SessionTransport) Stats() types.TransportStats {
+	return t.stats.snapshot()
+}
+
+func removeCall(frames []sessionFrame, callId string) []sessionFrame {
+	for i, frame := range frames {
+		if frame.callId == callId {
+			return append(frames[:i], frames[i+1:]...)
+		}
+	}
+	return frames
+}
@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"context"
+	"github.com/peergoim/signaling-server/internal/types"
+	"testing"
+	"time"
+)
+
+// detachedSession 底层连接已断开、缓冲了frames的会话
+func detachedSession(t *testing.T, frames ...string) *SessionTransport {
+	t.Helper()
+	pipe := NewPipeTransport(context.Background(), 8)
+	session := NewSessionTransport(context.Background(), pipe, 8)
+	t.Cleanup(func() { _ = session.Close(types.CloseNormal, "") })
+	if !session.Detach(pipe) {
+		t.Fatal("expected the session to be detached")
+	}
+	for _, frame := range frames {
+		if err := session.SendCall(context.Background(), frame, []byte(frame)); err != nil {
+			t.Fatalf("buffer %s: %v", frame, err)
+		}
+	}
+	return session
+}
+
+func TestSessionSendDoesNotBlockState(t *testing.T) {
+	// peer不读取，发送会一直阻塞
+	pipe := NewPipeTransport(context.Background(), 0)
+	session := NewSessionTransport(context.Background(), pipe, 8)
+	defer session.Close(types.CloseNormal, "")
+	sent := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		sent <- session.SendCall(ctx, "c1", []byte("c1"))
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			session.Attached()
+			session.Complete("c2")
+			_ = session.Stats()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("a blocked send should not block the session state")
+	}
+	if _, err := pipe.Recv(context.Background()); err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
+
+func TestSessionAttachFlushTimeout(t *testing.T) {
+	session := detachedSession(t, "c1", "c2", "c3")
+	slow := NewPipeTransport(context.Background(), 0)
+	start := time.Now()
+	session.Attach(slow, 100*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("attach should give up after the flush timeout, took %s", elapsed)
+	}
+	if code, reason := slow.CloseReason(); code != types.CloseGoingAway || reason != "session resume failed" {
+		t.Fatalf("the slow connection should be closed, got %d %s", code, reason)
+	}
+	if session.Attached() {
+		t.Fatal("session should stay detached after a failed resume")
+	}
+	// 再次恢复时按顺序收到所有消息
+	pipe := NewPipeTransport(context.Background(), 8)
+	session.Attach(pipe, time.Second)
+	for _, want := range []string{"c1", "c2", "c3"} {
+		if got := recv(t, pipe); got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	}
+}
+
+func TestSessionAttachKeepsOrder(t *testing.T) {
+	session := detachedSession(t, "c1", "c2")
+	pipe := NewPipeTransport(context.Background(), 0)
+	attached := make(chan struct{})
+	go func() {
+		defer close(attached)
+		session.Attach(pipe, time.Second)
+	}()
+	if got := recv(t, pipe); got != "c1" {
+		t.Fatalf("expected c1, got %s", got)
+	}
+	if !session.Attached() {
+		t.Fatal("a session being resumed counts as attached")
+	}
+	// 缓冲的消息发送完之前，新的消息排在后面
+	if err := session.Send(context.Background(), types.FrameResponse, []byte("p1")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	for _, want := range []string{"c2", "p1"} {
+		if got := recv(t, pipe); got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	}
+	<-attached
+	// 恢复后直接发送
+	go func() { _ = session.Send(context.Background(), types.FrameResponse, []byte("p2")) }()
+	if got := recv(t, pipe); got != "p2" {
+		t.Fatalf("expected p2, got %s", got)
+	}
+}
+
+func TestSessionDetachWhileAttaching(t *testing.T) {
+	session := detachedSession(t, "c1")
+	old := NewPipeTransport(context.Background(), 0)
+	pipe := NewPipeTransport(context.Background(), 0)
+	attached := make(chan struct{})
+	go func() {
+		defer close(attached)
+		session.Attach(pipe, time.Second)
+	}()
+	// 等待Attach开始发送
+	for i := 0; i < 100 && !session.Attached(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	// 已被替换的旧连接断开不影响正在恢复的连接
+	if session.Detach(old) {
+		t.Fatal("detaching a replaced connection should not detach the session")
+	}
+	if got := recv(t, pipe); got != "c1" {
+		t.Fatalf("expected c1, got %s", got)
+	}
+	<-attached
+	if !session.Detach(pipe) || session.Attached() {
+		t.Fatal("detaching the current connection should detach the session")
+	}
+}
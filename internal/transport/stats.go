@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"github.com/peergoim/signaling-server/internal/types"
+	"sync/atomic"
+	"time"
+)
+
+// stats 各传输层共用的发送统计
+type stats struct {
+	framesSent uint64
+	bytesSent  uint64
+	sendErrors uint64
+	lastSendAt int64
+}
+
+func (s *stats) record(size int, err error) {
+	if err != nil {
+		atomic.AddUint64(&s.sendErrors, 1)
+		return
+	}
+	atomic.AddUint64(&s.framesSent, 1)
+	atomic.AddUint64(&s.bytesSent, uint64(size))
+	atomic.StoreInt64(&s.lastSendAt, time.Now().UnixNano())
+}
+
+func (s *stats) snapshot() types.TransportStats {
+	st := types.TransportStats{
+		FramesSent: atomic.LoadUint64(&s.framesSent),
+		BytesSent:  atomic.LoadUint64(&s.bytesSent),
+		SendErrors: atomic.LoadUint64(&s.sendErrors),
+	}
+	if last := atomic.LoadInt64(&s.lastSendAt); last > 0 {
+		st.LastSendAt = time.Unix(0, last)
+	}
+	return st
+}
@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"context"
+	"github.com/peergoim/signaling-server/internal/types"
+	"nhooyr.io/websocket"
+)
+
+// WebSocketTransport 基于websocket连接的传输层
+type WebSocketTransport struct {
+	conn   *websocket.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	stats  stats
+}
+
+// NewWebSocketTransport ctx为连接的生命周期，Close后被取消
+func NewWebSocketTransport(ctx context.Context, conn *websocket.Conn) *WebSocketTransport {
+	ctx, cancel := context.WithCancel(ctx)
+	return &WebSocketTransport{conn: conn, ctx: ctx, cancel: cancel}
+}
+
+func (t *WebSocketTransport) Send(ctx context.Context, typ types.FrameType, data []byte) error {
+	messageType := websocket.MessageBinary
+	if typ == types.FrameResponse {
+		messageType = websocket.MessageText
+	}
+	err := t.conn.Write(ctx, messageType, data)
+	t.stats.record(len(data), err)
+	return err
+}
+
+func (t *WebSocketTransport) Close(code types.CloseCode, reason string) error {
+	defer t.cancel()
+	return t.conn.Close(websocket.StatusCode(code), reason)
+}
+
+func (t *WebSocketTransport) Context() context.Context {
+	return t.ctx
+}
+
+func (t *WebSocketTransport) Stats() types.TransportStats {
+	return t.stats.snapshot()
+}
@@ -15,6 +15,8 @@ type CallRequest struct {
 	Method string `json:"method"`
 	//请求携带的数据
 	Data []byte `json:"data"`
+	//分片信息，Data过大时拆分为多个消息发送
+	Fragment *Fragment `json:"fragment,omitempty"`
 }
 
 var (
@@ -57,6 +59,14 @@ type CallResponse struct {
 	Status codes.Code `json:"status"`
 	//响应携带的数据
 	Data []byte `json:"data"`
+	//分片信息，Data过大时拆分为多个消息发送
+	Fragment *Fragment `json:"fragment,omitempty"`
+}
+
+// Fragment 分片信息，同一消息的所有分片携带相同的callId，Data按Index顺序拼接后为完整数据
+type Fragment struct {
+	Index int `json:"index"`
+	Count int `json:"count"`
 }
 
 func (r *CallResponse) ToBytes() []byte {
@@ -75,10 +85,19 @@ var (
 		Status: codes.InvalidArgument,
 		Data:   nil,
 	}
-	PeerOfflineResponseError = errors.New("peer offline")
-	CallTimeoutResponseError = errors.New("call timeout")
+	PeerOfflineResponseError      = errors.New("peer offline")
+	CallTimeoutResponseError      = errors.New("call timeout")
+	UnimplementedResponseError    = errors.New("method not served by peer")
+	InvalidArgumentResponseError  = errors.New("invalid request")
+	InvalidResponseError          = errors.New("invalid response from peer")
+	PermissionDeniedResponseError = errors.New("call not permitted")
 )
 
+// ValidationErrors 数据校验失败时，响应的Data为此结构的JSON
+type ValidationErrors struct {
+	Errors []string `json:"errors"`
+}
+
 func PeerOfflineResponse(callId string, method string) *CallResponse {
 	return &CallResponse{
 		CallId: callId,
@@ -96,3 +115,51 @@ func CallTimeoutResponse(callId string, method string) *CallResponse {
 		Data:   nil,
 	}
 }
+
+// PermissionDeniedResponse 调用方没有权限调用此方法，不会转发给peer
+func PermissionDeniedResponse(callId string, method string) *CallResponse {
+	return &CallResponse{
+		CallId: callId,
+		Method: method,
+		Status: codes.PermissionDenied,
+		Data:   nil,
+	}
+}
+
+func UnimplementedResponse(callId string, method string) *CallResponse {
+	return &CallResponse{
+		CallId: callId,
+		Method: method,
+		Status: codes.Unimplemented,
+		Data:   nil,
+	}
+}
+
+// InvalidArgumentResponse 请求数据校验失败，不会转发给peer
+func InvalidArgumentResponse(callId string, method string, errs []string) *CallResponse {
+	data, _ := json.Marshal(ValidationErrors{Errors: errs})
+	return &CallResponse{
+		CallId: callId,
+		Method: method,
+		Status: codes.InvalidArgument,
+		Data:   data,
+	}
+}
+
+// ResourceExhaustedResponse 消息超过大小或内存限制
+func ResourceExhaustedResponse(callId string, method string, errs []string) *CallResponse {
+	resp := InvalidArgumentResponse(callId, method, errs)
+	resp.Status = codes.ResourceExhausted
+	return resp
+}
+
+// InvalidResponse peer返回的响应数据校验失败
+func InvalidResponse(callId string, method string, errs []string) *CallResponse {
+	data, _ := json.Marshal(ValidationErrors{Errors: errs})
+	return &CallResponse{
+		CallId: callId,
+		Method: method,
+		Status: codes.Internal,
+		Data:   data,
+	}
+}
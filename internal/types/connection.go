@@ -2,16 +2,116 @@ package types
 
 import (
 	"context"
-	"nhooyr.io/websocket"
+	"net/http"
+	"strings"
 	"time"
 )
 
+// FrameType 发给peer的消息类型
+type FrameType int
+
+const (
+	// FrameRequest 转发给peer的请求，websocket中对应binary消息
+	FrameRequest FrameType = iota
+	// FrameResponse 返回给peer的响应，websocket中对应text消息
+	FrameResponse
+)
+
+// CloseCode 关闭连接的原因码，与websocket关闭码保持一致，4000-4999为应用自定义
+type CloseCode int
+
+const (
+	CloseNormal          CloseCode = 1000
+	CloseGoingAway       CloseCode = 1001
+	ClosePolicyViolation CloseCode = 1008
+	CloseInternalError   CloseCode = 1011
+	// CloseSessionReplaced 同一peerId的新连接上线，旧连接被踢下线
+	CloseSessionReplaced CloseCode = 4001
+	// CloseSessionRejected 同一peerId已有连接在线，新连接被拒绝
+	CloseSessionRejected CloseCode = 4002
+	// CloseSlowConsumer 发送队列已满，peer接收过慢被断开
+	CloseSlowConsumer CloseCode = 4003
+	// CloseKicked 被管理接口踢下线，客户端不应自动重连
+	CloseKicked CloseCode = 4004
+)
+
+// TransportStats 传输层发送统计
+type TransportStats struct {
+	FramesSent uint64
+	BytesSent  uint64
+	SendErrors uint64
+	LastSendAt time.Time
+	// Queued 发送队列中等待发送的消息数量
+	Queued int
+}
+
+// PeerTransport peer连接的传输层，websocket、sse、长轮询、内存管道等传输方式都实现此接口
+type PeerTransport interface {
+	// Send 向peer发送一条消息
+	Send(ctx context.Context, typ FrameType, data []byte) error
+	// Close 携带原因码关闭连接，重复关闭不会报错
+	Close(code CloseCode, reason string) error
+	// Context 连接关闭后被取消
+	Context() context.Context
+	// Stats 发送统计
+	Stats() TransportStats
+}
+
 type PeerConnection struct {
-	PeerId              string
-	WenSocketConnection *websocket.Conn
-	Headers             map[string]string
-	Ctx                 context.Context
-	ConnectedAt         time.Time
-	RemoteIp            string
-	ClientIp            string
+	PeerId string
+	// DeviceId 连接时可选的设备/实例标识，可以通过 peerId/deviceId 向指定设备发送请求
+	DeviceId string
+	// Methods 连接提供的方法，连接时通过 methods 参数或之后调用 $server.announce 声明
+	Methods     *MethodSet
+	Transport   PeerTransport
+	Headers     map[string]string
+	Ctx         context.Context
+	ConnectedAt time.Time
+	RemoteIp    string
+	ClientIp    string
+	// SessionToken 启用会话恢复时连接所属会话的token，用于从连接找到会话
+	SessionToken string
+}
+
+// DeviceInfo 一个peer在线的设备
+type DeviceInfo struct {
+	DeviceId    string            `json:"deviceId"`
+	ConnectedAt time.Time         `json:"connectedAt"`
+	ClientIp    string            `json:"clientIp"`
+	Headers     map[string]string `json:"headers"`
+	// Methods 未声明方法时为空
+	Methods []string `json:"methods"`
+}
+
+// PeerInfo 一个在线的peer
+type PeerInfo struct {
+	PeerId  string       `json:"peerId"`
+	Devices []DeviceInfo `json:"devices"`
+}
+
+// sensitiveHeaders 设备列表中不返回的请求头
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Sec-Websocket-Key"}
+
+// DeviceInfo 返回连接的设备信息，不包含敏感请求头
+func (c *PeerConnection) DeviceInfo() DeviceInfo {
+	headers := make(map[string]string, len(c.Headers))
+	for k, v := range c.Headers {
+		headers[k] = v
+	}
+	for _, k := range sensitiveHeaders {
+		delete(headers, http.CanonicalHeaderKey(k))
+	}
+	return DeviceInfo{
+		DeviceId:    c.DeviceId,
+		ConnectedAt: c.ConnectedAt,
+		ClientIp:    c.ClientIp,
+		Headers:     headers,
+		Methods:     c.Methods.List(),
+	}
+}
+
+// SplitPeerAddress 拆分 peerId/deviceId 形式的地址，没有deviceId时返回空字符串
+func SplitPeerAddress(address string) (peerId string, deviceId string) {
+	peerId, deviceId, _ = strings.Cut(address, "/")
+	return peerId, deviceId
 }
@@ -0,0 +1,93 @@
+package types
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MethodSet peer声明提供的方法，方法可以带版本号，如 echo@2
+// 从未声明过的peer视为提供所有方法，兼容不声明方法的旧客户端
+type MethodSet struct {
+	lock      sync.RWMutex
+	announced bool
+	methods   map[string]struct{}
+}
+
+func NewMethodSet() *MethodSet {
+	return &MethodSet{methods: make(map[string]struct{})}
+}
+
+// ParseMethods 解析逗号分隔的方法列表，忽略空白项
+func ParseMethods(s string) []string {
+	methods := make([]string, 0)
+	for _, m := range strings.Split(s, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// Announce 替换为新声明的方法列表
+func (s *MethodSet) Announce(methods []string) {
+	set := make(map[string]struct{}, len(methods))
+	for _, m := range methods {
+		set[m] = struct{}{}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.announced = true
+	s.methods = set
+}
+
+// Announced 是否声明过方法
+func (s *MethodSet) Announced() bool {
+	if s == nil {
+		return false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.announced
+}
+
+// Serves 是否提供method，声明时不带版本号的方法提供所有版本，请求不带版本号时匹配任意版本
+func (s *MethodSet) Serves(method string) bool {
+	if s == nil {
+		return true
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if !s.announced {
+		return true
+	}
+	if _, ok := s.methods[method]; ok {
+		return true
+	}
+	name, version, versioned := strings.Cut(method, "@")
+	if versioned {
+		_, ok := s.methods[name]
+		return ok && version != ""
+	}
+	for m := range s.methods {
+		if n, _, _ := strings.Cut(m, "@"); n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// List 已声明的方法，按名称排序
+func (s *MethodSet) List() []string {
+	if s == nil {
+		return []string{}
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]string, 0, len(s.methods))
+	for m := range s.methods {
+		list = append(list, m)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -1,33 +1,107 @@
 package utils
 
 import (
+	"github.com/peergoim/signaling-server/internal/ipfilter"
+	"net"
 	"net/http"
+	"net/netip"
 	"strings"
+	"sync/atomic"
 )
 
-func GetClientIp(r *http.Request) string {
-	if cdn := r.Header.Get("Tencent-Acceleration-Domain-Name"); cdn != "" {
-		return r.Header.Get("X-Forwarded-For") + ", " + cdn
+// ClientIpResolver 解析客户端真实ip
+// 只有直连地址属于受信任代理时，才会解析 Forwarded / X-Forwarded-For / X-Real-Ip 请求头，
+// 并从右向左跳过受信任的代理，第一个不受信任的地址即为客户端地址，防止客户端伪造ip
+type ClientIpResolver struct {
+	trustedProxies atomic.Pointer[ipfilter.Tree]
+}
+
+func NewClientIpResolver(trustedProxies []string) (*ClientIpResolver, error) {
+	r := &ClientIpResolver{}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetTrustedProxies 替换受信任的代理，可以在运行时调用
+func (r *ClientIpResolver) SetTrustedProxies(trustedProxies []string) error {
+	tree := ipfilter.NewTree()
+	for _, proxy := range trustedProxies {
+		prefix, err := ipfilter.ParseRule(proxy)
+		if err != nil {
+			return err
+		}
+		tree.Insert(prefix)
+	}
+	r.trustedProxies.Store(tree)
+	return nil
+}
+
+// IsTrustedProxy addr是否为受信任的代理
+func (r *ClientIpResolver) IsTrustedProxy(addr netip.Addr) bool {
+	return r.trustedProxies.Load().Contains(addr)
+}
+
+// ClientIp 返回客户端ip，不带端口
+func (r *ClientIpResolver) ClientIp(req *http.Request) string {
+	remote, err := ipfilter.ParseAddr(req.RemoteAddr)
+	if err != nil {
+		host, _, _ := net.SplitHostPort(req.RemoteAddr)
+		return host
 	}
-	ip := r.Header.Get("X-Forwarded-For")
-	if ip == "" {
-		ip = r.Header.Get("X-Real-Ip")
+	if !r.IsTrustedProxy(remote) {
+		return remote.String()
 	}
-	if ip == "" {
-		ip = r.RemoteAddr
+	chain := forwardedFor(req.Header)
+	if len(chain) == 0 {
+		chain = splitHeader(req.Header.Values("X-Forwarded-For"))
 	}
-	if strings.Contains(ip, ":") {
-		split := strings.Split(ip, ":")
-		ip = ""
-		for i, s := range split {
-			if i != len(split)-1 {
-				ip += s + ":"
+	if len(chain) == 0 {
+		if realIp, err := ipfilter.ParseAddr(req.Header.Get("X-Real-Ip")); err == nil {
+			return realIp.String()
+		}
+		return remote.String()
+	}
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, err := ipfilter.ParseAddr(chain[i])
+		if err != nil {
+			// 无法解析（如 unknown 或混淆标识），停在最后一个可信的地址
+			break
+		}
+		client = addr
+		if !r.IsTrustedProxy(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedFor 解析RFC 7239 Forwarded请求头中的for参数，按出现顺序返回
+func forwardedFor(header http.Header) []string {
+	result := make([]string, 0)
+	for _, element := range splitHeader(header.Values("Forwarded")) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || !strings.EqualFold(key, "for") {
+				continue
 			}
+			// IPv6需要加引号，如 for="[2001:db8::1]:4711"
+			result = append(result, strings.Trim(value, "\""))
 		}
-		ip = strings.TrimSuffix(ip, ":")
 	}
-	if ip == "[::1]" {
-		ip = "127.0.0.1"
+	return result
+}
+
+func splitHeader(values []string) []string {
+	result := make([]string, 0)
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				result = append(result, s)
+			}
+		}
 	}
-	return ip
+	return result
 }
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIpResolver(t *testing.T) {
+	resolver, err := NewClientIpResolver([]string{"10.0.0.0/8", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		// 不受信任的直连地址，忽略请求头
+		{"198.51.100.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "198.51.100.1"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "1.2.3.4"},
+		// 从右向左，停在第一个不受信任的地址
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		// 全部受信任时取最左边
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "garbage, 10.0.0.2"}, "10.0.0.2"},
+		{"10.0.0.1:1234", map[string]string{"X-Real-Ip": "1.2.3.4"}, "1.2.3.4"},
+		// Forwarded优先于X-Forwarded-For
+		{"10.0.0.1:1234", map[string]string{
+			"Forwarded":       `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`,
+			"X-Forwarded-For": "9.9.9.9",
+		}, "192.0.2.60"},
+		{"[2001:db8::2]:443", map[string]string{"Forwarded": `for="[2001:db9::1]"`}, "2001:db9::1"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "for=unknown"}, "10.0.0.1"},
+	}
+	for _, c := range cases {
+		r := &http.Request{RemoteAddr: c.remote, Header: http.Header{}}
+		for k, v := range c.headers {
+			r.Header.Set(k, v)
+		}
+		if got := resolver.ClientIp(r); got != c.want {
+			t.Errorf("remote %s headers %v: got %s, want %s", c.remote, c.headers, got, c.want)
+		}
+	}
+}
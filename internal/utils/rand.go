@@ -2,6 +2,7 @@ package utils
 
 import (
 	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"math/big"
 	rand2 "math/rand"
@@ -24,3 +25,15 @@ func RealRandInt(min int, max int) int {
 func FakeRandInt(min int, max int) int {
 	return rand2.Intn(max-min) + min
 }
+
+// RandomId 返回32位十六进制随机字符串，用于连接id、会话令牌等
+func RandomId() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("RandomId error: %v", err)
+		for i := range buf {
+			buf[i] = byte(FakeRandInt(0, 256))
+		}
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderSignatureTimestamp = "X-Signaling-Timestamp"
+	HeaderSignature          = "X-Signaling-Signature"
+)
+
+// SignPayload 使用HMAC-SHA256对 "timestamp.payload" 签名，返回 "sha256=<hex>"
+// 接收方应使用相同的secret和请求头中的时间戳重新计算，并校验时间戳防止重放
+func SignPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest 为出站的webhook请求设置时间戳和签名请求头，secret为空时不签名
+func SignRequest(req *http.Request, secret string, payload []byte) {
+	if secret == "" {
+		return
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set(HeaderSignatureTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, SignPayload(secret, timestamp, payload))
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignPayload(t *testing.T) {
+	// echo -n '1700000000.{"a":1}' | openssl dgst -sha256 -hmac secret
+	want := "sha256=49f24e537407743fa4a0242bb63b94b9a47ee99cbbe071ccd8a22550ae411686"
+	if got := SignPayload("secret", 1700000000, []byte(`{"a":1}`)); got != want {
+		t.Fatalf("SignPayload = %s, want %s", got, want)
+	}
+	if SignPayload("secret", 1700000001, []byte(`{"a":1}`)) == want {
+		t.Fatal("signature should cover the timestamp")
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	payload := []byte(`{"a":1}`)
+	r, _ := http.NewRequest(http.MethodPost, "http://example.com", nil)
+	SignRequest(r, "", payload)
+	if r.Header.Get(HeaderSignature) != "" || r.Header.Get(HeaderSignatureTimestamp) != "" {
+		t.Fatal("empty secret should not sign")
+	}
+
+	SignRequest(r, "secret", payload)
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderSignatureTimestamp), 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+		t.Fatalf("unexpected timestamp header %q", r.Header.Get(HeaderSignatureTimestamp))
+	}
+	if r.Header.Get(HeaderSignature) != SignPayload("secret", timestamp, payload) {
+		t.Fatalf("unexpected signature header %q", r.Header.Get(HeaderSignature))
+	}
+}
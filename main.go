@@ -3,22 +3,42 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/gin-gonic/gin"
 	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/handler/wslogic"
 	"github.com/peergoim/signaling-server/internal/server"
 	"github.com/peergoim/signaling-server/internal/svc"
-	"github.com/zeromicro/go-zero/core/conf"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 var configPath = flag.String("f", "etc/config.yaml", "config file path")
 
 func main() {
 	flag.Parse()
-	c := &config.Config{}
-	conf.MustLoad(*configPath, c)
-	err := c.Validate()
+	c, err := config.Load(*configPath)
 	if err != nil {
 		panic(fmt.Errorf("validate config file: %s \n", err))
 	}
+	c.MustSetup()
+	if c.Mode == "pro" {
+		gin.SetMode(gin.ReleaseMode)
+	}
 	ctx := svc.NewServiceContext(c)
-	server.NewWebSocketServer(ctx).Start()
+	// 配置文件变化或收到SIGHUP时重新加载
+	go config.NewWatcher(*configPath, c, ctx.UpdateConfig).Watch()
+	// 退出前投递缓冲中的事件，关闭审计日志
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		_ = ctx.Close()
+		os.Exit(0)
+	}()
+	ws, err := server.NewWebSocketServer(ctx, wslogic.New(ctx, wslogic.Hooks{}))
+	if err != nil {
+		panic(err)
+	}
+	ws.Start()
 }
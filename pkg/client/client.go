@@ -0,0 +1,482 @@
+// Package client 信令服务的Go客户端，既可以作为peer注册方法处理请求，也可以调用其他peer
+//
+//	c := client.New("ws://127.0.0.1:21480", client.Options{PeerId: "alice"})
+//	c.Handle("echo", func(ctx context.Context, request *client.CallRequest) ([]byte, error) {
+//		return request.Data, nil
+//	})
+//	if err := c.Connect(ctx); err != nil {
+//		...
+//	}
+//	defer c.Close()
+//	data, err := c.Call(ctx, "bob", "echo", []byte("hi"))
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/peergoim/signaling-server/internal/fragment"
+	"github.com/peergoim/signaling-server/internal/types"
+	"github.com/peergoim/signaling-server/internal/utils"
+	"google.golang.org/grpc/codes"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"nhooyr.io/websocket"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	CallRequest  = types.CallRequest
+	CallResponse = types.CallResponse
+)
+
+// 与服务端 handler.SessionTokenHeader、handler.MaxFrameHeader 一致
+const (
+	sessionTokenHeader = "X-Signaling-Session-Token"
+	maxFrameHeader     = "X-Signaling-Max-Frame"
+)
+
+// writeTimeout 单条消息的写入超时，超时后连接会被关闭并重连
+const writeTimeout = 10 * time.Second
+
+var (
+	ErrClosed           = errors.New("client closed")
+	ErrAlreadyConnected = errors.New("client already connected")
+	// ErrSessionReplaced 同一peerId的新连接上线，服务端踢掉了此连接，客户端不再重连
+	ErrSessionReplaced = errors.New("session replaced by a newer connection")
+	// ErrKicked 被管理接口踢下线，客户端不再重连
+	ErrKicked = errors.New("kicked by the server")
+)
+
+// StatusError 响应状态不是OK
+type StatusError struct {
+	Code codes.Code
+	Data []byte
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Data) == 0 {
+		return fmt.Sprintf("call failed: %s", e.Code)
+	}
+	return fmt.Sprintf("call failed: %s: %s", e.Code, e.Data)
+}
+
+// HandlerFunc 处理其他peer发来的请求；返回*StatusError时使用其状态码，其他错误返回Internal
+type HandlerFunc func(ctx context.Context, request *CallRequest) ([]byte, error)
+
+type Options struct {
+	PeerId   string
+	DeviceId string // 可选，同一peerId有多个连接时区分设备
+	Header   http.Header
+	// 连接时声明Handle注册的方法，调用方调用其他方法时服务端直接返回Unimplemented
+	AnnounceMethods bool
+	MinBackoff      time.Duration // 首次重连的等待时间，之后指数增长，默认500ms
+	MaxBackoff      time.Duration // 重连的最长等待时间，默认30s
+	MaxMessageSize  int           // 接收的单条消息Data的最大长度，默认16MB
+}
+
+type connection struct {
+	conn *websocket.Conn
+	// 服务端单个消息的最大长度，超过后拆分为分片发送，0为不拆分
+	maxFrame int
+	// 连接断开后关闭
+	closed chan struct{}
+}
+
+type Client struct {
+	url     string
+	options Options
+
+	handlersLock sync.RWMutex
+	handlers     map[string]HandlerFunc
+	// 没有注册处理函数的方法使用fallback，为nil时返回Unimplemented
+	fallback HandlerFunc
+
+	lock    sync.Mutex
+	current *connection
+	// 连接建立后关闭，断开后替换
+	connected    chan struct{}
+	sessionToken string
+	pending      map[string]chan *CallResponse
+
+	started atomic.Bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	err     error
+}
+
+// New serverUrl为服务端地址，如 ws://127.0.0.1:21480 或 https://example.com，连接时访问其 /ws 接口
+func New(serverUrl string, options Options) *Client {
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = 500 * time.Millisecond
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = 30 * time.Second
+	}
+	if options.MaxMessageSize <= 0 {
+		options.MaxMessageSize = 16 << 20
+	}
+	u := strings.TrimSuffix(serverUrl, "/")
+	if strings.HasPrefix(u, "http") {
+		u = "ws" + strings.TrimPrefix(u, "http")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		url:       u,
+		options:   options,
+		handlers:  make(map[string]HandlerFunc),
+		connected: make(chan struct{}),
+		pending:   make(map[string]chan *CallResponse),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+}
+
+// Handle 注册方法的处理函数，AnnounceMethods为true时需要在Connect之前注册
+func (c *Client) Handle(method string, fn HandlerFunc) {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	c.handlers[method] = fn
+}
+
+// HandleDefault 注册没有单独处理函数的方法使用的处理函数
+func (c *Client) HandleDefault(fn HandlerFunc) {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	c.fallback = fn
+}
+
+func (c *Client) handler(method string) (HandlerFunc, bool) {
+	c.handlersLock.RLock()
+	defer c.handlersLock.RUnlock()
+	if fn, ok := c.handlers[method]; ok {
+		return fn, true
+	}
+	return c.fallback, c.fallback != nil
+}
+
+// Connect 建立连接，之后断线时在后台自动重连，直到Close
+func (c *Client) Connect(ctx context.Context) error {
+	if !c.started.CompareAndSwap(false, true) {
+		return ErrAlreadyConnected
+	}
+	conn, err := c.dial(ctx)
+	if err != nil {
+		c.started.Store(false)
+		return err
+	}
+	go c.loop(conn)
+	return nil
+}
+
+// Close 关闭连接，不再重连；等待响应的调用返回ErrClosed
+func (c *Client) Close() error {
+	c.cancel()
+	c.lock.Lock()
+	current := c.current
+	c.lock.Unlock()
+	if current != nil {
+		_ = current.conn.Close(websocket.StatusNormalClosure, "")
+	}
+	if c.started.Load() {
+		<-c.done
+	}
+	return nil
+}
+
+// Done 客户端关闭或不再重连时关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Err 客户端停止的原因，如 ErrSessionReplaced、ErrKicked；Close关闭时为nil
+func (c *Client) Err() error {
+	<-c.ctx.Done()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+func (c *Client) dial(ctx context.Context) (*connection, error) {
+	query := url.Values{"peerId": {c.options.PeerId}, "fragmentation": {"true"}}
+	if c.options.DeviceId != "" {
+		query.Set("deviceId", c.options.DeviceId)
+	}
+	if c.options.AnnounceMethods {
+		c.handlersLock.RLock()
+		methods := make([]string, 0, len(c.handlers))
+		for method := range c.handlers {
+			methods = append(methods, method)
+		}
+		c.handlersLock.RUnlock()
+		sort.Strings(methods)
+		query.Set("methods", strings.Join(methods, ","))
+	}
+	c.lock.Lock()
+	if c.sessionToken != "" {
+		query.Set("resumeToken", c.sessionToken)
+	}
+	c.lock.Unlock()
+	conn, resp, err := websocket.Dial(ctx, c.url+"/ws?"+query.Encode(), &websocket.DialOptions{HTTPHeader: c.options.Header})
+	if err != nil {
+		return nil, err
+	}
+	// 服务端会拆分超过自身限制的消息，单个消息不会超过完整消息的长度
+	conn.SetReadLimit(int64(c.options.MaxMessageSize)/3*4 + 4096)
+	maxFrame, _ := strconv.Atoi(resp.Header.Get(maxFrameHeader))
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// 拨号期间客户端已关闭，Close看不到这个连接，需要在这里关闭
+	if c.ctx.Err() != nil {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+		return nil, ErrClosed
+	}
+	if token := resp.Header.Get(sessionTokenHeader); token != "" {
+		c.sessionToken = token
+	}
+	c.current = &connection{conn: conn, maxFrame: maxFrame, closed: make(chan struct{})}
+	close(c.connected)
+	return c.current, nil
+}
+
+// loop 处理连接上的消息，断开后按退避时间重连
+func (c *Client) loop(current *connection) {
+	defer close(c.done)
+	for {
+		err := c.serve(current.conn)
+		c.lock.Lock()
+		c.current = nil
+		c.connected = make(chan struct{})
+		c.lock.Unlock()
+		close(current.closed)
+		if c.ctx.Err() != nil {
+			return
+		}
+		var stopErr error
+		switch websocket.CloseStatus(err) {
+		case websocket.StatusCode(types.CloseSessionReplaced):
+			stopErr = ErrSessionReplaced
+		case websocket.StatusCode(types.CloseKicked):
+			stopErr = ErrKicked
+		}
+		if stopErr != nil {
+			c.lock.Lock()
+			c.err = stopErr
+			c.lock.Unlock()
+			c.cancel()
+			return
+		}
+		if current = c.reconnect(); current == nil {
+			return
+		}
+	}
+}
+
+func (c *Client) reconnect() *connection {
+	for attempt := 0; ; attempt++ {
+		backoff := c.options.MinBackoff << attempt
+		if backoff > c.options.MaxBackoff || backoff <= 0 {
+			backoff = c.options.MaxBackoff
+		}
+		// 随机抖动，避免服务端重启后所有客户端同时重连
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(backoff):
+		case <-c.ctx.Done():
+			return nil
+		}
+		if current, err := c.dial(c.ctx); err == nil {
+			return current
+		}
+	}
+}
+
+func (c *Client) serve(conn *websocket.Conn) error {
+	reassembler := fragment.NewReassembler(c.options.MaxMessageSize, c.options.MaxMessageSize*2, 30*time.Second)
+	for {
+		// 不使用c.ctx：ctx取消时连接会以PolicyViolation关闭，服务端会保留会话等待恢复；
+		// Close以NormalClosure关闭连接来结束读取
+		typ, data, err := conn.Read(context.Background())
+		if err != nil {
+			return err
+		}
+		if typ == websocket.MessageText {
+			response := &CallResponse{}
+			if err = response.FromBytes(data); err != nil {
+				continue
+			}
+			if response.Fragment != nil {
+				data, complete, err := reassembler.Add("response/"+response.CallId, response.Fragment, response.Data)
+				if err != nil {
+					response = &CallResponse{CallId: response.CallId, Method: response.Method, Status: codes.ResourceExhausted, Data: []byte(err.Error())}
+				} else if !complete {
+					continue
+				} else {
+					response.Data, response.Fragment = data, nil
+				}
+			}
+			c.reply(response)
+			continue
+		}
+		request := &CallRequest{}
+		if err = request.FromBytes(data); err != nil {
+			continue
+		}
+		if request.Fragment != nil {
+			data, complete, err := reassembler.Add("request/"+request.CallId, request.Fragment, request.Data)
+			if err != nil {
+				go c.respond(&CallResponse{CallId: request.CallId, Method: request.Method, Status: codes.ResourceExhausted, Data: []byte(err.Error())})
+				continue
+			}
+			if !complete {
+				continue
+			}
+			request.Data, request.Fragment = data, nil
+		}
+		go c.handle(request)
+	}
+}
+
+func (c *Client) reply(response *CallResponse) {
+	c.lock.Lock()
+	ch, ok := c.pending[response.CallId]
+	delete(c.pending, response.CallId)
+	c.lock.Unlock()
+	if ok {
+		ch <- response
+	}
+}
+
+func (c *Client) handle(request *CallRequest) {
+	response := &CallResponse{CallId: request.CallId, Method: request.Method, Status: codes.OK}
+	fn, ok := c.handler(request.Method)
+	if !ok {
+		response.Status = codes.Unimplemented
+	} else {
+		data, err := c.invokeHandler(fn, request)
+		var statusErr *StatusError
+		switch {
+		case errors.As(err, &statusErr):
+			response.Status, response.Data = statusErr.Code, statusErr.Data
+		case err != nil:
+			response.Status, response.Data = codes.Internal, []byte(err.Error())
+		default:
+			response.Data = data
+		}
+	}
+	c.respond(response)
+}
+
+func (c *Client) invokeHandler(fn HandlerFunc, request *CallRequest) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	return fn(c.ctx, request)
+}
+
+// respond 在当前连接上返回响应，断线时等待重连
+func (c *Client) respond(response *CallResponse) {
+	_ = c.send(c.ctx, types.FrameResponse, response.ToBytes())
+}
+
+// send 等待连接可用后发送，连接在发送时断开则等待重连后重试
+func (c *Client) send(ctx context.Context, typ types.FrameType, data []byte) error {
+	messageType := websocket.MessageBinary
+	if typ == types.FrameResponse {
+		messageType = websocket.MessageText
+	}
+	for {
+		c.lock.Lock()
+		current, connected := c.current, c.connected
+		c.lock.Unlock()
+		if current == nil {
+			select {
+			case <-connected:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-c.ctx.Done():
+				return ErrClosed
+			}
+		}
+		frames := [][]byte{data}
+		if current.maxFrame > 0 {
+			var err error
+			if frames, err = fragment.Split(typ, data, current.maxFrame); err != nil {
+				return err
+			}
+		}
+		if err := writeFrames(current.conn, messageType, frames); err == nil {
+			return nil
+		}
+		// 连接已断开，等待loop重连后重试
+		select {
+		case <-current.closed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.ctx.Done():
+			return ErrClosed
+		}
+	}
+}
+
+// writeFrames 写入不使用调用方的ctx：写入时ctx取消会关闭整个连接，影响其他调用
+func writeFrames(conn *websocket.Conn, messageType websocket.MessageType, frames [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	for _, frame := range frames {
+		if err := conn.Write(ctx, messageType, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invoke 发送请求并等待响应，CallId为空时自动生成；断线期间等待重连，直到ctx结束
+func (c *Client) Invoke(ctx context.Context, request *CallRequest) (*CallResponse, error) {
+	if request.CallId == "" {
+		request.CallId = utils.RandomId()
+	}
+	ch := make(chan *CallResponse, 1)
+	c.lock.Lock()
+	c.pending[request.CallId] = ch
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, request.CallId)
+		c.lock.Unlock()
+	}()
+	if err := c.send(ctx, types.FrameRequest, request.ToBytes()); err != nil {
+		return nil, err
+	}
+	select {
+	case response := <-ch:
+		return response, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, ErrClosed
+	}
+}
+
+// Call 调用peer的方法，peerId可以是 peerId/deviceId 指定设备；响应状态不是OK时返回*StatusError
+func (c *Client) Call(ctx context.Context, peerId string, method string, data []byte) ([]byte, error) {
+	response, err := c.Invoke(ctx, &CallRequest{PeerId: peerId, Method: method, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	if response.Status != codes.OK {
+		return nil, &StatusError{Code: response.Status, Data: response.Data}
+	}
+	return response.Data, nil
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/peergoim/signaling-server/pkg/signaling"
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/logx"
+	"google.golang.org/grpc/codes"
+	"net/http/httptest"
+	"nhooyr.io/websocket"
+	"os"
+	"testing"
+	"time"
+)
+
+var serverUrl string
+
+func TestMain(m *testing.M) {
+	logx.Disable()
+	c := &signaling.Config{}
+	yaml := "Mode: pro\nWebSocket:\n  CallTimeout: 2\n  Resume:\n    Enabled: true\n  Limits:\n    MaxInboundFrame: 4096\n"
+	if err := conf.LoadFromYamlBytes([]byte(yaml), c); err != nil {
+		panic(err)
+	}
+	server, err := signaling.New(c)
+	if err != nil {
+		panic(err)
+	}
+	s := httptest.NewServer(server)
+	serverUrl = s.URL
+	code := m.Run()
+	s.Close()
+	os.Exit(code)
+}
+
+func newTestClient(t *testing.T, peerId string, handlers map[string]HandlerFunc) *Client {
+	t.Helper()
+	c := New(serverUrl, Options{PeerId: peerId, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	for method, fn := range handlers {
+		c.Handle(method, fn)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("connect %s: %v", peerId, err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func echo(_ context.Context, request *CallRequest) ([]byte, error) {
+	return request.Data, nil
+}
+
+func callContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestCall(t *testing.T) {
+	newTestClient(t, "call-callee", map[string]HandlerFunc{
+		"echo": echo,
+		"fail": func(context.Context, *CallRequest) ([]byte, error) {
+			return nil, &StatusError{Code: codes.PermissionDenied, Data: []byte("denied")}
+		},
+	})
+	caller := newTestClient(t, "call-caller", nil)
+	ctx := callContext(t)
+
+	data, err := caller.Call(ctx, "call-callee", "echo", []byte("hello"))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("echo: %q, %v", data, err)
+	}
+	var statusErr *StatusError
+	if _, err = caller.Call(ctx, "call-callee", "fail", nil); !errors.As(err, &statusErr) || statusErr.Code != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+	if _, err = caller.Call(ctx, "call-callee", "missing", nil); !errors.As(err, &statusErr) || statusErr.Code != codes.Unimplemented {
+		t.Fatalf("expected Unimplemented, got %v", err)
+	}
+	if _, err = caller.Call(ctx, "call-nobody", "echo", nil); !errors.As(err, &statusErr) || statusErr.Code != codes.Unavailable {
+		t.Fatalf("expected Unavailable for an offline peer, got %v", err)
+	}
+}
+
+func TestCallDeadline(t *testing.T) {
+	newTestClient(t, "deadline-callee", map[string]HandlerFunc{
+		"slow": func(ctx context.Context, request *CallRequest) ([]byte, error) {
+			time.Sleep(500 * time.Millisecond)
+			return nil, nil
+		},
+	})
+	caller := newTestClient(t, "deadline-caller", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := caller.Call(ctx, "deadline-callee", "slow", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestCallLargePayload(t *testing.T) {
+	newTestClient(t, "large-callee", map[string]HandlerFunc{"echo": echo})
+	caller := newTestClient(t, "large-caller", nil)
+	// 超过服务端的 MaxInboundFrame，请求和响应都需要分片
+	payload := bytes.Repeat([]byte("0123456789"), 10000)
+	data, err := caller.Call(callContext(t), "large-callee", "echo", payload)
+	if err != nil || !bytes.Equal(data, payload) {
+		t.Fatalf("large echo: %d bytes, %v", len(data), err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	callee := newTestClient(t, "reconnect-callee", map[string]HandlerFunc{"echo": echo})
+	caller := newTestClient(t, "reconnect-caller", nil)
+	callee.lock.Lock()
+	current := callee.current
+	callee.lock.Unlock()
+	// 异常断开，客户端按退避时间重连并恢复会话
+	_ = current.conn.Close(websocket.StatusInternalError, "test")
+	<-current.closed
+	data, err := caller.Call(callContext(t), "reconnect-callee", "echo", []byte("again"))
+	if err != nil || string(data) != "again" {
+		t.Fatalf("call after reconnect: %q, %v", data, err)
+	}
+}
+
+func TestCloseFailsPendingCalls(t *testing.T) {
+	caller := newTestClient(t, "close-caller", nil)
+	newTestClient(t, "close-callee", map[string]HandlerFunc{
+		"block": func(ctx context.Context, request *CallRequest) ([]byte, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	errs := make(chan error, 1)
+	go func() {
+		_, err := caller.Call(callContext(t), "close-callee", "block", nil)
+		errs <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	_ = caller.Close()
+	if err := <-errs; !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
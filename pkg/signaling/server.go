@@ -0,0 +1,138 @@
+// Package signaling 可嵌入的信令服务端，作为http.Handler挂载到已有的http服务上
+//
+//	c, err := signaling.LoadConfig("etc/config.yaml")
+//	...
+//	s, err := signaling.New(c,
+//		signaling.WithOnConnect(func(conn *signaling.PeerConnection) { ... }),
+//		signaling.WithCallInterceptor(func(ctx context.Context, request *signaling.CallRequest, next signaling.CallHandler) (*signaling.CallResponse, error) {
+//			return next(ctx, request)
+//		}),
+//	)
+//	mux.Handle("/signaling/", http.StripPrefix("/signaling", s))
+//
+// 每个Server有独立的连接、会话和虚拟peer，同一进程中可以创建多个。
+// 日志、链路追踪和gin的运行模式由嵌入方设置，New不会调用 Config.MustSetup；WebSocket.ListenOn 不被使用
+package signaling
+
+import (
+	"context"
+	"github.com/peergoim/signaling-server/internal/config"
+	"github.com/peergoim/signaling-server/internal/handler/wslogic"
+	"github.com/peergoim/signaling-server/internal/server"
+	"github.com/peergoim/signaling-server/internal/svc"
+	"github.com/peergoim/signaling-server/internal/types"
+	"github.com/zeromicro/go-zero/core/conf"
+	"net/http"
+)
+
+type (
+	Config          = config.Config
+	CallRequest     = types.CallRequest
+	CallResponse    = types.CallResponse
+	PeerConnection  = types.PeerConnection
+	CallHandler     = wslogic.CallHandler
+	CallInterceptor = wslogic.CallInterceptor
+)
+
+// Option 创建Server时的可选项
+type Option func(hooks *wslogic.Hooks)
+
+// WithOnConnect peer上线后调用，断线后恢复会话不会再次调用
+func WithOnConnect(fn func(conn *PeerConnection)) Option {
+	return func(hooks *wslogic.Hooks) {
+		hooks.OnConnect = fn
+	}
+}
+
+// WithOnDisconnect peer下线后调用
+func WithOnDisconnect(fn func(conn *PeerConnection)) Option {
+	return func(hooks *wslogic.Hooks) {
+		hooks.OnDisconnect = fn
+	}
+}
+
+// WithCallInterceptor 追加请求拦截器，按追加顺序从外到内包裹请求的处理
+// 拦截器可以修改请求和响应，也可以不调用next直接返回响应；
+// 内置的日志、指标和权限（CallAuth配置）拦截器在最外层，被拒绝的请求不会到达这里
+func WithCallInterceptor(interceptors ...CallInterceptor) Option {
+	return func(hooks *wslogic.Hooks) {
+		hooks.Interceptors = append(hooks.Interceptors, interceptors...)
+	}
+}
+
+// WithServerMethod 注册由服务端处理的方法，name不带 $server. 前缀时自动补全，同名时替换内置方法
+// peer调用时peerId可以为空
+func WithServerMethod(name string, fn CallHandler) Option {
+	return func(hooks *wslogic.Hooks) {
+		if hooks.ServerMethods == nil {
+			hooks.ServerMethods = make(map[string]CallHandler)
+		}
+		hooks.ServerMethods[name] = fn
+	}
+}
+
+// CallerFrom 在拦截器中获取调用方的连接，匿名调用（/call 接口）时返回false
+func CallerFrom(ctx context.Context) (*PeerConnection, bool) {
+	return wslogic.CallerFrom(ctx)
+}
+
+// LoadConfig 加载yaml或json配置文件并校验
+func LoadConfig(path string) (*Config, error) {
+	return config.Load(path)
+}
+
+// DefaultConfig 所有配置项使用默认值
+func DefaultConfig() *Config {
+	c := &Config{}
+	_ = conf.LoadFromYamlBytes([]byte("WebSocket: {}\n"), c)
+	return c
+}
+
+type Server struct {
+	svcCtx  *svc.ServiceContext
+	logic   *wslogic.Logic
+	handler http.Handler
+}
+
+// New 校验配置并创建服务端，不再使用时调用 Close
+func New(c *Config, options ...Option) (*Server, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	hooks := wslogic.Hooks{}
+	for _, option := range options {
+		option(&hooks)
+	}
+	svcCtx := svc.NewServiceContext(c)
+	logic := wslogic.New(svcCtx, hooks)
+	ws, err := server.NewWebSocketServer(svcCtx, logic)
+	if err != nil {
+		_ = svcCtx.Close()
+		return nil, err
+	}
+	return &Server{
+		svcCtx:  svcCtx,
+		logic:   logic,
+		handler: ws.Handler(),
+	}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// UpdateConfig 校验并替换配置，与配置文件热加载相同，部分配置项修改后需要重新创建Server
+func (s *Server) UpdateConfig(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	s.svcCtx.UpdateConfig(s.svcCtx.Config(), c)
+	return nil
+}
+
+// Close 结束保留中的会话，投递缓冲中的事件并关闭审计日志，不会断开在线的连接
+// 嵌入方应先关闭自己的http服务，再调用Close
+func (s *Server) Close() error {
+	s.logic.Close()
+	return s.svcCtx.Close()
+}
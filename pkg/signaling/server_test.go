@@ -0,0 +1,136 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/peergoim/signaling-server/pkg/client"
+	"github.com/zeromicro/go-zero/core/logx"
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logx.Disable()
+	gin.SetMode(gin.ReleaseMode)
+	os.Exit(m.Run())
+}
+
+func startServer(t *testing.T, options ...Option) string {
+	t.Helper()
+	c := DefaultConfig()
+	c.Mode = "pro"
+	c.WebSocket.CallTimeout = 2
+	s, err := New(c, options...)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	// 挂载在已有mux的前缀下
+	mux := http.NewServeMux()
+	mux.Handle("/signaling/", http.StripPrefix("/signaling", s))
+	httpServer := httptest.NewServer(mux)
+	t.Cleanup(httpServer.Close)
+	return httpServer.URL + "/signaling"
+}
+
+func connect(t *testing.T, serverUrl string, peerId string) *client.Client {
+	t.Helper()
+	c := client.New(serverUrl, client.Options{PeerId: peerId, MinBackoff: 10 * time.Millisecond})
+	c.Handle("echo", func(ctx context.Context, request *client.CallRequest) ([]byte, error) {
+		return request.Data, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("connect %s: %v", peerId, err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	a, b := startServer(t), startServer(t)
+	connect(t, a, "alice")
+	caller := connect(t, b, "caller")
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var statusErr *client.StatusError
+	if _, err := caller.Call(ctx, "alice", "echo", nil); !errors.As(err, &statusErr) || statusErr.Code != codes.Unavailable {
+		t.Fatalf("expected alice to be offline on the other server, got %v", err)
+	}
+	connect(t, b, "alice")
+	if data, err := caller.Call(ctx, "alice", "echo", []byte("hi")); err != nil || string(data) != "hi" {
+		t.Fatalf("echo: %q, %v", data, err)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	connected := make(chan string, 4)
+	disconnected := make(chan string, 4)
+	serverUrl := startServer(t,
+		WithOnConnect(func(conn *PeerConnection) { connected <- conn.PeerId }),
+		WithOnDisconnect(func(conn *PeerConnection) { disconnected <- conn.PeerId }),
+		WithCallInterceptor(
+			// 拒绝调用 secret 方法
+			func(ctx context.Context, request *CallRequest, next CallHandler) (*CallResponse, error) {
+				if request.Method == "secret" {
+					return &CallResponse{Status: codes.PermissionDenied, Data: []byte("denied")}, nil
+				}
+				return next(ctx, request)
+			},
+			// 修改请求和响应
+			func(ctx context.Context, request *CallRequest, next CallHandler) (*CallResponse, error) {
+				request.Data = append([]byte("in:"), request.Data...)
+				resp, err := next(ctx, request)
+				if err == nil {
+					resp.Data = append(resp.Data, []byte(":out")...)
+				}
+				return resp, err
+			},
+		),
+	)
+	callee := connect(t, serverUrl, "callee")
+	caller := connect(t, serverUrl, "caller")
+	if a, b := <-connected, <-connected; a+","+b != "callee,caller" && a+","+b != "caller,callee" {
+		t.Fatalf("unexpected OnConnect calls: %s, %s", a, b)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if data, err := caller.Call(ctx, "callee", "echo", []byte("hi")); err != nil || string(data) != "in:hi:out" {
+		t.Fatalf("echo: %q, %v", data, err)
+	}
+	var statusErr *client.StatusError
+	if _, err := caller.Call(ctx, "callee", "secret", nil); !errors.As(err, &statusErr) || statusErr.Code != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+	_ = callee.Close()
+	select {
+	case peerId := <-disconnected:
+		if peerId != "callee" {
+			t.Fatalf("expected callee to disconnect, got %s", peerId)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnect not called")
+	}
+}
+
+func TestNewKeepsGinMode(t *testing.T) {
+	defer gin.SetMode(gin.Mode())
+	gin.SetMode(gin.TestMode)
+	c := DefaultConfig()
+	c.Mode = "dev"
+	s, err := New(c)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	if gin.Mode() != gin.TestMode {
+		t.Fatalf("New should not change the global gin mode, got %s", gin.Mode())
+	}
+	if err = s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}